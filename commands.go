@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/megamon/core/leaks/content"
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/leaks/retention"
+	"github.com/megamon/core/leaks/ruleset"
+	"github.com/megamon/core/utils"
+)
+
+const usage = `usage: megamon [command]
+
+without command megamon starts the web server
+
+commands:
+  rules import [-format yaml|gitleaks] [-conflict skip|replace|fail] FILE
+  rules export [-format yaml|gitleaks] [-out FILE]
+  migrate up [VERSION]
+  migrate down [STEPS]
+  migrate status
+  content migrate [-from flat|fs|s3] [-keep]
+  purge [-dry-run]
+`
+
+//runCommand : execute cli command, only migrations need the manager itself
+func runCommand(manager *models.Manager, args []string) (err error) {
+	if len(args) > 0 && args[0] == "migrate" {
+		return migrateCommand(manager, args[1:])
+	}
+
+	if len(args) > 0 && args[0] == "purge" {
+		return purgeCommand(manager, args[1:])
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf(usage)
+	}
+
+	switch args[0] + " " + args[1] {
+	case "rules import":
+		return importRulesCommand(manager, args[2:])
+	case "rules export":
+		return exportRulesCommand(manager, args[2:])
+	case "content migrate":
+		return migrateContentCommand(args[2:])
+	}
+
+	return fmt.Errorf(usage)
+}
+
+func importRulesCommand(manager models.Storage, args []string) (err error) {
+	flags := flag.NewFlagSet("rules import", flag.ContinueOnError)
+	format := flags.String("format", ruleset.FormatYAML, "rule set format: yaml or gitleaks")
+	conflict := flags.String("conflict", ruleset.ConflictSkip, "duplicate handling: skip, replace or fail")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("rules import: expected exactly one file")
+	}
+
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return
+	}
+
+	set, err := ruleset.Parse(*format, data)
+	if err != nil {
+		return
+	}
+
+	summary, err := ruleset.Apply(manager, set, *conflict)
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("rules: %d added, %d updated, %d skipped\n", summary.RulesAdded, summary.RulesUpdated, summary.RulesSkipped)
+	fmt.Printf("keywords: %d added, %d updated, %d skipped\n", summary.KeywordsAdded, summary.KeywordsUpdated, summary.KeywordsSkipped)
+	for _, skipped := range summary.Skipped {
+		fmt.Printf("not imported: %s\n", skipped)
+	}
+	return
+}
+
+func exportRulesCommand(manager models.Storage, args []string) (err error) {
+	flags := flag.NewFlagSet("rules export", flag.ContinueOnError)
+	format := flags.String("format", ruleset.FormatYAML, "rule set format: yaml or gitleaks")
+	out := flags.String("out", "", "output file, stdout by default")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	set, err := ruleset.Load(manager)
+	if err != nil {
+		return
+	}
+
+	data, err := ruleset.Marshal(*format, set)
+	if err != nil {
+		return
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+		return
+	}
+
+	return ioutil.WriteFile(*out, data, 0644)
+}
+
+//migrateCommand : without arguments migrates to the latest version
+func migrateCommand(manager *models.Manager, args []string) (err error) {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	number := 0
+	if len(args) > 1 {
+		number, err = strconv.Atoi(args[1])
+		if err != nil || number < 0 {
+			return fmt.Errorf("migrate %s: invalid number %q", action, args[1])
+		}
+	}
+
+	switch action {
+	case "up":
+		err = manager.MigrateUp(number)
+	case "down":
+		if number == 0 {
+			number = 1
+		}
+		err = manager.MigrateDown(number)
+	case "status":
+	default:
+		return fmt.Errorf(usage)
+	}
+
+	if err != nil {
+		return
+	}
+
+	states, err := manager.MigrationStatus()
+	if err != nil {
+		return
+	}
+
+	for _, state := range states {
+		applied := "pending"
+		if state.Applied > 0 {
+			applied = time.Unix(state.Applied, 0).Format(time.RFC3339)
+		}
+		fmt.Printf("%03d %-20s %s\n", state.Version, state.Name, applied)
+	}
+	return
+}
+
+//migrateContentCommand : move content from the old store into the configured one
+func migrateContentCommand(args []string) (err error) {
+	flags := flag.NewFlagSet("content migrate", flag.ContinueOnError)
+	from := flags.String("from", content.TypeFlat, "source store type: flat, fs or s3")
+	keep := flags.Bool("keep", false, "keep content in the source store")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	//unset type stays flat while only legacy files exist, the destination has to be set explicitly
+	settings := utils.Settings.ContentStore
+	if *from == content.StoreType(settings, utils.Settings.LeakGlobals.ContentDir) {
+		return fmt.Errorf("content migrate: source & destination are the same store, set content_store.type")
+	}
+
+	dst, err := content.Default()
+	if err != nil {
+		return
+	}
+
+	settings.Type = *from
+	src, err := content.Open(settings, utils.Settings.LeakGlobals.ContentDir)
+	if err != nil {
+		return
+	}
+
+	moved, err := content.Migrate(src, dst, !*keep)
+	fmt.Printf("content: %d items migrated\n", moved)
+	return
+}
+
+//purgeCommand : apply retention policies once
+func purgeCommand(manager models.Storage, args []string) (err error) {
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only print what would be purged")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	result, err := retention.RunOnce(manager, *dryRun)
+	if err != nil {
+		return
+	}
+
+	for _, item := range result.Items {
+		fmt.Printf("%-9s report %d (%s, %s, %s): %d fragments\n", item.Action, item.ReportID, item.Type, item.Status,
+			time.Unix(item.Time, 0).Format(time.RFC3339), item.Fragments)
+	}
+
+	prefix := "purged"
+	if *dryRun {
+		prefix = "would purge"
+	}
+	fmt.Printf("%s: %d contents, %d fragments, %d reports\n", prefix, result.Contents, result.Fragments, result.Reports)
+	return
+}
@@ -0,0 +1,126 @@
+package allowlist
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/megamon/core/leaks/models"
+)
+
+//Item : properties of a search result checked against the allowlist
+type Item struct {
+	Repo    string
+	Owner   string
+	ShaHash string
+	Path    string
+}
+
+//Allowlist : compiled set of allowlist entries
+type Allowlist struct {
+	entries []models.AllowlistEntry
+	globs   map[int]*regexp.Regexp
+}
+
+//New : compile allowlist entries
+func New(entries []models.AllowlistEntry) (list *Allowlist, err error) {
+	list = &Allowlist{entries: entries, globs: make(map[int]*regexp.Regexp)}
+	for _, entry := range entries {
+		err = Validate(entry)
+		if err != nil {
+			return
+		}
+
+		if entry.Type == models.ALLOWPATH {
+			list.globs[entry.ID] = compileGlob(entry.Value)
+		}
+	}
+	return
+}
+
+//Load : read allowlist from the database
+func Load(manager models.Storage) (list *Allowlist, err error) {
+	entries, err := manager.SelectAllowlist()
+	if err != nil {
+		return
+	}
+	return New(entries)
+}
+
+//Validate : check entry type & value
+func Validate(entry models.AllowlistEntry) (err error) {
+	if strings.TrimSpace(entry.Value) == "" {
+		return fmt.Errorf("allowlist entry value is empty")
+	}
+
+	switch entry.Type {
+	case models.ALLOWREPO:
+		if !strings.Contains(entry.Value, "/") {
+			return fmt.Errorf("allowlist repo must be in owner/name format: %s", entry.Value)
+		}
+	case models.ALLOWOWNER, models.ALLOWSHA, models.ALLOWPATH:
+	default:
+		return fmt.Errorf("unknown allowlist entry type: %s", entry.Type)
+	}
+	return
+}
+
+//Match : return the first entry the item matches
+func (list *Allowlist) Match(item Item) (entry models.AllowlistEntry, ok bool) {
+	if list == nil {
+		return
+	}
+
+	for _, entry = range list.entries {
+		switch entry.Type {
+		case models.ALLOWREPO:
+			ok = item.Repo != "" && strings.EqualFold(entry.Value, item.Repo)
+		case models.ALLOWOWNER:
+			ok = item.Owner != "" && strings.EqualFold(entry.Value, item.Owner)
+		case models.ALLOWSHA:
+			ok = item.ShaHash != "" && strings.EqualFold(entry.Value, item.ShaHash)
+		case models.ALLOWPATH:
+			ok = item.Path != "" && matchGlob(list.globs[entry.ID], entry.Value, item.Path)
+		}
+
+		if ok {
+			return
+		}
+	}
+	return models.AllowlistEntry{}, false
+}
+
+//matchGlob : globs without slash match the file name, others the whole path
+func matchGlob(expr *regexp.Regexp, glob, filePath string) bool {
+	if !strings.Contains(glob, "/") {
+		filePath = path.Base(filePath)
+	}
+	return expr.MatchString(strings.TrimPrefix(filePath, "/"))
+}
+
+//compileGlob : "**" matches any number of directories, "*" & "?" stay inside one
+func compileGlob(glob string) *regexp.Regexp {
+	var builder strings.Builder
+	glob = strings.TrimPrefix(glob, "/")
+
+	builder.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				builder.WriteString(".*")
+				i++
+			} else {
+				builder.WriteString("[^/]*")
+			}
+		case '?':
+			builder.WriteString("[^/]")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+	builder.WriteString("$")
+
+	return regexp.MustCompile(builder.String())
+}
@@ -0,0 +1,59 @@
+package allowlist
+
+import (
+	"testing"
+
+	"github.com/megamon/core/leaks/models"
+)
+
+func TestMatch(t *testing.T) {
+	entries := []models.AllowlistEntry{
+		{ID: 1, Type: models.ALLOWREPO, Value: "megamon/megamon"},
+		{ID: 2, Type: models.ALLOWOWNER, Value: "vendor"},
+		{ID: 3, Type: models.ALLOWSHA, Value: "0123abcd"},
+		{ID: 4, Type: models.ALLOWPATH, Value: "*_test.go"},
+		{ID: 5, Type: models.ALLOWPATH, Value: "third_party/**/LICENSE"},
+	}
+
+	list, err := New(entries)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	cases := []struct {
+		item Item
+		id   int
+	}{
+		{Item{Repo: "Megamon/Megamon", Owner: "megamon"}, 1},
+		{Item{Repo: "vendor/lib", Owner: "Vendor"}, 2},
+		{Item{Repo: "other/lib", ShaHash: "0123ABCD"}, 3},
+		{Item{Repo: "other/lib", Path: "pkg/db/db_test.go"}, 4},
+		{Item{Repo: "other/lib", Path: "third_party/a/b/LICENSE"}, 5},
+		{Item{Repo: "other/lib", Path: "LICENSE"}, 0},
+		{Item{Repo: "other/lib", Path: "pkg/db/db.go"}, 0},
+	}
+
+	for _, c := range cases {
+		entry, ok := list.Match(c.item)
+		if ok != (c.id != 0) || entry.ID != c.id {
+			t.Errorf("Item %v: expected entry %d, got %d (%v)", c.item, c.id, entry.ID, ok)
+		}
+	}
+	return
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []models.AllowlistEntry{
+		{Type: models.ALLOWREPO, Value: "megamon"},
+		{Type: models.ALLOWOWNER, Value: " "},
+		{Type: "unknown", Value: "value"},
+	}
+
+	for _, entry := range invalid {
+		if Validate(entry) == nil {
+			t.Errorf("Expected validation error for %v", entry)
+		}
+	}
+	return
+}
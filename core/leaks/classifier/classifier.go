@@ -0,0 +1,146 @@
+package classifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/megamon/core/leaks/expr"
+)
+
+const (
+	//FalsePositive : class of manually rejected fragments
+	FalsePositive = 0
+
+	//Leak : class of verified fragments
+	Leak = 1
+)
+
+//Version : version of the model file format
+const Version = 1
+
+//Sample : labelled text
+type Sample struct {
+	Text string
+	Leak bool
+}
+
+//Model : multinomial naive Bayes model over fragment tokens
+type Model struct {
+	Version int               `json:"version"`
+	Trained int64             `json:"trained"`
+	Docs    [2]int            `json:"docs"`
+	Tokens  [2]int            `json:"tokens"`
+	Counts  map[string][2]int `json:"counts"`
+	Report  *Report           `json:"report,omitempty"`
+}
+
+var identExpr = regexp.MustCompile(`[a-z0-9_]+`)
+var wordExpr = regexp.MustCompile(`[a-z0-9]+`)
+
+//Tokenize : split text into lowercase words & add entropy feature
+//Identifiers like db_password produce both the whole word and its parts
+func Tokenize(text string) (tokens []string) {
+	lower := strings.ToLower(text)
+	for _, word := range identExpr.FindAllString(lower, -1) {
+		if len(word) < 2 || len(word) > 40 {
+			continue
+		}
+		tokens = append(tokens, word)
+
+		if strings.Contains(word, "_") {
+			for _, part := range wordExpr.FindAllString(word, -1) {
+				if len(part) > 1 {
+					tokens = append(tokens, part)
+				}
+			}
+		}
+	}
+
+	entropy := int(expr.MaxTokenEntropy(text))
+	tokens = append(tokens, "__entropy_"+strconv.Itoa(entropy))
+	return
+}
+
+//Train : fit model on labelled samples
+func Train(samples []Sample) (model *Model) {
+	model = &Model{Version: Version, Counts: make(map[string][2]int)}
+	for _, sample := range samples {
+		class := FalsePositive
+		if sample.Leak {
+			class = Leak
+		}
+
+		model.Docs[class]++
+		for _, token := range Tokenize(sample.Text) {
+			counts := model.Counts[token]
+			counts[class]++
+			model.Counts[token] = counts
+			model.Tokens[class]++
+		}
+	}
+	return
+}
+
+//Predict : probability of the text being a leak
+//Untrained model (no samples of either class) returns 0.5
+func (model *Model) Predict(text string) float64 {
+	if model.Docs[FalsePositive] == 0 || model.Docs[Leak] == 0 {
+		return 0.5
+	}
+
+	total := float64(model.Docs[FalsePositive] + model.Docs[Leak])
+	vocabulary := float64(len(model.Counts))
+
+	var logProb [2]float64
+	for class := range logProb {
+		logProb[class] = math.Log(float64(model.Docs[class]) / total)
+	}
+
+	for _, token := range Tokenize(text) {
+		counts, ok := model.Counts[token]
+		if !ok {
+			continue
+		}
+
+		for class := range logProb {
+			//Laplace smoothing
+			logProb[class] += math.Log((float64(counts[class]) + 1) / (float64(model.Tokens[class]) + vocabulary))
+		}
+	}
+
+	//P(leak) = 1 / (1 + exp(logP(fp) - logP(leak)))
+	return 1 / (1 + math.Exp(logProb[FalsePositive]-logProb[Leak]))
+}
+
+//Save : write model to the file
+func (model *Model) Save(filename string) (err error) {
+	data, err := json.Marshal(model)
+	if err != nil {
+		return
+	}
+
+	tmp := filename + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+//Load : read model from the file
+func Load(filename string) (model *Model, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	model = &Model{}
+	err = json.Unmarshal(data, model)
+	return
+}
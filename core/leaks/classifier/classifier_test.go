@@ -0,0 +1,106 @@
+package classifier
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func trainingSamples() (samples []Sample) {
+	for i := 0; i < 20; i++ {
+		samples = append(samples,
+			Sample{Text: fmt.Sprintf("db_password = \"Xk9%dqLm2vRz8TnW\"", i), Leak: true},
+			Sample{Text: fmt.Sprintf("aws_secret_key: \"AKIA%dQWERTYUIOPZX\"", i), Leak: true},
+			Sample{Text: fmt.Sprintf("password_hash = hash(example_%d)", i), Leak: false},
+			Sample{Text: fmt.Sprintf("// reset password form, see test %d", i), Leak: false},
+		)
+	}
+	return
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize("DB_Password = x")
+	expected := map[string]bool{"db_password": false, "db": false, "password": false}
+	for _, token := range tokens {
+		if _, ok := expected[token]; ok {
+			expected[token] = true
+		}
+	}
+
+	for token, found := range expected {
+		if !found {
+			t.Errorf("Expected token %s in %v", token, tokens)
+		}
+	}
+
+	if last := tokens[len(tokens)-1]; last[:10] != "__entropy_" {
+		t.Errorf("Expected entropy feature, got: %s", last)
+	}
+	return
+}
+
+func TestPredict(t *testing.T) {
+	if p := Train(nil).Predict("password"); p != 0.5 {
+		t.Errorf("Untrained model must return 0.5, got: %f", p)
+	}
+
+	model := Train(trainingSamples())
+	if p := model.Predict("mysql_password = \"Pq7rT2vXz9LmW4kN\""); p < Threshold {
+		t.Errorf("Expected leak, got probability: %f", p)
+	}
+
+	if p := model.Predict("password_hash = hash(example)"); p >= Threshold {
+		t.Errorf("Expected false positive, got probability: %f", p)
+	}
+	return
+}
+
+func TestEvaluate(t *testing.T) {
+	report := Evaluate(trainingSamples(), Folds)
+	if report.Samples != 80 || report.Leaks != 40 {
+		t.Errorf("Wrong sample counts: %d %d", report.Samples, report.Leaks)
+	}
+
+	if report.TruePositives+report.FalsePositives+report.TrueNegatives+report.FalseNegatives != report.Samples {
+		t.Errorf("Every sample must be evaluated once: %+v", report)
+	}
+
+	if report.Precision < 0.9 || report.Recall < 0.9 {
+		t.Errorf("Expected precision & recall above 0.9, got: %f %f", report.Precision, report.Recall)
+	}
+	return
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "classifier")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	model := Train(trainingSamples())
+	filename := filepath.Join(dir, "model.json")
+	if err = model.Save(filename); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	text := "db_password = \"Xk9qLm2vRz8TnW\""
+	if loaded.Predict(text) != model.Predict(text) {
+		t.Errorf("Loaded model predicts differently")
+	}
+
+	if Score(nil, text) != -1 {
+		t.Errorf("Missing model must score -1")
+	}
+	return
+}
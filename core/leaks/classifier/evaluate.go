@@ -0,0 +1,85 @@
+package classifier
+
+import "time"
+
+//Threshold : probability from which fragment is considered a leak
+const Threshold = 0.5
+
+//Report : quality of the model measured with cross validation
+type Report struct {
+	Created   int64   `json:"created"`
+	Samples   int     `json:"samples"`
+	Leaks     int     `json:"leaks"`
+	Folds     int     `json:"folds"`
+	Threshold float64 `json:"threshold"`
+
+	TruePositives  int `json:"true_positives"`
+	FalsePositives int `json:"false_positives"`
+	TrueNegatives  int `json:"true_negatives"`
+	FalseNegatives int `json:"false_negatives"`
+
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+//Evaluate : k-fold cross validation, sample i belongs to fold i % folds
+func Evaluate(samples []Sample, folds int) (report Report) {
+	report.Created = time.Now().Unix()
+	report.Samples = len(samples)
+	report.Folds = folds
+	report.Threshold = Threshold
+
+	for _, sample := range samples {
+		if sample.Leak {
+			report.Leaks++
+		}
+	}
+
+	if folds < 2 || len(samples) < folds {
+		return
+	}
+
+	for fold := 0; fold < folds; fold++ {
+		train := make([]Sample, 0, len(samples))
+		test := make([]Sample, 0, len(samples)/folds+1)
+		for i, sample := range samples {
+			if i%folds == fold {
+				test = append(test, sample)
+			} else {
+				train = append(train, sample)
+			}
+		}
+
+		model := Train(train)
+		for _, sample := range test {
+			predicted := model.Predict(sample.Text) >= Threshold
+			switch {
+			case predicted && sample.Leak:
+				report.TruePositives++
+			case predicted && !sample.Leak:
+				report.FalsePositives++
+			case !predicted && sample.Leak:
+				report.FalseNegatives++
+			default:
+				report.TrueNegatives++
+			}
+		}
+	}
+
+	report.Precision = ratio(report.TruePositives, report.TruePositives+report.FalsePositives)
+	report.Recall = ratio(report.TruePositives, report.TruePositives+report.FalseNegatives)
+	report.Accuracy = ratio(report.TruePositives+report.TrueNegatives, report.Samples)
+	if report.Precision+report.Recall > 0 {
+		report.F1 = 2 * report.Precision * report.Recall / (report.Precision + report.Recall)
+	}
+	return
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
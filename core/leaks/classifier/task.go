@@ -0,0 +1,86 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/utils"
+)
+
+//DefaultModelFile : used if model_file is not set in config
+const DefaultModelFile = "./config/classifier.json"
+
+//Folds : number of cross validation folds
+const Folds = 5
+
+//ModelFile : path of the model file from settings
+func ModelFile() string {
+	if utils.Settings.LeakGlobals.ModelFile != "" {
+		return utils.Settings.LeakGlobals.ModelFile
+	}
+	return DefaultModelFile
+}
+
+//LoadDefault : model from the configured file, nil if there is no trained model yet
+func LoadDefault() *Model {
+	model, err := Load(ModelFile())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			utils.ErrorLogger.Println(err.Error())
+		}
+		return nil
+	}
+	return model
+}
+
+//Score : leak probability of the text, -1 if there is no model
+func Score(model *Model, text string) float64 {
+	if model == nil {
+		return -1
+	}
+	return model.Predict(text)
+}
+
+//LoadSamples : reviewed fragments, verified ones are leaks
+func LoadSamples(manager models.Storage) (samples []Sample, err error) {
+	labels := map[int]bool{models.RULEMANUAL: false, models.RULEVERIFIED: true}
+	for rejectID, leak := range labels {
+		frags, err := manager.SelectTextFragments(models.FragmentFilter{RejectIDs: []int{rejectID}})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, frag := range frags {
+			samples = append(samples, Sample{Text: frag.Text, Leak: leak})
+		}
+	}
+	return
+}
+
+//RunTraining : retrain model on review history & save it with evaluation report
+func RunTraining(ctx context.Context, manager models.Storage) (err error) {
+	samples, err := LoadSamples(manager)
+	if err != nil {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	report := Evaluate(samples, Folds)
+	if report.Leaks == 0 || report.Leaks == report.Samples {
+		return fmt.Errorf("classifier: both verified and rejected fragments are required, got %d of %d verified", report.Leaks, report.Samples)
+	}
+
+	model := Train(samples)
+	model.Trained = report.Created
+	model.Report = &report
+
+	utils.InfoLogger.Printf("classifier trained on %d fragments: precision %.3f recall %.3f", report.Samples, report.Precision, report.Recall)
+	return model.Save(ModelFile())
+}
@@ -0,0 +1,115 @@
+package content
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	//CodecNone : content is stored as is
+	CodecNone = "none"
+
+	//CodecGzip : gzip compression
+	CodecGzip = "gzip"
+
+	//CodecZstd : zstandard compression
+	CodecZstd = "zstd"
+)
+
+//Codec : compression of stored content, Ext is appended to file names & object keys
+type Codec interface {
+	Name() string
+	Ext() string
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+//Codecs : all known codecs, readers try them in this order
+var Codecs = []Codec{gzipCodec{}, zstdCodec{}, noneCodec{}}
+
+//CodecByName : codec from settings
+func CodecByName(name string) (Codec, error) {
+	for _, codec := range Codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("content: unknown compression %q", name)
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string {
+	return CodecNone
+}
+
+func (noneCodec) Ext() string {
+	return ""
+}
+
+func (noneCodec) Encode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (noneCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return CodecGzip
+}
+
+func (gzipCodec) Ext() string {
+	return ".gz"
+}
+
+func (gzipCodec) Encode(data []byte) (encoded []byte, err error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err = writer.Write(data)
+	if err != nil {
+		return
+	}
+
+	err = writer.Close()
+	encoded = buf.Bytes()
+	return
+}
+
+func (gzipCodec) Decode(data []byte) (decoded []byte, err error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+//zstd encoder & decoder are safe for concurrent EncodeAll/DecodeAll calls
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string {
+	return CodecZstd
+}
+
+func (zstdCodec) Ext() string {
+	return ".zst"
+}
+
+func (zstdCodec) Encode(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (zstdCodec) Decode(data []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(data, nil)
+}
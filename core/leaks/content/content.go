@@ -0,0 +1,137 @@
+package content
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/megamon/core/utils"
+)
+
+const (
+	//TypeFlat : legacy layout, uncompressed files named by hash in one directory
+	TypeFlat = "flat"
+
+	//TypeFS : compressed files sharded by hash prefix
+	TypeFS = "fs"
+
+	//TypeS3 : S3 compatible object store
+	TypeS3 = "s3"
+)
+
+//ErrNotFound : there is no content with the key
+var ErrNotFound = errors.New("content: not found")
+
+//Store : storage of fetched content addressed by its hash
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	Exists(key string) (bool, error)
+
+	//List : call fn for every stored key, stops on the first error
+	List(fn func(key string) error) error
+}
+
+var keyExpr = regexp.MustCompile(`^[0-9A-Za-z_\-]+$`)
+
+//checkKey : keys become file names & object keys, so only hash-like keys are allowed
+func checkKey(key string) (err error) {
+	if !keyExpr.MatchString(key) {
+		return fmt.Errorf("content: invalid key %q", key)
+	}
+	return
+}
+
+//StoreType : type of the store described by settings, unset type is fs unless dir holds only legacy flat files
+//Existing installs keep reading their content until it is moved by megamon content migrate
+func StoreType(settings utils.ContentStoreSettings, dir string) string {
+	if settings.Type != "" {
+		return settings.Type
+	}
+
+	if legacyOnly(dir) {
+		return TypeFlat
+	}
+	return TypeFS
+}
+
+//legacyOnly : dir has flat files named by hash & no sharded content
+func legacyOnly(dir string) bool {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+
+	flat := false
+	for _, file := range files {
+		if file.IsDir() {
+			return false
+		}
+		flat = flat || file.Mode().IsRegular() && checkKey(file.Name()) == nil
+	}
+	return flat
+}
+
+//Open : store described by settings, ContentDir is the root of filesystem stores
+func Open(settings utils.ContentStoreSettings, dir string) (store Store, err error) {
+	compression := settings.Compression
+	if compression == "" {
+		compression = CodecGzip
+	}
+
+	codec, err := CodecByName(compression)
+	if err != nil {
+		return
+	}
+
+	switch StoreType(settings, dir) {
+	case TypeFlat:
+		return &FlatStore{Dir: dir}, nil
+	case TypeFS:
+		return &FSStore{Dir: dir, Codec: codec}, nil
+	case TypeS3:
+		return NewS3Store(settings, codec)
+	}
+	return nil, fmt.Errorf("content: unknown store type %q", settings.Type)
+}
+
+//Default : store configured in global settings
+func Default() (Store, error) {
+	settings, dir := utils.Settings.ContentStore, utils.Settings.LeakGlobals.ContentDir
+	if settings.Type == "" && StoreType(settings, dir) == TypeFlat {
+		utils.InfoLogger.Printf("content: %s has legacy flat files, they are used until content_store.type is set & megamon content migrate is run", dir)
+	}
+	return Open(settings, dir)
+}
+
+//Migrate : copy every item from src to dst, items already present in dst are skipped
+//Source items are deleted after they were copied if remove is set
+func Migrate(src, dst Store, remove bool) (moved int, err error) {
+	err = src.List(func(key string) (err error) {
+		exist, err := dst.Exists(key)
+		if err != nil {
+			return
+		}
+
+		if !exist {
+			data, err := src.Get(key)
+			if err != nil {
+				return err
+			}
+
+			err = dst.Put(key, data)
+			if err != nil {
+				return err
+			}
+			moved++
+		}
+
+		if remove {
+			err = src.Delete(key)
+		}
+		return
+	})
+	return
+}
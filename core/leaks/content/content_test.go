@@ -0,0 +1,162 @@
+package content
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/megamon/core/utils"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	return dir
+}
+
+func listKeys(t *testing.T, store Store) (keys []string) {
+	err := store.List(func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+	}
+	sort.Strings(keys)
+	return
+}
+
+func TestFSStoreCodecs(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("password = secret\n"), 100)
+	for _, codec := range Codecs {
+		store := &FSStore{Dir: dir, Codec: codec}
+		key := "a1b2c3" + codec.Name()
+
+		if err := store.Put(key, data); err != nil {
+			t.Errorf("%s: %s", codec.Name(), err.Error())
+			continue
+		}
+
+		stored, err := ioutil.ReadFile(filepath.Join(dir, "a1", "b2", key+codec.Ext()))
+		if err != nil {
+			t.Errorf("%s: expected sharded file: %s", codec.Name(), err.Error())
+			continue
+		}
+
+		if codec.Name() != CodecNone && len(stored) >= len(data) {
+			t.Errorf("%s: content was not compressed", codec.Name())
+		}
+
+		//content written with any codec stays readable after compression is changed
+		other := &FSStore{Dir: dir, Codec: noneCodec{}}
+		got, err := other.Get(key)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: wrong content: %v", codec.Name(), err)
+		}
+	}
+
+	store := &FSStore{Dir: dir, Codec: gzipCodec{}}
+	if keys := listKeys(t, store); len(keys) != len(Codecs) {
+		t.Errorf("Expected %d keys, got: %v", len(Codecs), keys)
+	}
+
+	if _, err := store.Get("missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+
+	if err := store.Put("../escape", data); err == nil {
+		t.Errorf("Expected error for invalid key")
+	}
+
+	if err := store.Delete("a1b2c3gzip"); err != nil {
+		t.Errorf("%s", err.Error())
+	}
+
+	if exist, _ := store.Exists("a1b2c3gzip"); exist {
+		t.Errorf("Expected content to be deleted")
+	}
+	return
+}
+
+func TestMigrateFlat(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	legacy := map[string]string{"deadbeef": "first", "cafebabe": "second"}
+	for key, text := range legacy {
+		if err := ioutil.WriteFile(filepath.Join(dir, key), []byte(text), 0644); err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+	}
+
+	flat := &FlatStore{Dir: dir}
+	store := &FSStore{Dir: dir, Codec: zstdCodec{}}
+
+	//legacy files are readable before migration
+	if got, err := store.Get("deadbeef"); err != nil || string(got) != "first" {
+		t.Errorf("Expected legacy content, got: %s %v", got, err)
+	}
+
+	moved, err := Migrate(flat, store, true)
+	if err != nil || moved != 2 {
+		t.Errorf("Expected 2 migrated items, got: %d %v", moved, err)
+	}
+
+	if keys := listKeys(t, flat); len(keys) != 0 {
+		t.Errorf("Expected legacy files to be removed, got: %v", keys)
+	}
+
+	for key, text := range legacy {
+		if got, err := store.Get(key); err != nil || string(got) != text {
+			t.Errorf("Wrong migrated content of %s: %s %v", key, got, err)
+		}
+	}
+	return
+}
+
+func TestStoreType(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	//new install gets the sharded store
+	if kind := StoreType(utils.ContentStoreSettings{}, dir); kind != TypeFS {
+		t.Errorf("Expected fs store for empty dir, got: %s", kind)
+	}
+
+	//existing install keeps reading its flat files until they are migrated
+	if err := ioutil.WriteFile(filepath.Join(dir, "deadbeef"), []byte("legacy"), 0644); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	store, err := Open(utils.ContentStoreSettings{}, dir)
+	if _, flat := store.(*FlatStore); err != nil || !flat {
+		t.Errorf("Expected flat store for legacy files, got: %T %v", store, err)
+	}
+
+	if exist, err := store.Exists("deadbeef"); err != nil || !exist {
+		t.Errorf("Expected legacy content to exist, got: %v %v", exist, err)
+	}
+
+	if kind := StoreType(utils.ContentStoreSettings{Type: TypeFS}, dir); kind != TypeFS {
+		t.Errorf("Expected configured type, got: %s", kind)
+	}
+
+	//sharded content was written already: fs reads both layouts
+	fs := &FSStore{Dir: dir, Codec: Codecs[0]}
+	if err = fs.Put("cafebabe", []byte("sharded")); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if kind := StoreType(utils.ContentStoreSettings{}, dir); kind != TypeFS {
+		t.Errorf("Expected fs store for sharded content, got: %s", kind)
+	}
+	return
+}
@@ -0,0 +1,248 @@
+package content
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//FlatStore : legacy layout, uncompressed files named by hash right in Dir
+type FlatStore struct {
+	Dir string
+}
+
+func (s *FlatStore) path(key string) string {
+	return filepath.Join(s.Dir, key)
+}
+
+//Put : store content
+func (s *FlatStore) Put(key string, data []byte) (err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+	return writeFile(s.path(key), data)
+}
+
+//Get : read content, ErrNotFound if there is no such file
+func (s *FlatStore) Get(key string) (data []byte, err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+	return readFile(s.path(key))
+}
+
+//Delete : remove content, missing files are ignored
+func (s *FlatStore) Delete(key string) (err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+	return removeFile(s.path(key))
+}
+
+//Exists : check if content is stored
+func (s *FlatStore) Exists(key string) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	return fileExists(s.path(key))
+}
+
+//List : regular files of Dir, subdirectories are skipped
+func (s *FlatStore) List(fn func(key string) error) (err error) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if !file.Mode().IsRegular() || checkKey(file.Name()) != nil {
+			continue
+		}
+
+		if err = fn(file.Name()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//FSStore : compressed files sharded by the first two bytes of hash: Dir/ab/cd/abcd...ext
+//Get falls back to the legacy flat file, so content written before migration stays readable;
+//Exists & List cover the sharded layout only
+type FSStore struct {
+	Dir   string
+	Codec Codec
+}
+
+func (s *FSStore) path(key string, codec Codec) string {
+	dir := s.Dir
+	if len(key) >= 4 {
+		dir = filepath.Join(dir, key[:2], key[2:4])
+	} else {
+		dir = filepath.Join(dir, "_")
+	}
+	return filepath.Join(dir, key+codec.Ext())
+}
+
+//Put : compress & store content
+func (s *FSStore) Put(key string, data []byte) (err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+
+	encoded, err := s.Codec.Encode(data)
+	if err != nil {
+		return
+	}
+
+	filename := s.path(key, s.Codec)
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return
+	}
+	return writeFile(filename, encoded)
+}
+
+//Get : read content written with any codec or by the legacy flat layout
+func (s *FSStore) Get(key string) (data []byte, err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+
+	for _, codec := range s.codecs() {
+		encoded, err := readFile(s.path(key, codec))
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return codec.Decode(encoded)
+	}
+
+	return readFile(filepath.Join(s.Dir, key))
+}
+
+//Delete : remove content in every format including the legacy one
+func (s *FSStore) Delete(key string) (err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+
+	for _, codec := range s.codecs() {
+		if err = removeFile(s.path(key, codec)); err != nil {
+			return
+		}
+	}
+	return removeFile(filepath.Join(s.Dir, key))
+}
+
+//Exists : check if content is stored in the sharded layout
+func (s *FSStore) Exists(key string) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+
+	for _, codec := range s.codecs() {
+		exist, err := fileExists(s.path(key, codec))
+		if err != nil || exist {
+			return exist, err
+		}
+	}
+	return false, nil
+}
+
+//List : keys of the sharded layout
+func (s *FSStore) List(fn func(key string) error) (err error) {
+	shards, err := filepath.Glob(filepath.Join(s.Dir, "*", "*", "*"))
+	if err != nil {
+		return
+	}
+
+	short, err := filepath.Glob(filepath.Join(s.Dir, "_", "*"))
+	if err != nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, filename := range append(shards, short...) {
+		key := filepath.Base(filename)
+		for _, codec := range Codecs {
+			if codec.Ext() != "" && strings.HasSuffix(key, codec.Ext()) {
+				key = strings.TrimSuffix(key, codec.Ext())
+				break
+			}
+		}
+
+		if seen[key] || checkKey(key) != nil {
+			continue
+		}
+		seen[key] = true
+
+		if err = fn(key); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//codecs : configured codec first
+func (s *FSStore) codecs() []Codec {
+	codecs := []Codec{s.Codec}
+	for _, codec := range Codecs {
+		if codec.Name() != s.Codec.Name() {
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs
+}
+
+//writeFile : write into temporary file & rename it, readers never see partial content
+func writeFile(filename string, data []byte) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".tmp-")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+func readFile(filename string) (data []byte, err error) {
+	data, err = ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return
+}
+
+func removeFile(filename string) (err error) {
+	err = os.Remove(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return
+}
+
+func fileExists(filename string) (exist bool, err error) {
+	_, err = os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,323 @@
+package content
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/megamon/core/utils"
+)
+
+//S3Store : objects in S3 compatible store (AWS, MinIO, Ceph), addressed path-style:
+//Endpoint/Bucket/Prefix+key+ext. Requests are signed with AWS signature v4
+type S3Store struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Codec     Codec
+	Client    *http.Client
+
+	//now : clock of the signer, replaced in tests
+	now func() time.Time
+}
+
+//NewS3Store : store from settings
+func NewS3Store(settings utils.ContentStoreSettings, codec Codec) (store *S3Store, err error) {
+	if settings.Endpoint == "" || settings.Bucket == "" {
+		return nil, fmt.Errorf("content: s3 endpoint & bucket are required")
+	}
+
+	region := settings.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	store = &S3Store{
+		Endpoint:  strings.TrimRight(settings.Endpoint, "/"),
+		Region:    region,
+		Bucket:    settings.Bucket,
+		Prefix:    settings.Prefix,
+		AccessKey: settings.AccessKey,
+		SecretKey: settings.SecretKey,
+		Codec:     codec,
+		Client:    &http.Client{Timeout: 60 * time.Second},
+	}
+	return
+}
+
+func (s *S3Store) objectPath(key string) string {
+	return "/" + s.Bucket + "/" + s.Prefix + key + s.Codec.Ext()
+}
+
+//Put : compress & upload content
+func (s *S3Store) Put(key string, data []byte) (err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+
+	encoded, err := s.Codec.Encode(data)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.do("PUT", s.objectPath(key), nil, encoded)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	return s.check(resp, "PUT", key)
+}
+
+//Get : download & decompress content
+func (s *S3Store) Get(key string) (data []byte, err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+
+	resp, err := s.do("GET", s.objectPath(key), nil, nil)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if err = s.check(resp, "GET", key); err != nil {
+		return
+	}
+
+	encoded, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	return s.Codec.Decode(encoded)
+}
+
+//Delete : remove object, missing objects are ignored
+func (s *S3Store) Delete(key string) (err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+
+	resp, err := s.do("DELETE", s.objectPath(key), nil, nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+
+	err = s.check(resp, "DELETE", key)
+	if err == ErrNotFound {
+		return nil
+	}
+	return
+}
+
+//Exists : check object with HEAD request
+func (s *S3Store) Exists(key string) (exist bool, err error) {
+	if err = checkKey(key); err != nil {
+		return
+	}
+
+	resp, err := s.do("HEAD", s.objectPath(key), nil, nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+
+	err = s.check(resp, "HEAD", key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+//List : keys under Prefix stored with the configured codec
+func (s *S3Store) List(fn func(key string) error) (err error) {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.Prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do("GET", "/"+s.Bucket, query, nil)
+		if err != nil {
+			return err
+		}
+
+		var result listBucketResult
+		err = s.check(resp, "LIST", s.Prefix)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+
+		if err != nil {
+			return err
+		}
+
+		for _, object := range result.Contents {
+			key := strings.TrimPrefix(object.Key, s.Prefix)
+			if !strings.HasSuffix(key, s.Codec.Ext()) {
+				continue
+			}
+
+			key = strings.TrimSuffix(key, s.Codec.Ext())
+			if checkKey(key) != nil {
+				continue
+			}
+
+			if err = fn(key); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Store) check(resp *http.Response, method, key string) error {
+	switch {
+	case resp.StatusCode == 404:
+		return ErrNotFound
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("content: s3 %s %s: %s", method, key, resp.Status)
+	}
+	return nil
+}
+
+func (s *S3Store) do(method, path string, query url.Values, body []byte) (resp *http.Response, err error) {
+	req, err := http.NewRequest(method, s.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	req.URL.RawQuery = query.Encode()
+	if body == nil {
+		req.Body = nil
+		req.ContentLength = 0
+	}
+
+	s.sign(req, body)
+	return s.Client.Do(req)
+}
+
+//sign : add AWS signature v4 headers, all headers set on the request are signed
+func (s *S3Store) sign(req *http.Request, body []byte) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+	return
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+//uriEncode : percent encoding of signature v4, slashes are kept in paths
+func uriEncode(value string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
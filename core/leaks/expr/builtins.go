@@ -0,0 +1,74 @@
+package expr
+
+import (
+	"math"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type builtin struct {
+	args []Type
+	ret  Type
+	fn   func(args []interface{}) interface{}
+}
+
+//builtins : functions available in rule expressions
+var builtins = map[string]builtin{
+	"len":     {[]Type{typeAny}, TypeNumber, builtinLen},
+	"lower":   {[]Type{TypeString}, TypeString, func(args []interface{}) interface{} { return strings.ToLower(args[0].(string)) }},
+	"upper":   {[]Type{TypeString}, TypeString, func(args []interface{}) interface{} { return strings.ToUpper(args[0].(string)) }},
+	"ext":     {[]Type{TypeString}, TypeString, func(args []interface{}) interface{} { return path.Ext(args[0].(string)) }},
+	"base":    {[]Type{TypeString}, TypeString, func(args []interface{}) interface{} { return path.Base(args[0].(string)) }},
+	"entropy": {[]Type{TypeString}, TypeNumber, func(args []interface{}) interface{} { return MaxTokenEntropy(args[0].(string)) }},
+}
+
+func builtinLen(args []interface{}) interface{} {
+	switch value := args[0].(type) {
+	case string:
+		return float64(utf8.RuneCountInString(value))
+	case []string:
+		return float64(len(value))
+	}
+	return float64(0)
+}
+
+//MinTokenLen : shorter tokens are ignored by entropy()
+const MinTokenLen = 8
+
+var secretToken = regexp.MustCompile(`[A-Za-z0-9+/=_\-]+`)
+
+//MaxTokenEntropy : highest shannon entropy (bits per char) among secret-like tokens of the text
+func MaxTokenEntropy(text string) (max float64) {
+	for _, token := range secretToken.FindAllString(text, -1) {
+		if len(token) < MinTokenLen {
+			continue
+		}
+
+		if e := Entropy(token); e > max {
+			max = e
+		}
+	}
+	return
+}
+
+//Entropy : shannon entropy of the string in bits per char
+func Entropy(text string) (entropy float64) {
+	if text == "" {
+		return
+	}
+
+	freq := make(map[rune]int)
+	n := 0
+	for _, r := range text {
+		freq[r]++
+		n++
+	}
+
+	for _, count := range freq {
+		p := float64(count) / float64(n)
+		entropy -= p * math.Log2(p)
+	}
+	return
+}
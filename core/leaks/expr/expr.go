@@ -0,0 +1,276 @@
+package expr
+
+import (
+	"regexp"
+	"strings"
+)
+
+//Type : type of expression value
+type Type int
+
+const (
+	//TypeBool : true/false
+	TypeBool Type = iota
+
+	//TypeNumber : float64
+	TypeNumber
+
+	//TypeString : string
+	TypeString
+
+	//TypeList : list of strings
+	TypeList
+
+	//typeAny : string or list, accepted by polymorphic builtins only
+	typeAny Type = -1
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeBool:
+		return "bool"
+	case TypeNumber:
+		return "number"
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	}
+	return "string or list"
+}
+
+//Variables : names available in rule expressions & their types
+var Variables = map[string]Type{
+	"text":     TypeString, //fragment text
+	"keyword":  TypeString, //keyword the fragment was built around
+	"keywords": TypeList,   //all keywords found in the fragment
+	"path":     TypeString, //file path of the report
+	"lang":     TypeString, //language of the report
+	"repo":     TypeString, //repository full name
+	"owner":    TypeString, //repository owner login
+	"type":     TypeString, //report type: github, gist...
+}
+
+//Env : variable values; string, float64, bool or []string according to Variables
+type Env map[string]interface{}
+
+//Expr : compiled boolean expression
+type Expr struct {
+	src  string
+	root node
+}
+
+//Compile : parse & type check the expression
+func Compile(src string) (e *Expr, err error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return
+	}
+
+	p := parser{tokens: tokens}
+	root, err := p.parse()
+	if err != nil {
+		return
+	}
+
+	return &Expr{src: src, root: root}, nil
+}
+
+//MustCompile : Compile that panics on error
+func MustCompile(src string) *Expr {
+	e, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+//Eval : evaluate expression against the environment
+//Missing variables evaluate to zero values of their types
+func (e *Expr) Eval(env Env) bool {
+	return e.root.eval(env).(bool)
+}
+
+//String : source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+type node interface {
+	typ() Type
+	eval(env Env) interface{}
+}
+
+type literal struct {
+	value interface{}
+	t     Type
+}
+
+func (n *literal) typ() Type                { return n.t }
+func (n *literal) eval(env Env) interface{} { return n.value }
+
+type variable struct {
+	name string
+	t    Type
+}
+
+func (n *variable) typ() Type { return n.t }
+func (n *variable) eval(env Env) interface{} {
+	value, ok := env[n.name]
+	if ok && typeOf(value) == n.t {
+		return value
+	}
+	return zero(n.t)
+}
+
+type list struct {
+	items []node
+}
+
+func (n *list) typ() Type { return TypeList }
+func (n *list) eval(env Env) interface{} {
+	values := make([]string, 0, len(n.items))
+	for _, item := range n.items {
+		values = append(values, item.eval(env).(string))
+	}
+	return values
+}
+
+type not struct {
+	x node
+}
+
+func (n *not) typ() Type                { return TypeBool }
+func (n *not) eval(env Env) interface{} { return !n.x.eval(env).(bool) }
+
+type logical struct {
+	op   string
+	l, r node
+}
+
+func (n *logical) typ() Type { return TypeBool }
+func (n *logical) eval(env Env) interface{} {
+	l := n.l.eval(env).(bool)
+	if n.op == "and" {
+		return l && n.r.eval(env).(bool)
+	}
+	return l || n.r.eval(env).(bool)
+}
+
+type compare struct {
+	op   string
+	l, r node
+}
+
+func (n *compare) typ() Type { return TypeBool }
+func (n *compare) eval(env Env) interface{} {
+	l := n.l.eval(env)
+	r := n.r.eval(env)
+
+	switch n.op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	}
+
+	a, b := l.(float64), r.(float64)
+	switch n.op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+type stringOp struct {
+	op   string
+	l, r node
+}
+
+func (n *stringOp) typ() Type { return TypeBool }
+func (n *stringOp) eval(env Env) interface{} {
+	r := n.r.eval(env).(string)
+
+	if n.l.typ() == TypeList {
+		return contains(n.l.eval(env).([]string), r)
+	}
+
+	l := n.l.eval(env).(string)
+	switch n.op {
+	case "startswith":
+		return strings.HasPrefix(l, r)
+	case "endswith":
+		return strings.HasSuffix(l, r)
+	}
+	return strings.Contains(l, r)
+}
+
+type membership struct {
+	l, r node
+}
+
+func (n *membership) typ() Type { return TypeBool }
+func (n *membership) eval(env Env) interface{} {
+	return contains(n.r.eval(env).([]string), n.l.eval(env).(string))
+}
+
+type match struct {
+	x  node
+	re *regexp.Regexp
+}
+
+func (n *match) typ() Type                { return TypeBool }
+func (n *match) eval(env Env) interface{} { return n.re.MatchString(n.x.eval(env).(string)) }
+
+type call struct {
+	fn   builtin
+	args []node
+}
+
+func (n *call) typ() Type { return n.fn.ret }
+func (n *call) eval(env Env) interface{} {
+	args := make([]interface{}, 0, len(n.args))
+	for _, arg := range n.args {
+		args = append(args, arg.eval(env))
+	}
+	return n.fn.fn(args)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) Type {
+	switch value.(type) {
+	case bool:
+		return TypeBool
+	case float64:
+		return TypeNumber
+	case string:
+		return TypeString
+	case []string:
+		return TypeList
+	}
+	return typeAny
+}
+
+func zero(t Type) interface{} {
+	switch t {
+	case TypeBool:
+		return false
+	case TypeNumber:
+		return float64(0)
+	case TypeString:
+		return ""
+	}
+	return []string{}
+}
@@ -0,0 +1,121 @@
+package expr
+
+import (
+	"math"
+	"testing"
+)
+
+func testEnv() Env {
+	return Env{
+		"text":     `db_password = "changeme"`,
+		"keyword":  "password",
+		"keywords": []string{"password", "db"},
+		"path":     "internal/db/db_test.go",
+		"lang":     "Go",
+		"repo":     "megamon/megamon",
+		"owner":    "megamon",
+		"type":     "github",
+	}
+}
+
+func TestEval(t *testing.T) {
+	cases := []struct {
+		src      string
+		expected bool
+	}{
+		{`keyword == "password"`, true},
+		{`keyword != "password"`, false},
+		{`path endswith "_test.go"`, true},
+		{`path startswith "internal/"`, true},
+		{`text contains "changeme"`, true},
+		{`keywords contains "db"`, true},
+		{`"token" in keywords`, false},
+		{`lang in ["Go", "Rust"]`, true},
+		{`text matches "(?i)password\\s*="`, true},
+		{"text matches `password\\s*=`", true},
+		{`len(keywords) == 2`, true},
+		{`len(keyword) >= 8 and len(keyword) < 9`, true},
+		{`lower(lang) == "go"`, true},
+		{`ext(path) == ".go" && base(path) == "db_test.go"`, true},
+		{`not (type == "gist")`, true},
+		{`!true || false`, false},
+		{`true or false and false`, true},
+		{`(true or false) and false`, false},
+		{`NOT keyword == "password" OR lang == "Go"`, true},
+		{`entropy(text) > 3.5`, false},
+		{`path endswith "_test.go" and entropy(text) < 3.5 and keyword == "password"`, true},
+	}
+
+	env := testEnv()
+	for _, c := range cases {
+		e, err := Compile(c.src)
+		if err != nil {
+			t.Errorf("%s: %s", c.src, err.Error())
+			continue
+		}
+
+		if result := e.Eval(env); result != c.expected {
+			t.Errorf("%s: expected %v got %v", c.src, c.expected, result)
+		}
+	}
+	return
+}
+
+func TestCompileErrors(t *testing.T) {
+	invalid := []string{
+		``,
+		`keyword`,
+		`unknown == "x"`,
+		`keyword == 1`,
+		`keywords == "a"`,
+		`keyword > 1`,
+		`lang in "Go"`,
+		`text matches keyword`,
+		`text matches "("`,
+		`len(1) > 0`,
+		`lower(keyword, path) == ""`,
+		`nofunc(text)`,
+		`keyword == "password" and`,
+		`(keyword == "password"`,
+		`keyword == "password")`,
+		`keyword == "unterminated`,
+		`keyword == "a" == true`,
+		`keyword # "a"`,
+		`[keyword] contains "a"`,
+		`not keyword`,
+	}
+
+	for _, src := range invalid {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("Expected compile error for: %s", src)
+		}
+	}
+	return
+}
+
+func TestMissingVariables(t *testing.T) {
+	e := MustCompile(`path == "" and len(keywords) == 0 and not (text contains "a")`)
+	if !e.Eval(Env{"path": 42}) {
+		t.Errorf("Missing or mistyped variables must evaluate to zero values")
+	}
+	return
+}
+
+func TestEntropy(t *testing.T) {
+	if e := Entropy("aaaa"); e != 0 {
+		t.Errorf("Expected entropy 0, got %f", e)
+	}
+
+	if e := Entropy("abcd"); math.Abs(e-2) > 1e-9 {
+		t.Errorf("Expected entropy 2, got %f", e)
+	}
+
+	if e := MaxTokenEntropy("short abc ghp_4Fz9QkLm2XwT7rBn8VcY"); e < 4 {
+		t.Errorf("Expected high entropy token, got %f", e)
+	}
+
+	if e := MaxTokenEntropy("short words only"); e != 0 {
+		t.Errorf("Tokens shorter than %d must be ignored, got %f", MinTokenLen, e)
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+//wordOps : operators spelled as words, matched case-insensitively
+var wordOps = map[string]string{
+	"and":        "and",
+	"or":         "or",
+	"not":        "not",
+	"contains":   "contains",
+	"startswith": "startswith",
+	"endswith":   "endswith",
+	"matches":    "matches",
+	"in":         "in",
+}
+
+//symbolOps : operators spelled with symbols & their word equivalents
+var symbolOps = map[string]string{
+	"&&": "and",
+	"||": "or",
+	"!":  "not",
+	"==": "==",
+	"!=": "!=",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+func lex(src string) (tokens []token, err error) {
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{tokLBracket, "[", i})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokRBracket, "]", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+
+		case r == '"' || r == '\'' || r == '`':
+			var text string
+			start := i
+			text, i, err = lexString(runes, i)
+			if err != nil {
+				return
+			}
+			tokens = append(tokens, token{tokString, text, start})
+
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[start:i]), start})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+
+			word := string(runes[start:i])
+			if op, ok := wordOps[strings.ToLower(word)]; ok {
+				tokens = append(tokens, token{tokOp, op, start})
+			} else {
+				tokens = append(tokens, token{tokIdent, word, start})
+			}
+
+		default:
+			start := i
+			if i+1 < len(runes) {
+				if op, ok := symbolOps[string(runes[i:i+2])]; ok {
+					tokens = append(tokens, token{tokOp, op, start})
+					i += 2
+					continue
+				}
+			}
+
+			op, ok := symbolOps[string(r)]
+			if !ok {
+				return nil, fmt.Errorf("expr: unexpected character %q at %d", r, i)
+			}
+			tokens = append(tokens, token{tokOp, op, start})
+			i++
+		}
+	}
+
+	tokens = append(tokens, token{tokEOF, "", len(runes)})
+	return
+}
+
+//lexString : quoted strings support \ escapes, backquoted strings are raw
+func lexString(runes []rune, start int) (text string, end int, err error) {
+	var builder strings.Builder
+	quote := runes[start]
+
+	for i := start + 1; i < len(runes); i++ {
+		r := runes[i]
+		if r == quote {
+			return builder.String(), i + 1, nil
+		}
+
+		if r == '\\' && quote != '`' && i+1 < len(runes) {
+			i++
+			switch runes[i] {
+			case 'n':
+				builder.WriteRune('\n')
+			case 't':
+				builder.WriteRune('\t')
+			default:
+				builder.WriteRune(runes[i])
+			}
+			continue
+		}
+		builder.WriteRune(r)
+	}
+
+	err = fmt.Errorf("expr: unterminated string at %d", start)
+	return
+}
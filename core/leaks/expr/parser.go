@@ -0,0 +1,295 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+//parser : recursive descent parser with type checking
+//
+//	expr    := and ("or" and)*
+//	and     := unary ("and" unary)*
+//	unary   := "not" unary | cmp
+//	cmp     := operand (cmpop operand)?
+//	operand := STRING | NUMBER | IDENT | IDENT "(" args ")" | "(" expr ")" | "[" args "]"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return false
+	}
+
+	for _, op := range ops {
+		if tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) (err error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return unexpected(tok, text)
+	}
+	return
+}
+
+func unexpected(tok token, expected string) error {
+	if tok.kind == tokEOF {
+		return fmt.Errorf("expr: unexpected end of expression, expected %s", expected)
+	}
+	return fmt.Errorf("expr: unexpected %q at %d, expected %s", tok.text, tok.pos, expected)
+}
+
+func typeError(op string, pos int, types ...Type) error {
+	return fmt.Errorf("expr: operator %s at %d is not defined for %v", op, pos, types)
+}
+
+func (p *parser) parse() (root node, err error) {
+	root, err = p.parseOr()
+	if err != nil {
+		return
+	}
+
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, unexpected(tok, "end of expression")
+	}
+
+	if root.typ() != TypeBool {
+		return nil, fmt.Errorf("expr: expression must be boolean, got %s", root.typ())
+	}
+	return
+}
+
+func (p *parser) parseOr() (n node, err error) {
+	return p.parseLogical("or", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (n node, err error) {
+	return p.parseLogical("and", p.parseUnary)
+}
+
+func (p *parser) parseLogical(op string, operand func() (node, error)) (n node, err error) {
+	n, err = operand()
+	if err != nil {
+		return
+	}
+
+	for p.isOp(op) {
+		tok := p.next()
+		var r node
+		r, err = operand()
+		if err != nil {
+			return
+		}
+
+		if n.typ() != TypeBool || r.typ() != TypeBool {
+			return nil, typeError(op, tok.pos, n.typ(), r.typ())
+		}
+		n = &logical{op: op, l: n, r: r}
+	}
+	return
+}
+
+func (p *parser) parseUnary() (n node, err error) {
+	if p.isOp("not") {
+		tok := p.next()
+		n, err = p.parseUnary()
+		if err != nil {
+			return
+		}
+
+		if n.typ() != TypeBool {
+			return nil, typeError("not", tok.pos, n.typ())
+		}
+		return &not{x: n}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (n node, err error) {
+	l, err := p.parseOperand()
+	if err != nil {
+		return
+	}
+
+	if !p.isOp("==", "!=", "<", "<=", ">", ">=", "contains", "startswith", "endswith", "matches", "in") {
+		return l, nil
+	}
+
+	tok := p.next()
+	r, err := p.parseOperand()
+	if err != nil {
+		return
+	}
+
+	lt, rt := l.typ(), r.typ()
+	switch tok.text {
+	case "==", "!=":
+		if lt != rt || lt == TypeList {
+			return nil, typeError(tok.text, tok.pos, lt, rt)
+		}
+		return &compare{op: tok.text, l: l, r: r}, nil
+
+	case "<", "<=", ">", ">=":
+		if lt != TypeNumber || rt != TypeNumber {
+			return nil, typeError(tok.text, tok.pos, lt, rt)
+		}
+		return &compare{op: tok.text, l: l, r: r}, nil
+
+	case "contains":
+		if (lt != TypeString && lt != TypeList) || rt != TypeString {
+			return nil, typeError(tok.text, tok.pos, lt, rt)
+		}
+		return &stringOp{op: tok.text, l: l, r: r}, nil
+
+	case "startswith", "endswith":
+		if lt != TypeString || rt != TypeString {
+			return nil, typeError(tok.text, tok.pos, lt, rt)
+		}
+		return &stringOp{op: tok.text, l: l, r: r}, nil
+
+	case "in":
+		if lt != TypeString || rt != TypeList {
+			return nil, typeError(tok.text, tok.pos, lt, rt)
+		}
+		return &membership{l: l, r: r}, nil
+	}
+
+	//matches: pattern must be a literal to be compiled once
+	pattern, ok := r.(*literal)
+	if lt != TypeString || !ok || rt != TypeString {
+		return nil, fmt.Errorf("expr: matches at %d expects string on the left and string literal on the right", tok.pos)
+	}
+
+	re, err := regexp.Compile(pattern.value.(string))
+	if err != nil {
+		return nil, fmt.Errorf("expr: invalid pattern at %d: %s", tok.pos, err.Error())
+	}
+	return &match{x: l, re: re}, nil
+}
+
+func (p *parser) parseOperand() (n node, err error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokString:
+		return &literal{value: tok.text, t: TypeString}, nil
+
+	case tokNumber:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expr: invalid number %q at %d", tok.text, tok.pos)
+		}
+		return &literal{value: value, t: TypeNumber}, nil
+
+	case tokLParen:
+		n, err = p.parseOr()
+		if err != nil {
+			return
+		}
+		err = p.expect(tokRParen, ")")
+		return
+
+	case tokLBracket:
+		items, err := p.parseArgs(tokRBracket, "]")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if _, ok := item.(*literal); !ok || item.typ() != TypeString {
+				return nil, fmt.Errorf("expr: list at %d must contain string literals only", tok.pos)
+			}
+		}
+		return &list{items: items}, nil
+
+	case tokIdent:
+		if tok.text == "true" || tok.text == "false" {
+			return &literal{value: tok.text == "true", t: TypeBool}, nil
+		}
+
+		if p.peek().kind == tokLParen {
+			p.next()
+			return p.parseCall(tok)
+		}
+
+		t, ok := Variables[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("expr: unknown variable %q at %d", tok.text, tok.pos)
+		}
+		return &variable{name: tok.text, t: t}, nil
+	}
+
+	return nil, unexpected(tok, "operand")
+}
+
+func (p *parser) parseCall(name token) (n node, err error) {
+	fn, ok := builtins[name.text]
+	if !ok {
+		return nil, fmt.Errorf("expr: unknown function %q at %d", name.text, name.pos)
+	}
+
+	args, err := p.parseArgs(tokRParen, ")")
+	if err != nil {
+		return
+	}
+
+	if len(args) != len(fn.args) {
+		return nil, fmt.Errorf("expr: %s at %d expects %d arguments, got %d", name.text, name.pos, len(fn.args), len(args))
+	}
+
+	for i, arg := range args {
+		if fn.args[i] == typeAny && (arg.typ() == TypeString || arg.typ() == TypeList) {
+			continue
+		}
+
+		if fn.args[i] != arg.typ() {
+			return nil, fmt.Errorf("expr: argument %d of %s at %d must be %s, got %s", i+1, name.text, name.pos, fn.args[i], arg.typ())
+		}
+	}
+	return &call{fn: fn, args: args}, nil
+}
+
+func (p *parser) parseArgs(closing tokenKind, text string) (args []node, err error) {
+	if p.peek().kind == closing {
+		p.next()
+		return
+	}
+
+	for {
+		var arg node
+		arg, err = p.parseOr()
+		if err != nil {
+			return
+		}
+		args = append(args, arg)
+
+		tok := p.next()
+		if tok.kind == closing {
+			return
+		}
+
+		if tok.kind != tokComma {
+			return nil, unexpected(tok, ", or "+text)
+		}
+	}
+}
@@ -4,45 +4,59 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/megamon/core/leaks/classifier"
+	"github.com/megamon/core/leaks/content"
 	"github.com/megamon/core/leaks/github"
 	"github.com/megamon/core/leaks/models"
 	"github.com/megamon/core/leaks/stage"
+	"github.com/megamon/core/leaks/taskrun"
+	"github.com/megamon/core/leaks/tokens"
 	"github.com/megamon/core/utils"
 	"golang.org/x/time/rate"
 )
 
 //Stage : Stage interface
 type Stage struct {
-	Manager       models.Manager
-	RequestParams map[int]gistRequestParams
+	Manager    models.Storage
+	Classifier *classifier.Model
+	Content    content.Store
+
+	//Run : task run new reports are counted in
+	Run *taskrun.Run
+
+	//Pool : requests & probes are signed with its tokens when they are sent
+	*tokens.Pool
 }
 
+//gistRequestParams : keyword & page of the search request
 type gistRequestParams struct {
-	keyword string
-	page    int
+	Keyword string `json:"keyword"`
+	Page    int    `json:"page"`
 }
 
-//Init : constructor
-func (s *Stage) Init() (err error) {
-	s.RequestParams = make(map[int]gistRequestParams)
-	err = s.Manager.Init()
-	return
-}
+//Init : constructor, manager is shared with other stages
+func (s *Stage) Init(manager models.Storage) (err error) {
+	s.Manager = manager
+	s.Pool = tokens.Default()
+
+	s.Content, err = content.Default()
+	if err != nil {
+		return
+	}
 
-//Close : destructor
-func (s *Stage) Close() {
-	s.Manager.Close()
+	s.Classifier = classifier.LoadDefault()
 	return
 }
 
 //GetDBManager : stage interface realization
-func (s *Stage) GetDBManager() models.Manager {
+func (s *Stage) GetDBManager() models.Storage {
 	return s.Manager
 }
 
@@ -62,9 +76,10 @@ func gistSearchURL(query string, page int) string {
 	return URL
 }
 
-func buildSearchRequest(query string, page int, token string) (*http.Request, error) {
+//buildSearchRequest : page of search results, request is signed with a token when it is sent
+func buildSearchRequest(query string, page int) (*http.Request, error) {
 	url := gistSearchURL(query, page)
-	logInfo(fmt.Sprintf("building gist search request: %s %s", url, token[:4]))
+	logInfo(fmt.Sprintf("building gist search request: %s", url))
 
 	var requestBody bytes.Buffer
 	req, err := http.NewRequest("GET", url, &requestBody)
@@ -73,43 +88,57 @@ func buildSearchRequest(query string, page int, token string) (*http.Request, er
 		return &http.Request{}, err
 	}
 
-	req.Header.Set("Authorization", "token "+token)
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 	req.Header.Set("Accept-Encoding", "deflate, gzip;q=1.0, *;q=0.5")
 	return req, err
 }
 
 //BuildRequests : generate search requests
-func (s *Stage) BuildRequests(reqQueue chan stage.Request) (err error) {
+func (s *Stage) BuildRequests(ctx context.Context, reqQueue chan stage.Request) (err error) {
 	keywords, err := s.Manager.SelectKeywordByType(models.KWSEARCHABLE)
 	if err != nil {
 		logErr(err)
 		return
 	}
 
-	tokens := utils.Settings.Github.Tokens
 	desiredRate := rate.Limit(utils.Settings.Github.RequestRate) * rate.Every(time.Second)
 	rl := rate.NewLimiter(desiredRate, 1)
-	ctx := context.Background()
 	id := 0
 
 	// nQueries := len(keywords) * len(langs)
 GENREQ:
-	for i, keyword := range keywords {
-		token := tokens[i%len(tokens)]
+	for _, keyword := range keywords {
 		nPages := []int{0, 5, 10, 20, 50, 100}
 		nToLoad := 5
 
 		for _, page := range nPages {
-			req, err := buildSearchRequest(keyword.Value, page, token)
+			req, err := buildSearchRequest(keyword.Value, page)
 
 			if err != nil {
 				logErr(err)
 				continue GENREQ
 			}
 
-			_ = rl.Wait(ctx)
-			resp, err := utils.DoRequest(req)
+			//the probe is waited for & sent in the context of the run
+			if err := rl.Wait(ctx); err != nil {
+				return err
+			}
+
+			token, err := s.Authorize(ctx, req)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if err != nil {
+				return err
+			}
+
+			resp, err := utils.DoRequest(req.WithContext(ctx))
+			s.Observe(token, resp, err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
 			if err != nil {
 				logErr(err)
 				continue GENREQ
@@ -133,15 +162,17 @@ GENREQ:
 		logInfo(fmt.Sprintf("loading %d pages for gist %s\n", nToLoad, keyword.Value))
 
 		for offset := 0; offset < nToLoad; offset++ {
-			token := tokens[id%len(tokens)]
-			req, err := buildSearchRequest(keyword.Value, offset, token)
+			req, err := buildSearchRequest(keyword.Value, offset)
 			if err != nil {
 				logErr(err)
 				continue
 			}
 
-			reqQueue <- stage.Request{ID: id, Req: req}
-			s.RequestParams[id] = gistRequestParams{keyword: keyword.Value, page: offset}
+			params := gistRequestParams{Keyword: keyword.Value, Page: offset}
+			err = stage.Enqueue(ctx, reqQueue, stage.Request{ID: id, Req: req, Params: params})
+			if err != nil {
+				return err
+			}
 			id++
 		}
 	}
@@ -149,25 +180,46 @@ GENREQ:
 	return
 }
 
-//CheckResponse : check reponse
+//Name : checkpointer interface realization
+func (s *Stage) Name() string {
+	return "gist"
+}
+
+//RestoreRequest : checkpointer interface realization, request is signed when it is sent
+func (s *Stage) RestoreRequest(requestID int, data []byte) (req stage.Request, err error) {
+	var params gistRequestParams
+	err = json.Unmarshal(data, &params)
+	if err != nil {
+		return
+	}
+
+	httpReq, err := buildSearchRequest(params.Keyword, params.Page)
+	if err != nil {
+		return
+	}
+
+	return stage.Request{ID: requestID, Req: httpReq, Params: params}, nil
+}
+
+//CheckResponse : check reponse, failed request is repeated or given up by the retry policy
 func (s *Stage) CheckResponse(resp stage.Response, reqCount int) (res int) {
 	switch resp.Resp.StatusCode {
 	case 200:
 		return stage.OK
-	case 403:
-		fallthrough
 	default:
-		if reqCount < stage.MAXRETRIES {
-			return stage.WAIT
-		}
-		return stage.SKIP
+		return stage.WAIT
 	}
 }
 
 //ProcessResponse : process search response
-func (s *Stage) ProcessResponse(resp []byte, requestID int) (err error) {
+func (s *Stage) ProcessResponse(resp []byte, requestID int, requestParams interface{}) (err error) {
 	logInfo(fmt.Sprintf("processing search API response from request : %d", requestID))
 
+	params, ok := requestParams.(gistRequestParams)
+	if !ok {
+		return fmt.Errorf("gist request %d: unexpected params %T", requestID, requestParams)
+	}
+
 	if strings.Contains(string(resp), "We couldn’t find any gists matching") {
 		return
 	}
@@ -180,16 +232,15 @@ func (s *Stage) ProcessResponse(resp []byte, requestID int) (err error) {
 	report.ShaHash = fmt.Sprintf("%x", shaHash)
 	report.Status = stage.FETCHED
 
-	_, err = s.Manager.InsertReport(report)
+	report.Source = models.Source{URL: gistSearchURL(params.Keyword, params.Page), Keyword: params.Keyword}
 
-	if err != nil {
+	inserted, err := s.Manager.InsertReports([]models.Report{report})
+	if err != nil || inserted == 0 {
 		return
 	}
+	s.Run.AddReports(inserted)
 
-	filePrefix := utils.Settings.LeakGlobals.ContentDir
-	filename := fmt.Sprintf("%s%s", filePrefix, report.ShaHash)
-
-	err = ioutil.WriteFile(filename, resp, 0644)
+	err = s.Content.Put(report.ShaHash, resp)
 	if err != nil {
 		logErr(err)
 		return
@@ -199,66 +250,65 @@ func (s *Stage) ProcessResponse(resp []byte, requestID int) (err error) {
 }
 
 //GetTextsToProcess : produce report texts
-func (s *Stage) GetTextsToProcess(textQueue chan stage.ReportText) (err error) {
+func (s *Stage) GetTextsToProcess(ctx context.Context, textQueue chan stage.ReportText) (err error) {
 	logInfo("generating texts for processing")
-	reports, err := s.Manager.SelectReportByStatus("gist", stage.FETCHED)
-	filePrefix := utils.Settings.LeakGlobals.ContentDir
-
+	reports, err := s.Manager.SelectReports(models.ReportFilter{Type: "gist", Status: stage.FETCHED})
 	if err != nil {
 		logErr(err)
 		return
 	}
 
 	for _, report := range reports {
-		filename := fmt.Sprintf("%s%s", filePrefix, report.ShaHash)
-		logInfo(fmt.Sprintf("generating fragments for %s", filename))
+		logInfo(fmt.Sprintf("generating fragments for %s", report.ShaHash))
 
-		fileData, err := utils.ReadFile(filename)
+		fileData, err := s.Content.Get(report.ShaHash)
 		if err != nil {
 			logErr(err)
 			continue
 		}
 
-		textQueue <- stage.ReportText{ReportID: report.ID, Text: string(fileData)}
+		err = stage.EnqueueText(ctx, textQueue, stage.ReportText{ReportID: report.ID, Text: string(fileData), Type: report.Type})
+		if err != nil {
+			return err
+		}
 	}
 
 	return
 }
 
-//ProcessTextFragment : stage interface realization
-func (s *Stage) ProcessTextFragment(fragment models.TextFragment) (err error) {
-	logInfo(fmt.Sprintf("processing fragment %s", fragment.ShaHash[:4]))
-	exist, err := s.Manager.CheckTextFragmentDuplicate(fragment.ShaHash)
-	if err != nil {
-		return
-	}
-	if !exist {
-		fragment.Type = "gist"
-		_, err = s.Manager.InsertTextFragment(&fragment)
-		return
+//ProcessTextFragments : stage interface realization
+//Fragments & the NEW status of the report are committed in one transaction
+func (s *Stage) ProcessTextFragments(reportID int, fragments []models.TextFragment) (err error) {
+	logInfo(fmt.Sprintf("processing %d fragments of report %d", len(fragments), reportID))
+	for i := range fragments {
+		fragments[i].Type = "gist"
+		fragments[i].Score = classifier.Score(s.Classifier, fragments[i].Text)
 	}
+
+	_, err = s.Manager.CommitReportFragments(reportID, fragments, stage.NEW)
 	return
 }
 
 //RunGistStage : main function
-func RunGistStage(ctx context.Context) (err error) {
+func RunGistStage(ctx context.Context, manager models.Storage) (err error) {
 	var gistStage Stage
-	gistStage.Init()
-	var rl github.RateLimiter
-	rl.Init()
-
-	err = stage.RunStage(ctx, &gistStage, &rl, 1, 1, 2)
-	gistStage.Close()
-
+	err = gistStage.Init(manager)
 	if err != nil {
 		logErr(err)
 		return
 	}
+	gistStage.Run = taskrun.FromContext(ctx)
+
+	var rl github.RateLimiter
+	rl.Init()
+
+	err = stage.RunStage(ctx, &gistStage, &rl, stage.OptionsOf("gist"))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
-	err = github.UpdateState(stage.FRAGMENTED, stage.NEW, "gist")
 	if err != nil {
 		logErr(err)
 	}
-
 	return
 }
@@ -2,18 +2,22 @@ package github
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 
+	"github.com/megamon/core/leaks/allowlist"
+	"github.com/megamon/core/leaks/classifier"
+	"github.com/megamon/core/leaks/content"
 	"github.com/megamon/core/leaks/models"
 	"github.com/megamon/core/leaks/stage"
-	"github.com/megamon/core/utils"
+	"github.com/megamon/core/leaks/tokens"
 )
 
-func buildFetchRequest(url, token string) (*http.Request, error) {
-	logInfo(fmt.Sprintf("building fetch request: %s %s", url, token[:4]))
+//buildFetchRequest : request of the content, it is signed with a token when it is sent
+func buildFetchRequest(url string) (*http.Request, error) {
+	logInfo(fmt.Sprintf("building fetch request: %s", url))
 
 	var requestBody bytes.Buffer
 	req, err := http.NewRequest("GET", url, &requestBody)
@@ -22,7 +26,6 @@ func buildFetchRequest(url, token string) (*http.Request, error) {
 		return &http.Request{}, err
 	}
 
-	req.Header.Set("Authorization", "token "+token)
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 	req.Header.Set("Accept-Encoding", "deflate, gzip;q=1.0, *;q=0.5")
 	return req, err
@@ -30,37 +33,42 @@ func buildFetchRequest(url, token string) (*http.Request, error) {
 
 //FetchStage struct for the interface
 type FetchStage struct {
-	ReportHashes map[int]string
-	ReportIDs    map[int]int
-	Manager      models.Manager
-}
+	Manager    models.Storage
+	Allowlist  *allowlist.Allowlist
+	Classifier *classifier.Model
+	Content    content.Store
 
-//Init : constructor
-func (s *FetchStage) Init() (err error) {
-	s.ReportHashes = make(map[int]string)
-	s.ReportIDs = make(map[int]int)
-	err = s.Manager.Init()
-	return
+	//Pool : requests are signed with its tokens when they are sent
+	*tokens.Pool
 }
 
-//Close : destructor
-func (s *FetchStage) Close() {
-	s.Manager.Close()
+//Init : constructor, manager is shared with other stages
+func (s *FetchStage) Init(manager models.Storage) (err error) {
+	s.Manager = manager
+	s.Pool = tokens.Default()
+
+	s.Content, err = content.Default()
+	if err != nil {
+		return
+	}
+
+	s.Allowlist, err = allowlist.Load(s.Manager)
+	if err != nil {
+		return
+	}
+
+	s.Classifier = classifier.LoadDefault()
 	return
 }
 
 //BuildRequests : generate search requests
-func (s *FetchStage) BuildRequests(reqQueue chan stage.Request) (err error) {
-	tokens := utils.Settings.Github.Tokens
-	reports, err := s.Manager.SelectReportByStatus("github", stage.PROCESSED)
+func (s *FetchStage) BuildRequests(ctx context.Context, reqQueue chan stage.Request) (err error) {
+	reports, err := s.Manager.SelectReports(models.ReportFilter{Type: "github", Status: stage.PROCESSED})
 	if err != nil {
 		return
 	}
 
 	for id, report := range reports {
-		s.ReportHashes[id] = report.ShaHash
-		s.ReportIDs[id] = report.ID
-
 		var gitSearchItem GitSearchItem
 		err = json.Unmarshal(report.Data, &gitSearchItem)
 		if err != nil {
@@ -68,42 +76,55 @@ func (s *FetchStage) BuildRequests(reqQueue chan stage.Request) (err error) {
 			continue
 		}
 
-		token := tokens[id%len(tokens)]
-		req, err := buildFetchRequest(gitSearchItem.GitURL, token)
+		//Allowlist could be extended after the search stage
+		if suppressed(s.Manager, s.Allowlist, gitSearchItem) {
+			err = s.Manager.UpdateReportStatus(report.ID, stage.CLOSED)
+			if err != nil {
+				logErr(err)
+			}
+			continue
+		}
+
+		req, err := buildFetchRequest(gitSearchItem.GitURL)
 
 		if err != nil {
 			logErr(err)
 			continue
 		}
-		reqQueue <- stage.Request{ID: id, Req: req}
+
+		params := fetchRequestParams{ReportID: report.ID, ShaHash: report.ShaHash}
+		err = stage.Enqueue(ctx, reqQueue, stage.Request{ID: id, Req: req, Params: params})
+		if err != nil {
+			return err
+		}
 	}
 	return
 }
 
-//CheckResponse : check reponse
+//CheckResponse : check reponse, failed request is repeated or given up by the retry policy
 func (s *FetchStage) CheckResponse(resp stage.Response, reqCount int) (res int) {
 	switch resp.Resp.StatusCode {
 	case 200:
 		return stage.OK
-	case 403:
-		fallthrough
 	default:
-		if reqCount < stage.MAXRETRIES {
-			return stage.WAIT
-		}
-		return stage.SKIP
+		return stage.WAIT
 	}
 }
 
 //GetDBManager : stage interface realization
-func (s *FetchStage) GetDBManager() models.Manager {
+func (s *FetchStage) GetDBManager() models.Storage {
 	return s.Manager
 }
 
 //ProcessResponse : process search response
-func (s *FetchStage) ProcessResponse(resp []byte, RequestID int) (err error) {
+func (s *FetchStage) ProcessResponse(resp []byte, RequestID int, requestParams interface{}) (err error) {
 	logInfo(fmt.Sprintf("processing fetch response from request : %d", RequestID))
 
+	params, ok := requestParams.(fetchRequestParams)
+	if !ok {
+		return fmt.Errorf("fetch request %d: unexpected params %T", RequestID, requestParams)
+	}
+
 	var gitFetchItem GitFetchItem
 	err = json.Unmarshal(resp, &gitFetchItem)
 	if err != nil {
@@ -122,58 +143,60 @@ func (s *FetchStage) ProcessResponse(resp []byte, RequestID int) (err error) {
 		return
 	}
 
-	filePrefix := utils.Settings.LeakGlobals.ContentDir
-	filename := fmt.Sprintf("%s%s", filePrefix, s.ReportHashes[RequestID])
-
-	err = ioutil.WriteFile(filename, decoded, 0644)
+	err = s.Content.Put(params.ShaHash, decoded)
 	if err != nil {
 		logErr(err)
 		return
 	}
 
-	reportID := s.ReportIDs[RequestID]
-	s.Manager.UpdateReportStatus(reportID, stage.FETCHED)
+	s.Manager.UpdateReportStatus(params.ReportID, stage.FETCHED)
 	return
 }
 
 //GetTextsToProcess : produce report texts
-func (s *FetchStage) GetTextsToProcess(textQueue chan stage.ReportText) (err error) {
+func (s *FetchStage) GetTextsToProcess(ctx context.Context, textQueue chan stage.ReportText) (err error) {
 	logInfo("generating texts for processing")
-	reports, err := s.Manager.SelectReportByStatus("github", stage.FETCHED)
-	filePrefix := utils.Settings.LeakGlobals.ContentDir
-
+	reports, err := s.Manager.SelectReports(models.ReportFilter{Type: "github", Status: stage.FETCHED})
 	if err != nil {
 		logErr(err)
 		return
 	}
 
 	for _, report := range reports {
-		filename := fmt.Sprintf("%s%s", filePrefix, report.ShaHash)
-		logInfo(fmt.Sprintf("generating fragments for %s", filename))
+		logInfo(fmt.Sprintf("generating fragments for %s", report.ShaHash))
 
-		fileData, err := utils.ReadFile(filename)
+		fileData, err := s.Content.Get(report.ShaHash)
 		if err != nil {
 			logErr(err)
 			continue
 		}
 
-		textQueue <- stage.ReportText{ReportID: report.ID, Text: string(fileData)}
+		err = stage.EnqueueText(ctx, textQueue, stage.ReportText{
+			ReportID: report.ID,
+			Text:     string(fileData),
+			Type:     report.Type,
+			Path:     report.Path,
+			Lang:     report.Language,
+			Repo:     report.Repo,
+			Owner:    report.Owner,
+		})
+		if err != nil {
+			return err
+		}
 	}
 
 	return
 }
 
-//ProcessTextFragment : stage interface realization
-func (s *FetchStage) ProcessTextFragment(fragment models.TextFragment) (err error) {
-	logInfo(fmt.Sprintf("processing fragment %s", fragment.ShaHash[:4]))
-	exist, err := s.Manager.CheckTextFragmentDuplicate(fragment.ShaHash)
-	if err != nil {
-		return
-	}
-	if !exist {
-		fragment.Type = "github"
-		_, err = s.Manager.InsertTextFragment(&fragment)
-		return
+//ProcessTextFragments : stage interface realization
+//Fragments & the NEW status of the report are committed in one transaction
+func (s *FetchStage) ProcessTextFragments(reportID int, fragments []models.TextFragment) (err error) {
+	logInfo(fmt.Sprintf("processing %d fragments of report %d", len(fragments), reportID))
+	for i := range fragments {
+		fragments[i].Type = "github"
+		fragments[i].Score = classifier.Score(s.Classifier, fragments[i].Text)
 	}
+
+	_, err = s.Manager.CommitReportFragments(reportID, fragments, stage.NEW)
 	return
 }
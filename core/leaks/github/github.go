@@ -5,53 +5,50 @@ import (
 
 	"github.com/megamon/core/leaks/models"
 	"github.com/megamon/core/leaks/stage"
+	"github.com/megamon/core/leaks/taskrun"
 )
 
 //RunGitSearch : main stage for leak search on github
-func RunGitSearch(ctx context.Context) (err error) {
+//Reports found before the search failed are fetched anyway, the first error is returned
+func RunGitSearch(ctx context.Context, manager models.Storage) (err error) {
 	var searchStage SearchStage
-	searchStage.Init()
+	err = searchStage.Init(manager)
+	if err != nil {
+		logErr(err)
+		return
+	}
+	searchStage.Run = taskrun.FromContext(ctx)
+
 	var rl RateLimiter
 	rl.Init()
 
 	logInfo("search stage started")
-	err = stage.RunMiddlewareStage(ctx, &searchStage, &rl, 1, 1)
-	searchStage.Close()
+	err = stage.RunMiddlewareStage(ctx, &searchStage, &rl, stage.OptionsOf("search"))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
 	if err != nil {
 		logErr(err)
-		return
 	}
 
 	var fetchStage FetchStage
-	fetchStage.Init()
-	logInfo("fetch stage started")
-	
-	err = stage.RunStage(ctx, &fetchStage, &rl, 1, 1, 2)
-	fetchStage.Close()
-
-	if err != nil {
-		logErr(err)
-		return
+	fetchErr := fetchStage.Init(manager)
+	if fetchErr == nil {
+		logInfo("fetch stage started")
+		fetchErr = stage.RunStage(ctx, &fetchStage, &rl, stage.OptionsOf("fetch"))
 	}
 
-	err = UpdateState(stage.FRAGMENTED, stage.NEW, "github")
-	if err != nil {
-		logErr(err)
+	if fetchErr != nil && ctx.Err() == nil {
+		logErr(fetchErr)
 	}
 
-	return
-}
-
-//UpdateState : change state1 -> state2 for all reports of the type
-func UpdateState(prev, next string, reportType string) (err error) {
-	var manager models.Manager
-	err = manager.Init()
-	if err != nil {
-		return
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
-	query := "UPDATE " + models.ReportTable + " SET status=$2 WHERE status=$1 AND type=$3;"
-	_, err = manager.Database.Exec(query, next, prev, reportType)
+	if err == nil {
+		err = fetchErr
+	}
 	return
 }
@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"strconv"
-	"time"
 
+	"github.com/megamon/core/leaks/allowlist"
+	"github.com/megamon/core/leaks/models"
 	"github.com/megamon/core/utils"
 	"golang.org/x/time/rate"
 )
@@ -22,45 +22,36 @@ func logInfo(info string) {
 	return
 }
 
+//suppressed : check search item against allowlist & count the hit
+func suppressed(manager models.Storage, list *allowlist.Allowlist, item GitSearchItem) bool {
+	entry, ok := list.Match(allowlist.Item{
+		Repo:    item.Repo.FullName,
+		Owner:   item.Repo.Owner.Login,
+		ShaHash: item.ShaHash,
+		Path:    item.Path,
+	})
+
+	if !ok {
+		return false
+	}
+
+	logInfo(fmt.Sprintf("%s/%s suppressed by allowlist entry %d", item.Repo.FullName, item.Path, entry.ID))
+	err := manager.IncrementAllowlistHits(entry.ID, 1)
+	if err != nil {
+		logErr(err)
+	}
+	return true
+}
+
 //Init : RateLimiter init function
 func (rl *RateLimiter) Init() {
 	rl.Limiter = rate.NewLimiter(rate.Every(rl.Duration)*rate.Limit(rl.RequestRate), 1)
 }
 
-//Wait until desired rate
+//Wait : pace requests to RequestRate per Duration, quota of every token is tracked by the token pool
 func (rl *RateLimiter) Wait(ctx context.Context, resp *http.Response) interface{} {
-	rlRemaining := resp.Header.Get("x-ratelimit-remaining")
-	rlReset := resp.Header.Get("x-ratelimit-reset")
-
-	var remaining, resetTime int
-	var err error
-
-	if rlRemaining != "" {
-		remaining, err = strconv.Atoi(rlRemaining)
-
-		if err != nil {
-			logErr(err)
-			return rl.Limiter.Wait(ctx)
-		}
-
-		if remaining > 0 {
-			return struct{}{}
-		}
-
-		if rlReset != "" {
-			resetTime, err = strconv.Atoi(rlReset)
-			if err != nil {
-				logErr(err)
-				return rl.Limiter.Wait(ctx)
-			}
-			toWait := int64(resetTime) - time.Now().Unix()
-
-			if toWait < 120 {
-				<-time.After(time.Duration(toWait) * time.Second)
-				return struct{}{}
-			}
-		}
+	if rl.RequestRate <= 0 || rl.Duration <= 0 {
+		return struct{}{}
 	}
-
 	return rl.Limiter.Wait(ctx)
 }
@@ -0,0 +1,193 @@
+package github
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/megamon/core/leaks/models"
+)
+
+const (
+	//MAXRESULTS : results of a query reachable by pagination
+	MAXRESULTS = MAXOFFSET * MAXRESPONSEITEMS
+
+	//MAXFILESIZE : larger files are not indexed by github code search
+	MAXFILESIZE = 384 * 1024
+
+	//MAXSPLITS : partitions split per keyword, the rest of the oversized partitions are truncated
+	MAXSPLITS = 64
+)
+
+//SearchPaths : path qualifiers tried when neither size nor language narrow the query enough
+var SearchPaths = []string{"src", "lib", "app", "config", "conf", "test", "tests", "scripts", "docs", "deploy"}
+
+//partition : search of the keyword narrowed by qualifiers, empty qualifiers are not used
+//Size ranges split the results exhaustively, language, extension & path only pick the most common of them
+type partition struct {
+	Keyword string
+	MinSize int
+	MaxSize int
+	Lang    string
+	Ext     string
+	Path    string
+}
+
+//rootPartition : all results of the keyword
+func rootPartition(keyword string) partition {
+	return partition{Keyword: keyword, MaxSize: MAXFILESIZE}
+}
+
+//Query : search query of the partition, the whole size range is not qualified
+func (p partition) Query() (query string) {
+	query = buildGitSearchQuery(p.Keyword, p.Lang, false)
+	if p.MinSize > 0 || p.MaxSize < MAXFILESIZE {
+		query += fmt.Sprintf("+size:%d..%d", p.MinSize, p.MaxSize)
+	}
+
+	if p.Ext != "" {
+		query += "+extension:" + p.Ext
+	}
+
+	if p.Path != "" {
+		query += "+path:" + p.Path
+	}
+	return
+}
+
+//split : narrower partitions by the first qualifier that can still be narrowed
+//size range is halved until it is a single size, then the language, extension & path are set,
+//only size ranges are exhaustive, results of other languages, extensions & paths are left out
+func (p partition) split() (parts []partition, exhaustive bool) {
+	if p.MaxSize > p.MinSize {
+		lower, upper := p, p
+		lower.MaxSize = (p.MinSize + p.MaxSize) / 2
+		upper.MinSize = lower.MaxSize + 1
+		return []partition{lower, upper}, true
+	}
+
+	if p.Lang == "" {
+		for _, lang := range Langs {
+			if lang != "" {
+				part := p
+				part.Lang = lang
+				parts = append(parts, part)
+			}
+		}
+		return
+	}
+
+	if p.Ext == "" && p.Path == "" {
+		for _, ext := range models.LanguageExtensions(strings.ReplaceAll(p.Lang, "+", " ")) {
+			part := p
+			part.Ext = ext
+			parts = append(parts, part)
+		}
+
+		if len(parts) > 0 {
+			return
+		}
+	}
+
+	if p.Path == "" {
+		for _, path := range SearchPaths {
+			part := p
+			part.Path = path
+			parts = append(parts, part)
+		}
+	}
+	return
+}
+
+//searchLeaf : partition with all of its results reachable, unless it is truncated
+type searchLeaf struct {
+	partition
+	Total     int
+	Truncated bool
+	Items     []GitSearchItem
+}
+
+//Pages : number of pages to request
+func (leaf searchLeaf) Pages() (n int) {
+	total := leaf.Total
+	if total > MAXRESULTS {
+		total = MAXRESULTS
+	}
+
+	n = total / MAXRESPONSEITEMS
+	if total%MAXRESPONSEITEMS != 0 {
+		n++
+	}
+	return
+}
+
+//probeFunc : first page of the query
+type probeFunc func(query string) (resp GitSearchAPIResponse, err error)
+
+//partitionKeyword : split results of the keyword into partitions of at most MAXRESULTS results
+//Partition over the cap is truncated when MAXSPLITS splits are spent or there is nothing left to split.
+//It is also kept if its partitions leave some results out, the newest of them are reached by its own pages.
+//Results of the partition with a failed probe are not retrievable, they lower the coverage of the keyword
+func partitionKeyword(keyword string, probe probeFunc) (leaves []searchLeaf, coverage models.Coverage, err error) {
+	coverage.Keyword = keyword
+	root := rootPartition(keyword)
+	resp, err := probe(root.Query())
+	coverage.Probes++
+	if err != nil {
+		return
+	}
+
+	splits := 0
+	addLeaf := func(part partition, resp GitSearchAPIResponse) (reachable int) {
+		leaf := searchLeaf{partition: part, Total: resp.TotalCount, Items: resp.Items}
+		leaf.Truncated = leaf.Total > MAXRESULTS
+		leaves = append(leaves, leaf)
+
+		coverage.Partitions++
+		if leaf.Truncated {
+			coverage.Truncated++
+			return MAXRESULTS
+		}
+		return leaf.Total
+	}
+
+	//walk : results of the partition reachable by the leaves
+	var walk func(part partition, resp GitSearchAPIResponse) (reachable int)
+	walk = func(part partition, resp GitSearchAPIResponse) (reachable int) {
+		if resp.TotalCount == 0 {
+			return 0
+		}
+
+		parts, exhaustive := part.split()
+		if resp.TotalCount <= MAXRESULTS || splits >= MAXSPLITS || len(parts) == 0 {
+			return addLeaf(part, resp)
+		}
+
+		splits++
+		found := 0
+		for _, child := range parts {
+			childResp, err := probe(child.Query())
+			coverage.Probes++
+			if err != nil {
+				logErr(err)
+				continue
+			}
+
+			found += childResp.TotalCount
+			reachable += walk(child, childResp)
+		}
+
+		if rest := resp.TotalCount - found; !exhaustive && rest > 0 {
+			addLeaf(part, resp)
+			if rest > MAXRESULTS {
+				rest = MAXRESULTS
+			}
+			reachable += rest
+		}
+		return
+	}
+
+	coverage.Total = resp.TotalCount
+	coverage.Retrievable = walk(root, resp)
+	coverage.Ratio = models.CoverageRatio(coverage.Total, coverage.Retrievable)
+	return
+}
@@ -0,0 +1,369 @@
+package github
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/leaks/stage"
+	"github.com/megamon/core/leaks/tokens"
+	"github.com/megamon/core/utils"
+	"golang.org/x/time/rate"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLoggers("test.log")
+
+	retCode := m.Run()
+	err := os.Remove("test.log")
+	if err != nil {
+		fmt.Println("Unable to remove test.log")
+		fmt.Println(err.Error())
+	}
+	os.Exit(retCode)
+}
+
+//indexedFile : file of the fake search index
+type indexedFile struct {
+	size int
+	lang string
+	ext  string
+	path string
+}
+
+//fakeIndex : probe answering the query with the number of matching files, the keyword matches all of them
+func fakeIndex(files []indexedFile) probeFunc {
+	return func(query string) (resp GitSearchAPIResponse, err error) {
+		minSize, maxSize := 0, MAXFILESIZE
+		var lang, ext, path string
+		for _, qualifier := range strings.Split(query, "+")[1:] {
+			switch {
+			case strings.HasPrefix(qualifier, "size:"):
+				bounds := strings.Split(strings.TrimPrefix(qualifier, "size:"), "..")
+				minSize, _ = strconv.Atoi(bounds[0])
+				maxSize, _ = strconv.Atoi(bounds[1])
+			case strings.HasPrefix(qualifier, "language:"):
+				lang = strings.TrimPrefix(qualifier, "language:")
+			case strings.HasPrefix(qualifier, "extension:"):
+				ext = strings.TrimPrefix(qualifier, "extension:")
+			case strings.HasPrefix(qualifier, "path:"):
+				path = strings.TrimPrefix(qualifier, "path:")
+			}
+		}
+
+		for i, file := range files {
+			if file.size < minSize || file.size > maxSize || (lang != "" && file.lang != lang) ||
+				(ext != "" && file.ext != ext) || (path != "" && file.path != path) {
+				continue
+			}
+
+			resp.TotalCount++
+			if len(resp.Items) < MAXRESPONSEITEMS {
+				resp.Items = append(resp.Items, GitSearchItem{ShaHash: fmt.Sprintf("%d", i)})
+			}
+		}
+		return
+	}
+}
+
+func TestPartitionKeyword(t *testing.T) {
+	var files []indexedFile
+	for i := 0; i < 2500; i++ {
+		files = append(files, indexedFile{size: i * 100, lang: "Go", ext: "go"})
+	}
+
+	leaves, coverage, err := partitionKeyword("password", fakeIndex(files))
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	found := 0
+	for _, leaf := range leaves {
+		if leaf.Total > MAXRESULTS || leaf.Truncated {
+			t.Errorf("Expected partition within the cap, got: %s %d", leaf.Query(), leaf.Total)
+		}
+		found += leaf.Total
+	}
+
+	if found != 2500 || coverage.Total != 2500 || coverage.Retrievable != 2500 || coverage.Ratio != 1 || coverage.Truncated != 0 {
+		t.Errorf("Expected size ranges to cover every result, got: %d %+v", found, coverage)
+	}
+
+	//the same size, only the language splits the results
+	files = files[:0]
+	for i := 0; i < 1500; i++ {
+		lang := "Go"
+		if i%2 == 0 {
+			lang = "Python"
+		}
+		files = append(files, indexedFile{size: 10, lang: lang})
+	}
+
+	leaves, coverage, err = partitionKeyword("password", fakeIndex(files))
+	if err != nil || len(leaves) != 2 || coverage.Ratio != 1 || leaves[0].Lang == "" || leaves[0].MinSize != 10 || leaves[0].MaxSize != 10 {
+		t.Errorf("Expected partitions by language, got: %v %+v %v", leaves, coverage, err)
+	}
+
+	//results of unlisted paths are reached only by the pages of the partition itself
+	files = files[:0]
+	for i := 0; i < 3000; i++ {
+		path := ""
+		if i < 500 {
+			path = "src"
+		}
+		files = append(files, indexedFile{size: 10, lang: "Go", ext: "go", path: path})
+	}
+
+	leaves, coverage, err = partitionKeyword("password", fakeIndex(files))
+	if err != nil || coverage.Retrievable != 1500 || coverage.Truncated != 1 || coverage.Ratio != 0.5 {
+		t.Errorf("Expected truncated partition kept with its paths, got: %v %+v %v", leaves, coverage, err)
+	}
+}
+
+func TestPartitionKeywordFailedProbe(t *testing.T) {
+	var files []indexedFile
+	for i := 0; i < 2500; i++ {
+		files = append(files, indexedFile{size: i * 100, lang: "Go", ext: "go"})
+	}
+
+	//the upper half of the sizes can't be probed
+	index := fakeIndex(files)
+	probe := func(query string) (resp GitSearchAPIResponse, err error) {
+		if strings.Contains(query, fmt.Sprintf("size:%d..", MAXFILESIZE/2+1)) {
+			return resp, errors.New("rate limited")
+		}
+		return index(query)
+	}
+
+	_, coverage, err := partitionKeyword("password", probe)
+	if err != nil || coverage.Total != 2500 || coverage.Retrievable >= 2500 || coverage.Ratio >= 1 {
+		t.Errorf("Expected results of the failed probe to be lost, got: %+v %v", coverage, err)
+	}
+}
+
+func TestSearchProbe(t *testing.T) {
+	var sent int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Query().Get("q"), "invalid"):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		case atomic.AddInt64(&sent, 1) == 1:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write(searchPage(3, "a", "b", "c"))
+		}
+	}))
+	defer server.Close()
+
+	api := searchAPI
+	searchAPI = server.URL
+	defer func() { searchAPI = api }()
+
+	s := &SearchStage{Pool: tokens.NewPool([]string{"token"})}
+	s.Retry = stage.NewRetryPolicy(stage.NewOptions(utils.StageSettings{MaxRetries: 3}))
+	s.Retry.Backoff = time.Millisecond
+	rl := rate.NewLimiter(rate.Inf, 1)
+
+	//server error is repeated, the next attempt gets the results
+	resp, err := s.probe(context.Background(), rl, "password")
+	if err != nil || resp.TotalCount != 3 || atomic.LoadInt64(&sent) != 2 {
+		t.Errorf("Expected probe repeated after 502, got: %+v %v %d", resp, err, sent)
+	}
+
+	//rejected query is an error, not a query without results
+	_, err = s.probe(context.Background(), rl, "invalid")
+	if err == nil {
+		t.Errorf("Expected 422 to fail the probe")
+	}
+}
+
+func TestPartitionQuery(t *testing.T) {
+	if query := rootPartition("password").Query(); query != "password" {
+		t.Errorf("Expected unqualified root query, got: %s", query)
+	}
+
+	part := partition{Keyword: "password", MinSize: 0, MaxSize: 1024, Lang: "Go", Ext: "go", Path: "src"}
+	if query := part.Query(); query != "password+language:Go+size:0..1024+extension:go+path:src" {
+		t.Errorf("Expected qualified query, got: %s", query)
+	}
+}
+
+//searchPage : search API response with the items of the hashes
+func searchPage(total int, hashes ...string) []byte {
+	resp := GitSearchAPIResponse{TotalCount: total}
+	for _, hash := range hashes {
+		resp.Items = append(resp.Items, GitSearchItem{ShaHash: hash, Path: hash + ".go"})
+	}
+
+	data, _ := json.Marshal(resp)
+	return data
+}
+
+func TestSearchWatermarkFailedPage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megamon")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := models.OpenPool(utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.Close()
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	query := "password+in:file"
+	newRun := func() (s *SearchStage) {
+		s = &SearchStage{}
+		if err := s.Init(&manager); err != nil {
+			t.Errorf("%s", err.Error())
+		}
+		s.expect(query, 2)
+		return
+	}
+
+	//page 1 is stored, page 2 is given up: the watermark isn't saved
+	s := newRun()
+	if err = s.ProcessResponse(searchPage(150, "a", "b"), 0, gitRequestParams{query, "password", 1}); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if _, err = manager.SelectWatermark(query); err != sql.ErrNoRows {
+		t.Errorf("Expected no watermark after failed page 2, got: %v", err)
+	}
+
+	//next run walks the query again although results of page 1 are reported
+	s = newRun()
+	known, err := s.knownPage(query, []GitSearchItem{{ShaHash: "a"}, {ShaHash: "b"}})
+	if err != nil || known {
+		t.Errorf("Expected query of the incomplete run not to be known, got: %v %v", known, err)
+	}
+
+	if err = s.ProcessResponse(searchPage(150, "a", "b"), 0, gitRequestParams{query, "password", 1}); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	page2 := stage.Request{ID: 1, Params: gitRequestParams{query, "password", 2}}
+	if s.SkipRequest(page2) {
+		t.Errorf("Expected page 2 not to be skipped")
+	}
+
+	if err = s.ProcessResponse(searchPage(150, "c"), 1, gitRequestParams{query, "password", 2}); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	exist, err := manager.CheckReportDuplicate("c")
+	if err != nil || !exist {
+		t.Errorf("Expected result of page 2 to be reported, got: %v %v", exist, err)
+	}
+
+	//every page is done, results of all pages become the watermark
+	wm, err := manager.SelectWatermark(query)
+	if err != nil || !wm.Seen("a") || !wm.Seen("b") || !wm.Seen("c") || wm.Total != 150 {
+		t.Errorf("Expected watermark of both pages, got: %v %v", wm, err)
+	}
+
+	s = newRun()
+	known, err = s.knownPage(query, []GitSearchItem{{ShaHash: "a"}, {ShaHash: "b"}})
+	if err != nil || !known {
+		t.Errorf("Expected query of the complete run to be known, got: %v %v", known, err)
+	}
+	return
+}
+
+func TestSearchWatermarkExhausted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megamon")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := models.OpenPool(utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.Close()
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	query := "password+in:file"
+	newRun := func(pages int) (s *SearchStage) {
+		s = &SearchStage{}
+		if err := s.Init(&manager); err != nil {
+			t.Errorf("%s", err.Error())
+		}
+		s.expect(query, pages)
+		return
+	}
+
+	//previous run walks both pages of the query
+	s := newRun(2)
+	if err = s.ProcessResponse(searchPage(4, "a", "b"), 0, gitRequestParams{query, "password", 1}); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if err = s.ProcessResponse(searchPage(4, "c", "d"), 1, gitRequestParams{query, "password", 2}); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	s = newRun(3)
+
+	//one new result pushes the older ones down, page 2 has only results of the previous run
+	if err = s.ProcessResponse(searchPage(5, "n", "a"), 0, gitRequestParams{query, "password", 1}); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	page2 := stage.Request{ID: 1, Params: gitRequestParams{query, "password", 2}}
+	if s.SkipRequest(page2) {
+		t.Errorf("Expected page 2 not to be skipped")
+	}
+
+	if err = s.ProcessResponse(searchPage(5, "b", "c"), 1, gitRequestParams{query, "password", 2}); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	page3 := stage.Request{ID: 2, Params: gitRequestParams{query, "password", 3}}
+	if !s.SkipRequest(page3) {
+		t.Errorf("Expected page 3 to be skipped")
+	}
+
+	//results of the skipped page are kept from the previous watermark
+	wm, err := manager.SelectWatermark(query)
+	if err != nil || strings.Join(wm.ShaHashes, ",") != "n,a,b,c,d" || wm.Total != 5 {
+		t.Errorf("Wrong watermark: %v %v", wm, err)
+	}
+	return
+}
@@ -3,18 +3,26 @@ package github
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/megamon/core/leaks/allowlist"
 	"github.com/megamon/core/leaks/models"
 	"github.com/megamon/core/leaks/stage"
+	"github.com/megamon/core/leaks/taskrun"
+	"github.com/megamon/core/leaks/tokens"
 	"github.com/megamon/core/utils"
 	"golang.org/x/time/rate"
 )
 
+//searchAPI : code search endpoint, tests replace it with a fake one
+var searchAPI = "https://api.github.com/search/code"
+
 func buildGitSearchQuery(keyword string, lang string, infile bool) (query string) {
 	query = keyword
 	if infile {
@@ -28,11 +36,13 @@ func buildGitSearchQuery(keyword string, lang string, infile bool) (query string
 	return query
 }
 
-func buildGitSearchRequest(query string, offset int, token string) (req *http.Request, err error) {
-	logInfo(fmt.Sprintf("building search request: %s %d %s", query, offset, token[:4]))
+//buildGitSearchRequest : page of results, recently indexed first, pages start from 1
+//Request is signed with a token when it is sent
+func buildGitSearchRequest(query string, page int) (req *http.Request, err error) {
+	logInfo(fmt.Sprintf("building search request: %s %d", query, page))
 
 	var requestBody bytes.Buffer
-	url := fmt.Sprintf("https://api.github.com/search/code?q=%s&per_page=100&page=%d", query, offset)
+	url := fmt.Sprintf("%s?q=%s&per_page=100&page=%d&sort=indexed&order=desc", searchAPI, query, page)
 
 	req, err = http.NewRequest("GET", url, &requestBody)
 
@@ -40,7 +50,6 @@ func buildGitSearchRequest(query string, offset int, token string) (req *http.Re
 		return
 	}
 
-	req.Header.Set("Authorization", "token "+token)
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 	req.Header.Set("Accept-Encoding", "deflate, gzip;q=1.0, *;q=0.5")
 	req.Header.Set("Connection", "close")
@@ -48,99 +57,125 @@ func buildGitSearchRequest(query string, offset int, token string) (req *http.Re
 }
 
 //SearchStage : type of the stage interface
+//Query is exhausted when its page has no results unseen by the last complete run, further pages of it are skipped
+//Watermark of the query is moved only after every page of it is done in the run
 type SearchStage struct {
-	RequestParams map[int]gitRequestParams
-	Manager       models.Manager
+	Manager   models.Storage
+	Allowlist *allowlist.Allowlist
+
+	//Run : task run new reports & coverage of keywords are recorded in
+	Run *taskrun.Run
+
+	//Pool : requests & probes are signed with its tokens when they are sent
+	*tokens.Pool
+
+	//Retry : policy of the failed probes, requests are repeated by the stage
+	Retry stage.RetryPolicy
+
+	mu         sync.Mutex
+	watermarks map[string]models.Watermark
+	exhausted  map[string]bool
+	progress   map[string]*queryProgress
 }
 
-//Init : constructor
-func (s *SearchStage) Init() (err error) {
-	s.RequestParams = make(map[int]gitRequestParams)
-	err = s.Manager.Init()
-	return
+//queryProgress : pages of the query queued in the run, results of the done pages wait until all of them are done
+type queryProgress struct {
+	pages, done int
+	total       int
+	hashes      map[int][]string
 }
 
-//Close : destructor
-func (s *SearchStage) Close() {
-	s.Manager.Close()
+//Init : constructor, manager is shared with other stages
+func (s *SearchStage) Init(manager models.Storage) (err error) {
+	s.watermarks = make(map[string]models.Watermark)
+	s.exhausted = make(map[string]bool)
+	s.progress = make(map[string]*queryProgress)
+	s.Manager = manager
+	s.Pool = tokens.Default()
+	s.Retry = stage.NewRetryPolicy(stage.OptionsOf("search"))
+
+	s.Allowlist, err = allowlist.Load(s.Manager)
 	return
 }
 
 //GetDBManager : stage interface realization
-func (s *SearchStage) GetDBManager() models.Manager {
+func (s *SearchStage) GetDBManager() models.Storage {
 	return s.Manager
 }
 
 //BuildRequests : generate search requests
-func (s *SearchStage) BuildRequests(reqQueue chan stage.Request) (err error) {
+//Keyword with more results than pagination reaches is split into partitions, coverage of the keyword is saved
+//First page of every partition is known from its probe, so it is processed at once & requests start from page 2
+func (s *SearchStage) BuildRequests(ctx context.Context, reqQueue chan stage.Request) (err error) {
 	keywords, err := s.Manager.SelectKeywordByType(models.KWSEARCHABLE)
 	if err != nil {
 		logErr(err)
 		return
 	}
 
-	tokens := utils.Settings.Github.Tokens
 	desiredRate := rate.Limit(utils.Settings.Github.RequestRate) * rate.Every(time.Second)
 	rl := rate.NewLimiter(desiredRate, 1)
-	ctx := context.Background()
 	id := 0
 
-	// nQueries := len(keywords) * len(langs)
-	for i, lang := range Langs {
-		for j, keyword := range keywords {
-			query := buildGitSearchQuery(keyword.Value, lang, false)
-			token := tokens[(i*len(keywords)+j)%len(tokens)]
+	probe := func(query string) (GitSearchAPIResponse, error) {
+		return s.probe(ctx, rl, query)
+	}
 
-			offset := 0
-			req, err := buildGitSearchRequest(query, offset, token)
+	for _, keyword := range keywords {
+		leaves, coverage, err := partitionKeyword(keyword.Value, probe)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-			if err != nil {
-				logErr(err)
-				continue
-			}
+		if err != nil {
+			logErr(err)
+			continue
+		}
 
-			_ = rl.Wait(ctx)
-			resp, err := utils.DoRequest(req)
+		message := fmt.Sprintf("keyword %s: %d of %d results in %d partitions (%d truncated), coverage %.1f%%",
+			keyword.Value, coverage.Retrievable, coverage.Total, coverage.Partitions, coverage.Truncated, coverage.Ratio*100)
+		logInfo(message)
+		s.Run.Log(models.LOGINFO, message)
+		err = s.Manager.SaveCoverage(coverage)
+		if err != nil {
+			logErr(err)
+		}
+
+		for _, leaf := range leaves {
+			query := leaf.Query()
+
+			//the newest results were seen by the previous run, so are the older ones
+			known, err := s.knownPage(query, leaf.Items)
 			if err != nil {
 				logErr(err)
 				continue
 			}
 
-			bodyReader, err := utils.GetBodyReader(resp)
-			if err != nil {
-				logErr(err)
+			if known {
+				logInfo(fmt.Sprintf("no new results for %s", query))
 				continue
 			}
 
-			body, err := ioutil.ReadAll(bodyReader)
-			bodyReader.Close()
-
-			var githubResponse GitSearchAPIResponse
-			err = json.Unmarshal(body, &githubResponse)
+			s.expect(query, leaf.Pages())
+			first := 2
+			err = s.processPage(GitSearchAPIResponse{TotalCount: leaf.Total, Items: leaf.Items}, gitRequestParams{query, keyword.Value, 1})
 			if err != nil {
 				logErr(err)
-				continue
-			}
-
-			n := int(githubResponse.TotalCount / MAXRESPONSEITEMS)
-			if githubResponse.TotalCount%MAXRESPONSEITEMS != 0 {
-				n++
-			}
-
-			if n > MAXOFFSET {
-				n = MAXOFFSET
+				first = 1
 			}
 
-			for offset := 0; offset < n; offset++ {
-				token := tokens[id%len(tokens)]
-				req, err := buildGitSearchRequest(query, offset, token)
+			for page := first; page <= leaf.Pages(); page++ {
+				req, err := buildGitSearchRequest(query, page)
 				if err != nil {
 					logErr(err)
 					continue
 				}
 
-				reqQueue <- stage.Request{ID: id, Req: req}
-				s.RequestParams[id] = gitRequestParams{query, keyword.Value, offset}
+				params := gitRequestParams{query, keyword.Value, page}
+				err = stage.Enqueue(ctx, reqQueue, stage.Request{ID: id, Req: req, Params: params})
+				if err != nil {
+					return err
+				}
 				id++
 			}
 		}
@@ -148,32 +183,263 @@ func (s *SearchStage) BuildRequests(reqQueue chan stage.Request) (err error) {
 	return
 }
 
-//CheckResponse : check reponse
+//probe : first page of the query, failed attempts are repeated or given up as the retry policy decides
+//Failed probe is an error, its partition is not taken for one without results
+func (s *SearchStage) probe(ctx context.Context, rl *rate.Limiter, query string) (githubResponse GitSearchAPIResponse, err error) {
+	for attempt := 1; ; attempt++ {
+		var req *http.Request
+		req, err = buildGitSearchRequest(query, 1)
+		if err != nil {
+			return
+		}
+
+		err = rl.Wait(ctx)
+		if err != nil {
+			return
+		}
+
+		var token string
+		token, err = s.Authorize(ctx, req)
+		if err != nil {
+			return
+		}
+
+		resp, rErr := utils.DoRequest(req.WithContext(ctx))
+		s.Observe(token, resp, rErr)
+		if rErr == nil && resp.StatusCode == http.StatusOK {
+			return readSearchResponse(resp)
+		}
+
+		if rErr == nil {
+			resp.Body.Close()
+		}
+
+		//pool waits for the reset if no other token has quota left
+		retry := s.Retry.Reauthorized(s.Retry.Decide(resp, rErr, attempt))
+		if !retry.Repeat {
+			err = fmt.Errorf("probe of %s failed: %s", query, retry.Reason)
+			return
+		}
+
+		logInfo(fmt.Sprintf("repeating probe of %s in %s: %s", query, retry.Delay, retry.Reason))
+		if !s.Retry.Sleep(ctx, retry.Delay) {
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+//readSearchResponse : decode search response, body is closed
+func readSearchResponse(resp *http.Response) (githubResponse GitSearchAPIResponse, err error) {
+	defer resp.Body.Close()
+	bodyReader, err := utils.GetBodyReader(resp)
+	if err != nil {
+		return
+	}
+
+	body, err := ioutil.ReadAll(bodyReader)
+	bodyReader.Close()
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &githubResponse)
+	return
+}
+
+//watermark : results of the query seen by the last complete run, loaded once per run
+func (s *SearchStage) watermark(query string) (wm models.Watermark, err error) {
+	s.mu.Lock()
+	wm, ok := s.watermarks[query]
+	s.mu.Unlock()
+	if ok {
+		return
+	}
+
+	wm, err = s.Manager.SelectWatermark(query)
+	if err == sql.ErrNoRows {
+		wm, err = models.Watermark{Query: query}, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.watermarks[query] = wm
+	s.mu.Unlock()
+	return
+}
+
+//knownPage : all results of the page were seen by the last run that walked the whole query, empty page is not known
+//Results reported by an incomplete run or another query don't count, older results of the query may be missing
+func (s *SearchStage) knownPage(query string, items []GitSearchItem) (known bool, err error) {
+	if len(items) == 0 {
+		return false, nil
+	}
+
+	wm, err := s.watermark(query)
+	if err != nil {
+		return
+	}
+
+	for _, item := range items {
+		if !wm.Seen(item.ShaHash) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+//expect : pages of the query queued in the run
+func (s *SearchStage) expect(query string, pages int) {
+	s.mu.Lock()
+	s.progress[query] = &queryProgress{pages: pages, hashes: make(map[int][]string)}
+	s.mu.Unlock()
+}
+
+//pageDone : page of the query was processed with its results or skipped without them
+//Watermark is saved after the last page, query with a failed page keeps the old one & is walked again by the next run
+//Pages restored from the interrupted run aren't tracked, so their query keeps the old watermark too
+func (s *SearchStage) pageDone(query string, page int, hashes []string, total int) (err error) {
+	s.mu.Lock()
+	progress, ok := s.progress[query]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+
+	if page > 0 {
+		progress.hashes[page] = hashes
+		if page == 1 {
+			progress.total = total
+		}
+	}
+
+	progress.done++
+	_, first := progress.hashes[1]
+	if progress.done < progress.pages || !first {
+		s.mu.Unlock()
+		return
+	}
+
+	delete(s.progress, query)
+	s.mu.Unlock()
+
+	wm, err := s.watermark(query)
+	if err != nil {
+		return
+	}
+	return s.Manager.SaveWatermark(seenResults(wm, progress))
+}
+
+//seenResults : results of the walked pages followed by the older ones of the previous watermark
+//Pagination doesn't reach past MAXRESULTS of the query, older results are dropped
+func seenResults(previous models.Watermark, progress *queryProgress) (wm models.Watermark) {
+	wm = models.Watermark{Query: previous.Query, Total: progress.total}
+	added := make(map[string]bool)
+	add := func(hashes []string) {
+		for _, hash := range hashes {
+			if added[hash] || len(wm.ShaHashes) >= MAXRESULTS {
+				continue
+			}
+			added[hash] = true
+			wm.ShaHashes = append(wm.ShaHashes, hash)
+		}
+	}
+
+	for page := 1; page <= progress.pages; page++ {
+		add(progress.hashes[page])
+	}
+	add(previous.ShaHashes)
+	return
+}
+
+//SkipRequest : skipper interface realization, pages of exhausted queries are not requested & count as done
+func (s *SearchStage) SkipRequest(req stage.Request) bool {
+	params, ok := req.Params.(gitRequestParams)
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	exhausted := s.exhausted[params.Query]
+	s.mu.Unlock()
+	if !exhausted {
+		return false
+	}
+
+	err := s.pageDone(params.Query, 0, nil, 0)
+	if err != nil {
+		logErr(err)
+	}
+	return true
+}
+
+//Name : checkpointer interface realization
+func (s *SearchStage) Name() string {
+	return "github"
+}
+
+//RestoreRequest : checkpointer interface realization, request is signed when it is sent
+func (s *SearchStage) RestoreRequest(requestID int, data []byte) (req stage.Request, err error) {
+	var params gitRequestParams
+	err = json.Unmarshal(data, &params)
+	if err != nil {
+		return
+	}
+
+	httpReq, err := buildGitSearchRequest(params.Query, params.Offset)
+	if err != nil {
+		return
+	}
+
+	return stage.Request{ID: requestID, Req: httpReq, Params: params}, nil
+}
+
+//CheckResponse : check reponse, failed request is repeated or given up by the retry policy
 func (s *SearchStage) CheckResponse(resp stage.Response, reqCount int) (res int) {
 	switch resp.Resp.StatusCode {
 	case 200:
 		return stage.OK
-	case 403:
-		fallthrough
 	default:
-		if reqCount < stage.MAXRETRIES {
-			return stage.WAIT
-		}
-		return stage.SKIP
+		return stage.WAIT
 	}
 }
 
 //ProcessResponse : process search response
-func (s *SearchStage) ProcessResponse(resp []byte, requestID int) (err error) {
+func (s *SearchStage) ProcessResponse(resp []byte, requestID int, requestParams interface{}) (err error) {
 	logInfo(fmt.Sprintf("processing search API response from request : %d", requestID))
 
+	params, ok := requestParams.(gitRequestParams)
+	if !ok {
+		return fmt.Errorf("search request %d: unexpected params %T", requestID, requestParams)
+	}
+
 	var githubResponse GitSearchAPIResponse
 	err = json.Unmarshal(resp, &githubResponse)
 	if err != nil {
 		return
 	}
+	return s.processPage(githubResponse, params)
+}
 
+//processPage : report new results of the page, responses & first pages known from the probes are processed alike
+func (s *SearchStage) processPage(githubResponse GitSearchAPIResponse, params gitRequestParams) (err error) {
+	wm, err := s.watermark(params.Query)
+	if err != nil {
+		return
+	}
+
+	//results reported by an incomplete run or another query are fresh for the walk, they aren't reported again
+	fresh := 0
+	reports := make([]models.Report, 0, len(githubResponse.Items))
 	for _, gihubResponseItem := range githubResponse.Items {
+		if wm.Seen(gihubResponseItem.ShaHash) {
+			continue
+		}
+
+		fresh++
 		exist, err := s.Manager.CheckReportDuplicate(gihubResponseItem.ShaHash)
 
 		if err != nil {
@@ -185,11 +451,23 @@ func (s *SearchStage) ProcessResponse(resp []byte, requestID int) (err error) {
 			continue
 		}
 
+		if suppressed(s.Manager, s.Allowlist, gihubResponseItem) {
+			continue
+		}
+
 		var report models.Report
 		report.Type = "github"
 		report.Status = stage.PROCESSED
 		report.Time = time.Now().Unix()
 		report.ShaHash = gihubResponseItem.ShaHash
+		report.Source = models.Source{
+			URL:      gihubResponseItem.HTMLURL,
+			Repo:     gihubResponseItem.Repo.FullName,
+			Owner:    gihubResponseItem.Repo.Owner.Login,
+			Path:     gihubResponseItem.Path,
+			Language: models.LanguageByPath(gihubResponseItem.Path),
+			Keyword:  params.Keyword,
+		}
 
 		data, err := json.Marshal(gihubResponseItem)
 		if err != nil {
@@ -197,12 +475,28 @@ func (s *SearchStage) ProcessResponse(resp []byte, requestID int) (err error) {
 		}
 
 		report.Data = data
-		_, err = s.Manager.InsertReport(report)
+		reports = append(reports, report)
+	}
 
-		if err != nil {
-			return err
-		}
+	//results are ordered by index time, so the older pages have no new results either
+	if len(githubResponse.Items) > 0 && fresh == 0 {
+		logInfo(fmt.Sprintf("page %d of %s has no new results, skipping the rest", params.Offset, params.Query))
+		s.mu.Lock()
+		s.exhausted[params.Query] = true
+		s.mu.Unlock()
 	}
 
-	return
+	//reports of the page are written at once, the same file found by another query is skipped
+	inserted, err := s.Manager.InsertReports(reports)
+	s.Run.AddReports(inserted)
+	if err != nil {
+		return
+	}
+
+	//this run keeps comparing with the previous one, seen results are saved once the whole query is done
+	hashes := make([]string, 0, len(githubResponse.Items))
+	for _, item := range githubResponse.Items {
+		hashes = append(hashes, item.ShaHash)
+	}
+	return s.pageDone(params.Query, params.Offset, hashes, githubResponse.TotalCount)
 }
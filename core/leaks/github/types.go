@@ -10,7 +10,7 @@ const (
 	//MAXRESPONSEITEMS : max items in search response
 	MAXRESPONSEITEMS = 100
 
-	//MAXOFFSET : maximum offset supported by github API
+	//MAXOFFSET : maximum number of pages supported by github API
 	MAXOFFSET = 10
 )
 
@@ -25,12 +25,19 @@ type gitRepo struct {
 	Owner    gitRepoOwner `json:"owner"`
 }
 
+//gitRequestParams : query & page of the search request, pages start from 1
 type gitRequestParams struct {
 	Query   string
 	Keyword string
 	Offset  int
 }
 
+//fetchRequestParams : report the content of the fetch request belongs to
+type fetchRequestParams struct {
+	ReportID int
+	ShaHash  string
+}
+
 //GitSearchItem : search item format
 type GitSearchItem struct {
 	Name    string  `json:"name"`
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+)
+
+//AuditTable : global name for table with audit log
+var AuditTable = "audit_log"
+
+//AuditEntry : action of a reviewer or an admin
+//Old & New hold JSON encoded values of the target before & after the action
+type AuditEntry struct {
+	ID        int    `json:"id"`
+	Time      int64  `json:"time"`
+	Actor     string `json:"actor"`
+	Action    string `json:"action"`
+	Target    string `json:"target"`
+	TargetIDs []int  `json:"target_ids"`
+	Old       string `json:"old"`
+	New       string `json:"new"`
+	ClientIP  string `json:"client_ip"`
+}
+
+//AuditFilter : conditions of audit log selection, zero values are ignored
+//TargetID matches entries with the id among their targets, time range is exclusive
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Target   string
+	TargetID int
+	Since    int
+	Until    int
+	Page
+}
+
+//auditSortColumns : columns audit log may be sorted by
+var auditSortColumns = map[string]string{"id": "id", "time": "time", "actor": "actor", "action": "action"}
+
+//joinIDs : target ids are stored as comma separated list
+func joinIDs(IDs []int) string {
+	values := make([]string, 0, len(IDs))
+	for _, ID := range IDs {
+		values = append(values, strconv.Itoa(ID))
+	}
+	return strings.Join(values, ",")
+}
+
+func splitIDs(value string) (IDs []int, err error) {
+	IDs = []int{}
+	if value == "" {
+		return
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		ID, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		IDs = append(IDs, ID)
+	}
+	return
+}
+
+func (filter AuditFilter) where() (w *where) {
+	w = &where{}
+	if filter.Actor != "" {
+		w.add("actor", "=", filter.Actor)
+	}
+
+	if filter.Action != "" {
+		w.add("action", "=", filter.Action)
+	}
+
+	if filter.Target != "" {
+		w.add("target", "=", filter.Target)
+	}
+
+	//delimiters on both sides, so 1 doesn't match 10
+	if filter.TargetID != 0 {
+		w.add("(',' || target_ids || ',')", " LIKE ", "%,"+strconv.Itoa(filter.TargetID)+",%")
+	}
+
+	if filter.Since != 0 {
+		w.add("time", ">", filter.Since)
+	}
+
+	if filter.Until != 0 {
+		w.add("time", "<", filter.Until)
+	}
+	return
+}
+
+//InsertAuditEntry : append entry to the audit log
+func (manager *Manager) InsertAuditEntry(entry AuditEntry) (ID int, err error) {
+	query := "INSERT INTO " + AuditTable + " (time, actor, action, target, target_ids, old_value, new_value, client_ip) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;"
+	err = manager.queryRow(query, entry.Time, entry.Actor, entry.Action, entry.Target, joinIDs(entry.TargetIDs), entry.Old, entry.New, entry.ClientIP).Scan(&ID)
+	return
+}
+
+//CountAuditEntries : return count of audit entries matching the filter, pagination is ignored
+func (manager *Manager) CountAuditEntries(filter AuditFilter) (count int, err error) {
+	w := filter.where()
+	query := "SELECT COUNT(id) FROM " + AuditTable + w.String() + ";"
+	err = manager.queryRow(query, w.args...).Scan(&count)
+	return
+}
+
+//SelectAuditEntries : select audit entries matching the filter
+func (manager *Manager) SelectAuditEntries(filter AuditFilter) (entries []AuditEntry, err error) {
+	w := filter.where()
+	page, err := w.page(filter.Page, auditSortColumns)
+	if err != nil {
+		return
+	}
+
+	query := "SELECT id, time, actor, action, target, target_ids, old_value, new_value, client_ip FROM " + AuditTable + w.String() + page + ";"
+	rows, err := manager.query(query, w.args...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var entry AuditEntry
+		var targetIDs string
+
+		err = rows.Scan(&entry.ID, &entry.Time, &entry.Actor, &entry.Action, &entry.Target, &targetIDs, &entry.Old, &entry.New, &entry.ClientIP)
+		if err != nil {
+			return
+		}
+
+		entry.TargetIDs, err = splitIDs(targetIDs)
+		if err != nil {
+			return
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
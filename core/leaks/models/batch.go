@@ -0,0 +1,133 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+//insertBatchSize : rows per INSERT statement, keeps the number of parameters below the limits of both databases
+const insertBatchSize = 500
+
+//fragmentInsertColumns : columns written by InsertTextFragments
+var fragmentInsertColumns = []string{"content", "reject_id", "report_id", "type", "shahash", "keywords", "score"}
+
+//transaction : run fn in a transaction, the whole transaction is repeated on transient errors
+//Manager bound by Transaction runs fn in its transaction
+func (manager *Manager) transaction(fn func(tx *sql.Tx) error) (err error) {
+	if manager.tx != nil {
+		return fn(manager.tx)
+	}
+
+	return manager.retry(func() (err error) {
+		tx, err := manager.Database.Begin()
+		if err != nil {
+			return
+		}
+
+		err = fn(tx)
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		return tx.Commit()
+	})
+}
+
+//Transaction : run fn with storage bound to one transaction, it is committed if fn returns nil
+//The whole transaction is repeated on transient errors, so fn must not keep results of a failed attempt
+func (manager *Manager) Transaction(fn func(tx Storage) error) (err error) {
+	return manager.transaction(func(tx *sql.Tx) error {
+		bound := *manager
+		bound.tx = tx
+		return fn(&bound)
+	})
+}
+
+//insertRows : multi-row INSERT of values in batches, rows with the existing natural key (shahash) are skipped
+func (manager *Manager) insertRows(tx *sql.Tx, table string, columns []string, rows [][]interface{}) (inserted int, err error) {
+	d := manager.dialect()
+	for start := 0; start < len(rows); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		var args []interface{}
+		tuples := make([]string, 0, end-start)
+		for _, row := range rows[start:end] {
+			placeholders := make([]string, 0, len(row))
+			for _, value := range row {
+				args = append(args, value)
+				placeholders = append(placeholders, "$"+strconv.Itoa(len(args)))
+			}
+			tuples = append(tuples, "("+strings.Join(placeholders, ", ")+")")
+		}
+
+		query := "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES " + strings.Join(tuples, ", ") + " ON CONFLICT (shahash) DO NOTHING;"
+		result, err := tx.Exec(d.Rebind(query), args...)
+		if err != nil {
+			return inserted, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return inserted, err
+		}
+		inserted += int(affected)
+	}
+	return
+}
+
+func (manager *Manager) insertTextFragments(tx *sql.Tx, frags []TextFragment) (inserted int, err error) {
+	rows := make([][]interface{}, 0, len(frags))
+	for _, frag := range frags {
+		kwData, err := json.Marshal(frag.Keywords)
+		if err != nil {
+			return 0, err
+		}
+		rows = append(rows, []interface{}{[]byte(frag.Text), frag.RejectID, frag.ReportID, frag.Type, frag.ShaHash, kwData, frag.Score})
+	}
+	return manager.insertRows(tx, FragmentTable, fragmentInsertColumns, rows)
+}
+
+//InsertTextFragments : insert fragments in one transaction, duplicates by hash are skipped
+func (manager *Manager) InsertTextFragments(frags []TextFragment) (inserted int, err error) {
+	err = manager.transaction(func(tx *sql.Tx) (err error) {
+		inserted, err = manager.insertTextFragments(tx, frags)
+		return
+	})
+	return
+}
+
+//CommitReportFragments : insert fragments of the report & set its status in one transaction
+//Report keeps its status if the run is interrupted, so it is fragmentized again instead of being left half done
+func (manager *Manager) CommitReportFragments(reportID int, frags []TextFragment, status string) (inserted int, err error) {
+	err = manager.transaction(func(tx *sql.Tx) (err error) {
+		inserted, err = manager.insertTextFragments(tx, frags)
+		if err != nil {
+			return
+		}
+
+		query := "UPDATE " + ReportTable + " SET status=$2 WHERE id=$1;"
+		_, err = tx.Exec(manager.dialect().Rebind(query), reportID, status)
+		return
+	})
+	return
+}
+
+//InsertReports : insert reports in one transaction, duplicates by hash are skipped
+func (manager *Manager) InsertReports(reports []Report) (inserted int, err error) {
+	columns := append([]string{"shahash", "type", "status", "data", "time"}, sourceColumns...)
+	rows := make([][]interface{}, 0, len(reports))
+	for _, report := range reports {
+		rows = append(rows, append([]interface{}{report.ShaHash, report.Type, report.Status, report.Data, report.Time}, report.Source.values()...))
+	}
+
+	err = manager.transaction(func(tx *sql.Tx) (err error) {
+		inserted, err = manager.insertRows(tx, ReportTable, columns, rows)
+		return
+	})
+	return
+}
@@ -3,18 +3,36 @@ package models
 import (
 	"database/sql"
 	"encoding/json"
+	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"fmt"
 
 	//Postgresql driver
 	_ "github.com/lib/pq"
+	"github.com/megamon/core/leaks/expr"
 	"github.com/megamon/core/utils"
 )
 
 //Manager : database manager for all types
+//Dialect hides differences between supported databases, Postgres is used if it is not set
+//Manager is a handle to the shared pool, copies use the same connections
 type Manager struct {
 	Database *sql.DB
+	Dialect  Dialect
+
+	//Retries : attempts to repeat statements failed with transient errors
+	Retries    int
+	RetryDelay time.Duration
+
+	//PingTimeout : time to wait for the database in health checks
+	PingTimeout time.Duration
+
+	//tx : transaction the statements of the manager bound by Transaction run in
+	tx *sql.Tx
 }
 
 //ReportTable : global name for table with reports
@@ -29,29 +47,75 @@ var RuleTable = "rules"
 //KeywordsTable : global name for table with keywords
 var KeywordsTable = "keywords"
 
-//Init : Manager constructor
+//AllowlistTable : global name for table with allowlist entries
+var AllowlistTable = "allowlist"
+
+//Init : Manager constructor, opens pool configured in settings
+//Process opens the pool once and passes the manager to stages & backend
 func (manager *Manager) Init() (err error) {
-	creds := utils.Settings.DBCredentials
-	conn, err := Connect(creds.Name, creds.Password, creds.DBHostName, creds.Database)
-	if err != nil {
+	*manager, err = OpenPool(utils.Settings.DBCredentials)
+	return
+}
+
+//Close : Manager destructor, closes the pool for all copies of the manager
+//Manager bound to a transaction leaves the pool open
+func (manager *Manager) Close() {
+	if manager.Database != nil && manager.tx == nil {
+		manager.Database.Close()
+	}
+	return
+}
+
+func (manager *Manager) dialect() Dialect {
+	if manager.Dialect == nil {
+		return postgresDialect{}
+	}
+	return manager.Dialect
+}
+
+//exec, query & queryRow : statement in the bound transaction or on the pool with retries of transient errors
+func (manager *Manager) exec(query string, args ...interface{}) (result sql.Result, err error) {
+	query = manager.dialect().Rebind(query)
+	if manager.tx != nil {
+		return manager.tx.Exec(query, args...)
+	}
+
+	err = manager.retry(func() (err error) {
+		result, err = manager.Database.Exec(query, args...)
 		return
+	})
+	return
+}
+
+func (manager *Manager) query(query string, args ...interface{}) (rows *sql.Rows, err error) {
+	query = manager.dialect().Rebind(query)
+	if manager.tx != nil {
+		return manager.tx.Query(query, args...)
 	}
 
-	manager.Database = conn
+	err = manager.retry(func() (err error) {
+		rows, err = manager.Database.Query(query, args...)
+		return
+	})
 	return
 }
 
-//Close : Manager destructor
-func (manager *Manager) Close() {
-	if manager.Database != nil {
-		manager.Database.Close()
+func (manager *Manager) queryRow(query string, args ...interface{}) (row *sql.Row) {
+	query = manager.dialect().Rebind(query)
+	if manager.tx != nil {
+		return manager.tx.QueryRow(query, args...)
 	}
+
+	manager.retry(func() error {
+		row = manager.Database.QueryRow(query, args...)
+		return row.Err()
+	})
 	return
 }
 
 //InsertTextFragment : insert text fragment into db
 func (manager *Manager) InsertTextFragment(frag *TextFragment) (ID int, err error) {
-	query := "INSERT INTO " + FragmentTable + " (content, reject_id, report_id, type, shahash, keywords) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;"
+	query := "INSERT INTO " + FragmentTable + " (content, reject_id, report_id, type, shahash, keywords, score) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;"
 	kwData, err := json.Marshal(frag.Keywords)
 	content := []byte(frag.Text)
 
@@ -59,131 +123,194 @@ func (manager *Manager) InsertTextFragment(frag *TextFragment) (ID int, err erro
 		return 0, err
 	}
 
-	err = manager.Database.QueryRow(query, content, frag.RejectID, frag.ReportID, frag.Type, frag.ShaHash, kwData).Scan(&ID)
+	err = manager.queryRow(query, content, frag.RejectID, frag.ReportID, frag.Type, frag.ShaHash, kwData, frag.Score).Scan(&ID)
 	return
 }
 
 //UpdateTextFragmentRejectID : updates text fragment in db
 func (manager *Manager) UpdateTextFragmentRejectID(ID, rejectID int) (err error) {
 	query := "UPDATE " + FragmentTable + " SET reject_id=$2 WHERE id=$1;"
-	_, err = manager.Database.Exec(query, ID, rejectID)
+	_, err = manager.exec(query, ID, rejectID)
 	return
 }
 
 //DeleteTextFragmentByID : deletes text fragment from db
 func (manager *Manager) DeleteTextFragmentByID(ID int) (err error) {
 	query := "DELETE FROM " + FragmentTable + " WHERE id=$1;"
-	_, err = manager.Database.Exec(query, ID)
+	_, err = manager.exec(query, ID)
 	return
 }
 
-//CountTextFragments : return count of text fragments with defined type
-func (manager *Manager) CountTextFragments(field string, value int, extensions ...string) (count int, err error) {
-	extension := ""
-	for _, ext := range extensions {
-		extension += ext
+//DeleteTextFragmentsByReport : delete all fragments of the report
+func (manager *Manager) DeleteTextFragmentsByReport(reportID int) (count int, err error) {
+	query := "DELETE FROM " + FragmentTable + " WHERE report_id=$1;"
+	result, err := manager.exec(query, reportID)
+	if err != nil {
+		return
+	}
+
+	deleted, err := result.RowsAffected()
+	count = int(deleted)
+	return
+}
+
+//fragmentsFrom : fragments (f) with their reports (r), fragments of deleted reports are kept
+func fragmentsFrom() string {
+	return " FROM " + FragmentTable + " f LEFT JOIN " + ReportTable + " r ON r.id = f.report_id"
+}
+
+//fragmentColumns : columns scanned by scanFragment
+func fragmentColumns() string {
+	return "f.id, f.content, f.reject_id, f.report_id, f.type, f.shahash, f.keywords, f.score, " + selectSource("r")
+}
+
+//scanFragment : scan row selected with fragmentColumns followed by extra destinations
+func scanFragment(rows *sql.Rows, extra ...interface{}) (frag TextFragment, err error) {
+	var content []byte
+	var kwData []byte
+
+	dest := []interface{}{&frag.ID, &content, &frag.RejectID, &frag.ReportID, &frag.Type, &frag.ShaHash, &kwData, &frag.Score}
+	dest = append(dest, frag.Source.fields()...)
+	err = rows.Scan(append(dest, extra...)...)
+	if err != nil {
+		return
 	}
 
-	query := "SELECT COUNT(id) FROM " + FragmentTable + " WHERE " + field + "=$1 " + extension + ";"
-	row := manager.Database.QueryRow(query, value)
+	err = json.Unmarshal(kwData, &frag.Keywords)
+	frag.Text = string(content)
+	return
+}
+
+//CountTextFragments : return count of text fragments matching the filter, pagination is ignored
+func (manager *Manager) CountTextFragments(filter FragmentFilter) (count int, err error) {
+	w := filter.where()
+	query := "SELECT COUNT(f.id)" + fragmentsFrom() + w.String() + ";"
+	row := manager.queryRow(query, w.args...)
 	err = row.Scan(&count)
 	return
 }
 
-//SelectTextFragment : select text fragment from db
-func (manager *Manager) SelectTextFragment(field string, value int, extensions ...string) (frags []TextFragment, err error) {
-	extension := ""
-	for _, ext := range extensions {
-		extension += ext
+//SelectTextFragments : select text fragments matching the filter
+func (manager *Manager) SelectTextFragments(filter FragmentFilter) (frags []TextFragment, err error) {
+	w := filter.where()
+	page, err := w.page(filter.Page, fragmentSortColumns)
+	if err != nil {
+		return
 	}
 
-	query := "SELECT id, content, reject_id, report_id, type, shahash, keywords FROM " + FragmentTable + " WHERE " + field + "=$1 " + extension + ";"
-	rows, err := manager.Database.Query(query, value)
+	query := "SELECT " + fragmentColumns() + fragmentsFrom() + w.String() + page + ";"
+	rows, err := manager.query(query, w.args...)
 
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var frag TextFragment
-		var content []byte
-		var kwData []byte
-
-		err = rows.Scan(&frag.ID, &content, &frag.RejectID, &frag.ReportID, &frag.Type, &frag.ShaHash, &kwData)
-		if err != nil {
-			return
-		}
-
-		err = json.Unmarshal(kwData, &frag.Keywords)
+		frag, err := scanFragment(rows)
 		if err != nil {
-			return
+			return nil, err
 		}
 
-		frag.Text = string(content)
 		frags = append(frags, frag)
 	}
 	return
 }
 
+//SelectTextFragmentByID : select single text fragment, sql.ErrNoRows if there is no such fragment
+func (manager *Manager) SelectTextFragmentByID(ID int) (frag TextFragment, err error) {
+	frags, err := manager.SelectTextFragments(FragmentFilter{ID: ID})
+	if err != nil {
+		return
+	}
+
+	if len(frags) == 0 {
+		return frag, sql.ErrNoRows
+	}
+	return frags[0], nil
+}
+
 //CheckTextFragmentDuplicate : Check for text fragment with the same hash
 func (manager *Manager) CheckTextFragmentDuplicate(ShaHash string) (exist bool, err error) {
 	query := "SELECT EXISTS(SELECT id FROM " + FragmentTable + " WHERE shahash=$1);"
 
-	row := manager.Database.QueryRow(query, ShaHash)
+	row := manager.queryRow(query, ShaHash)
 	err = row.Scan(&exist)
 	return
 }
 
 //InsertReport : inser report to db
 func (manager *Manager) InsertReport(report Report) (ID int, err error) {
-	query := "INSERT INTO " + ReportTable + " (shahash, type, status, data, time) VALUES ($1, $2, $3, $4, $5) RETURNING id;"
+	query := "INSERT INTO " + ReportTable + " (shahash, type, status, data, time, " + strings.Join(sourceColumns, ", ") + ") " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id;"
 
-	err = manager.Database.QueryRow(query, report.ShaHash, report.Type, report.Status, report.Data, report.Time).Scan(&ID)
+	args := append([]interface{}{report.ShaHash, report.Type, report.Status, report.Data, report.Time}, report.Source.values()...)
+	err = manager.queryRow(query, args...).Scan(&ID)
 	return
 }
 
 //UpdateReport : update report in db
 func (manager *Manager) UpdateReport(field, queryField string, newValue interface{}, queryValue interface{}) (err error) {
 	query := "UPDATE " + ReportTable + " SET $1=$2 WHERE $3=$4;"
-	_, err = manager.Database.Exec(query, field, newValue, queryField, queryValue)
+	_, err = manager.exec(query, field, newValue, queryField, queryValue)
 	return
 }
 
 //UpdateReportStatus : updates status of the report
 func (manager *Manager) UpdateReportStatus(reportID int, status string) (err error) {
 	query := "UPDATE " + ReportTable + " SET status=$2 WHERE id=$1;"
-	_, err = manager.Database.Exec(query, reportID, status)
+	_, err = manager.exec(query, reportID, status)
+	return
+}
+
+//UpdateReportsStatus : change status prev -> next for all reports of the type
+func (manager *Manager) UpdateReportsStatus(reportType, prev, next string) (err error) {
+	query := "UPDATE " + ReportTable + " SET status=$2 WHERE status=$1 AND type=$3;"
+	_, err = manager.exec(query, prev, next, reportType)
 	return
 }
 
 //UpdateReportTime : updates timestamp
 func (manager *Manager) UpdateReportTime(reportID int, timestamp int) (err error) {
 	query := "UPDATE " + ReportTable + " SET time=$2 WHERE id=$1;"
-	_, err = manager.Database.Exec(query, reportID, timestamp)
+	_, err = manager.exec(query, reportID, timestamp)
+	return
+}
+
+//UpdateReportPurged : store retention level applied to the report
+func (manager *Manager) UpdateReportPurged(reportID int, level int) (err error) {
+	query := "UPDATE " + ReportTable + " SET purged=$2 WHERE id=$1;"
+	_, err = manager.exec(query, reportID, level)
 	return
 }
 
 //DeleteReportByID : delete reprort from db
 func (manager *Manager) DeleteReportByID(ID int) (err error) {
 	query := "DELETE FROM " + ReportTable + " WHERE id=$1;"
-	_, err = manager.Database.Exec(query, ID)
+	_, err = manager.exec(query, ID)
 	return
 }
 
+//reportColumns : columns scanned by Report.fields
+func reportColumns() string {
+	return "id, type, status, data, shahash, time, purged, " + strings.Join(sourceColumns, ", ")
+}
+
+func (rep *Report) fields() []interface{} {
+	return append([]interface{}{&rep.ID, &rep.Type, &rep.Status, &rep.Data, &rep.ShaHash, &rep.Time, &rep.Purged}, rep.Source.fields()...)
+}
+
 //SelectReportByID : select report from db
 func (manager *Manager) SelectReportByID(ID int) (rep Report, err error) {
-	var shaHashStr string
-
-	query := "SELECT id, type, status, data, shahash, time FROM " + ReportTable + " WHERE id=$1;"
-	row := manager.Database.QueryRow(query, ID)
-	err = row.Scan(&rep.ID, &rep.Type, &rep.Status, &rep.Data, &shaHashStr, &rep.Time)
+	query := "SELECT " + reportColumns() + " FROM " + ReportTable + " WHERE id=$1;"
+	row := manager.queryRow(query, ID)
+	err = row.Scan(rep.fields()...)
 	return
 }
 
 //SelectReportTypes : select report types
 func (manager *Manager) SelectReportTypes() (types []string, err error) {
 	query := "SELECT DISTINCT type FROM " + ReportTable + ";"
-	rows, err := manager.Database.Query(query)
+	rows, err := manager.query(query)
 	if err != nil {
 		return
 	}
@@ -201,15 +328,16 @@ func (manager *Manager) SelectReportTypes() (types []string, err error) {
 	return
 }
 
-//SelectReportByStatus : select report by it's type & status
-func (manager *Manager) SelectReportByStatus(reportType, status string, extensions ...string) (reports []Report, err error) {
-	extension := ""
-	for _, ext := range extensions {
-		extension += ext
+//SelectReports : select reports matching the filter
+func (manager *Manager) SelectReports(filter ReportFilter) (reports []Report, err error) {
+	w := filter.where()
+	page, err := w.page(filter.Page, reportSortColumns)
+	if err != nil {
+		return
 	}
 
-	query := "SELECT id, type, status, data, shahash, time FROM " + ReportTable + " WHERE type=$1 AND status=$2 " + extension + ";"
-	rows, err := manager.Database.Query(query, reportType, status)
+	query := "SELECT " + reportColumns() + " FROM " + ReportTable + w.String() + page + ";"
+	rows, err := manager.query(query, w.args...)
 	if err != nil {
 		return
 	}
@@ -218,7 +346,7 @@ func (manager *Manager) SelectReportByStatus(reportType, status string, extensio
 	for rows.Next() {
 		var rep Report
 
-		err = rows.Scan(&rep.ID, &rep.Type, &rep.Status, &rep.Data, &rep.ShaHash, &rep.Time)
+		err = rows.Scan(rep.fields()...)
 		if err != nil {
 			return
 		}
@@ -233,73 +361,84 @@ func (manager *Manager) SelectReportByStatus(reportType, status string, extensio
 func (manager *Manager) CheckReportDuplicate(ShaHash string) (exist bool, err error) {
 	query := "SELECT EXISTS(SELECT id FROM " + ReportTable + " WHERE shahash=$1);"
 
-	row := manager.Database.QueryRow(query, ShaHash)
+	row := manager.queryRow(query, ShaHash)
 	err = row.Scan(&exist)
 	return
 }
 
-//CountReports : return count of text fragments with defined type
-func (manager *Manager) CountReports(reportType string, extensions ...string) (count int, err error) {
-	extension := ""
-	for _, ext := range extensions {
-		extension += ext
-	}
-
-	query := "SELECT COUNT(id) FROM " + ReportTable + " WHERE type=$1" + extension + ";"
-	row := manager.Database.QueryRow(query)
+//CountReports : return count of reports matching the filter, pagination is ignored
+func (manager *Manager) CountReports(filter ReportFilter) (count int, err error) {
+	w := filter.where()
+	query := "SELECT COUNT(id) FROM " + ReportTable + w.String() + ";"
+	row := manager.queryRow(query, w.args...)
 	err = row.Scan(&count)
 	return
 }
 
+//Compile : compile rule according to its kind
+func (rule *RejectRule) Compile() (err error) {
+	switch rule.Kind {
+	case RULEKINDEXPR:
+		rule.Cond, err = expr.Compile(rule.Rule)
+	case RULEKINDREGEXP, "":
+		rule.Kind = RULEKINDREGEXP
+		rule.Expr, err = regexp.Compile(rule.Rule)
+	default:
+		err = fmt.Errorf("unknown rule kind: %s", rule.Kind)
+	}
+	return
+}
+
 //InsertRule : inser rule into db
 func (manager *Manager) InsertRule(rule RejectRule) (ID int, err error) {
 	//Verify data consistency
-	if rule.Expr == nil {
-		rule.Expr, err = regexp.Compile(rule.Rule)
-		if err != nil {
-			return
-		}
+	err = rule.Compile()
+	if err != nil {
+		return
 	}
 
-	query := "INSERT INTO " + RuleTable + " (name, rule) VALUES ($1, $2) RETURNING id;"
-	err = manager.Database.QueryRow(query, rule.Name, rule.Rule).Scan(&ID)
+	query := "INSERT INTO " + RuleTable + " (name, rule, kind) VALUES ($1, $2, $3) RETURNING id;"
+	err = manager.queryRow(query, rule.Name, rule.Rule, rule.Kind).Scan(&ID)
 	return
 }
 
 //UpdateRule : update rule in database
 func (manager *Manager) UpdateRule(rule RejectRule) (err error) {
-	err = fmt.Errorf("Not implemented")
+	//Verify data consistency
+	err = rule.Compile()
+	if err != nil {
+		return
+	}
+
+	query := "UPDATE " + RuleTable + " SET name=$2, rule=$3, kind=$4 WHERE id=$1;"
+	_, err = manager.exec(query, rule.ID, rule.Name, rule.Rule, rule.Kind)
 	return
 }
 
 //DeleteRuleByID : update rule in database
 func (manager *Manager) DeleteRuleByID(ID int) (err error) {
 	query := "DELETE FROM " + RuleTable + " WHERE id=$1;"
-	_, err = manager.Database.Exec(query, ID)
+	_, err = manager.exec(query, ID)
 	return
 }
 
 //SelectRuleByID : select rejection rule by id
 func (manager *Manager) SelectRuleByID(ID int) (rule RejectRule, err error) {
-	query := "SELECT id, name, rule FROM " + RuleTable + " WHERE id=$1;"
-	row := manager.Database.QueryRow(query, ID)
-	err = row.Scan(&rule.ID, &rule.Name, &rule.Rule)
-	if err != nil {
-		return
-	}
-
-	rule.Expr, err = regexp.Compile(rule.Rule)
+	query := "SELECT id, name, rule, kind FROM " + RuleTable + " WHERE id=$1;"
+	row := manager.queryRow(query, ID)
+	err = row.Scan(&rule.ID, &rule.Name, &rule.Rule, &rule.Kind)
 	if err != nil {
 		return
 	}
 
+	err = rule.Compile()
 	return
 }
 
 //SelectAllRules : select all rejection rules from database
 func (manager *Manager) SelectAllRules() (rules []RejectRule, err error) {
-	query := "SELECT id, name, rule FROM " + RuleTable + ";"
-	rows, err := manager.Database.Query(query)
+	query := "SELECT id, name, rule, kind FROM " + RuleTable + ";"
+	rows, err := manager.query(query)
 	if err != nil {
 		return
 	}
@@ -307,12 +446,12 @@ func (manager *Manager) SelectAllRules() (rules []RejectRule, err error) {
 	defer rows.Close()
 	for rows.Next() {
 		var rule RejectRule
-		err = rows.Scan(&rule.ID, &rule.Name, &rule.Rule)
+		err = rows.Scan(&rule.ID, &rule.Name, &rule.Rule, &rule.Kind)
 		if err != nil {
 			return
 		}
 
-		rule.Expr, err = regexp.Compile(rule.Rule)
+		err = rule.Compile()
 		if err != nil {
 			return
 		}
@@ -325,21 +464,28 @@ func (manager *Manager) SelectAllRules() (rules []RejectRule, err error) {
 //InsertKeyword : insert keyword to the databese
 func (manager *Manager) InsertKeyword(keyword string, wordType int) (ID int, err error) {
 	query := "INSERT INTO " + KeywordsTable + " (keyword, type)  VALUES  ($1, $2) RETURNING id;"
-	err = manager.Database.QueryRow(query, keyword, wordType).Scan(&ID)
+	err = manager.queryRow(query, keyword, wordType).Scan(&ID)
+	return
+}
+
+//UpdateKeyword : update keyword type in database
+func (manager *Manager) UpdateKeyword(keyword Keyword) (err error) {
+	query := "UPDATE " + KeywordsTable + " SET keyword=$2, type=$3 WHERE id=$1;"
+	_, err = manager.exec(query, keyword.ID, keyword.Value, keyword.Type)
 	return
 }
 
 //DeleteKeyword : delete keyword from database
 func (manager *Manager) DeleteKeyword(ID int) (err error) {
 	query := "DELETE FROM " + KeywordsTable + " WHERE id=$1;"
-	_, err = manager.Database.Exec(query, ID)
+	_, err = manager.exec(query, ID)
 	return
 }
 
 //SelectKeywordByType : select all keywords with the same type
 func (manager *Manager) SelectKeywordByType(wordType int) (keywords []Keyword, err error) {
 	query := "SELECT id, keyword, type FROM " + KeywordsTable + " WHERE type=$1;"
-	rows, err := manager.Database.Query(query, wordType)
+	rows, err := manager.query(query, wordType)
 	if err != nil {
 		return
 	}
@@ -359,7 +505,7 @@ func (manager *Manager) SelectKeywordByType(wordType int) (keywords []Keyword, e
 //SelectAllKeywords : select all keywords from database
 func (manager *Manager) SelectAllKeywords() (keywords []Keyword, err error) {
 	query := "SELECT id, keyword, type FROM " + KeywordsTable + ";"
-	rows, err := manager.Database.Query(query)
+	rows, err := manager.query(query)
 	if err != nil {
 		return
 	}
@@ -379,92 +525,117 @@ func (manager *Manager) SelectAllKeywords() (keywords []Keyword, err error) {
 //SelectKeywordByID : select particular keyword from database by its id
 func (manager *Manager) SelectKeywordByID(ID int) (keyword Keyword, err error) {
 	query := "SELECT id, keyword, type FROM " + KeywordsTable + " WHERE id=$1"
-	row := manager.Database.QueryRow(query, ID)
+	row := manager.queryRow(query, ID)
 	err = row.Scan(&keyword.ID, &keyword.Value, &keyword.Type)
 	return
 }
 
-//Init :  init checks & table creation
-func Init(conn *sql.DB) (err error) {
-	tables := make(map[string](func(name string, conn *sql.DB) (err error)), 10)
+//InsertAllowlistEntry : insert allowlist entry into db
+func (manager *Manager) InsertAllowlistEntry(entry AllowlistEntry) (ID int, err error) {
+	query := "INSERT INTO " + AllowlistTable + " (type, value, hits) VALUES ($1, $2, 0) RETURNING id;"
+	err = manager.queryRow(query, entry.Type, entry.Value).Scan(&ID)
+	return
+}
+
+//DeleteAllowlistEntry : delete allowlist entry from db
+func (manager *Manager) DeleteAllowlistEntry(ID int) (err error) {
+	query := "DELETE FROM " + AllowlistTable + " WHERE id=$1;"
+	_, err = manager.exec(query, ID)
+	return
+}
+
+//IncrementAllowlistHits : increase counter of suppressed results
+func (manager *Manager) IncrementAllowlistHits(ID int, count int) (err error) {
+	query := "UPDATE " + AllowlistTable + " SET hits=hits+$2 WHERE id=$1;"
+	_, err = manager.exec(query, ID, count)
+	return
+}
 
-	tables[FragmentTable] = createFragmentTable
-	tables[ReportTable] = createReportTable
-	tables[RuleTable] = createRulesTable
-	tables[KeywordsTable] = createKeywordsTable
+//SelectAllowlist : select all allowlist entries
+func (manager *Manager) SelectAllowlist() (entries []AllowlistEntry, err error) {
+	query := "SELECT id, type, value, hits FROM " + AllowlistTable + " ORDER BY id;"
+	rows, err := manager.query(query)
+	if err != nil {
+		return
+	}
 
-	for table := range tables {
-		exist, err := CheckExists(table, conn)
+	defer rows.Close()
+	for rows.Next() {
+		var entry AllowlistEntry
+		err = rows.Scan(&entry.ID, &entry.Type, &entry.Value, &entry.Hits)
 		if err != nil {
-			return err
-		}
-		if !exist {
-			err = tables[table](table, conn)
-			if err != nil {
-				return err
-			}
+			return
 		}
+		entries = append(entries, entry)
 	}
-
 	return
 }
 
-// Connect to database
-func Connect(name, password, hostname, database string) (db *sql.DB, err error) {
-	ConnectURI := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable", name, password, hostname, database)
-	db, err = sql.Open("postgres", ConnectURI)
-	return
+//Open : connect to the database selected in settings
+func Open(creds utils.DBCredentialsSettings) (db *sql.DB, dialect Dialect, err error) {
+	return open(creds, NewPoolOptions(creds.Pool))
 }
 
-//CheckExists : Check if table exists in db
-func CheckExists(tableName string, conn *sql.DB) (exist bool, err error) {
-	query := "SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_schema=$2 AND table_name=$1);"
-	schema := "public"
-	row := conn.QueryRow(query, tableName, schema)
-	err = row.Scan(&exist)
+func open(creds utils.DBCredentialsSettings, opts PoolOptions) (db *sql.DB, dialect Dialect, err error) {
+	switch creds.Driver {
+	case "", DriverPostgres:
+		dialect = postgresDialect{}
+		db, err = sql.Open("postgres", postgresURI(creds, opts))
+	case DriverSQLite:
+		dialect = sqliteDialect{}
+		if creds.Path == "" {
+			return nil, nil, fmt.Errorf("sqlite: database path is not set")
+		}
+		db, err = sql.Open(sqliteDriverName, sqliteURI(creds.Path, opts))
+	default:
+		err = fmt.Errorf("unknown database driver: %s", creds.Driver)
+	}
 	return
 }
 
-//DropTable : drops table if exists
-func DropTable(tableName string, conn *sql.DB) (err error) {
-	query := "DROP TABLE IF EXISTS " + tableName + " CASCADE;"
-	_, err = conn.Exec(query)
-	return
+//postgresURI : statements running longer than the timeout are cancelled by the server
+func postgresURI(creds utils.DBCredentialsSettings, opts PoolOptions) string {
+	params := url.Values{}
+	params.Set("sslmode", "disable")
+	params.Set("connect_timeout", strconv.Itoa(int(opts.ConnectTimeout.Seconds())))
+	params.Set("statement_timeout", strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10))
+
+	URI := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(creds.Name, creds.Password),
+		Host:     creds.DBHostName,
+		Path:     "/" + creds.Database,
+		RawQuery: params.Encode(),
+	}
+	return URI.String()
 }
 
-func createFragmentTable(tableName string, conn *sql.DB) (err error) {
-	query := "CREATE TABLE " + tableName + " (id serial, content bytea, reject_id integer, report_id integer,type varchar, shahash varchar PRIMARY KEY, keywords jsonb);"
-	_, err = conn.Exec(query)
-	return
+//sqliteURI : writers from different connections wait for each other up to the statement timeout instead of failing with SQLITE_BUSY
+func sqliteURI(path string, opts PoolOptions) string {
+	busyTimeout := strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	return "file:" + path + "?_pragma=busy_timeout(" + busyTimeout + ")&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate"
 }
 
-func createReportTable(tableName string, conn *sql.DB) (err error) {
-	query := "CREATE TABLE " + tableName + " (id serial, shahash varchar PRIMARY KEY, status varchar, type varchar, data bytea, time integer);"
-	_, err = conn.Exec(query)
+// Connect to database
+func Connect(name, password, hostname, database string) (db *sql.DB, err error) {
+	creds := utils.DBCredentialsSettings{Name: name, Password: password, DBHostName: hostname, Database: database}
+	db, err = sql.Open("postgres", postgresURI(creds, NewPoolOptions(creds.Pool)))
 	return
 }
 
-func createKeywordsTable(tableName string, conn *sql.DB) (err error) {
-	query := "CREATE TABLE " + tableName + " (id serial, type int, keyword varchar);"
-	_, err = conn.Exec(query)
+//ConnectSQLite : open embedded database stored in the file
+func ConnectSQLite(path string) (db *sql.DB, err error) {
+	db, _, err = open(utils.DBCredentialsSettings{Driver: DriverSQLite, Path: path}, NewPoolOptions(utils.DBPoolSettings{}))
 	return
 }
 
-func createRulesTable(tableName string, conn *sql.DB) (err error) {
-	query := "CREATE TABLE " + tableName + " (id serial, name varchar, rule varchar) ;"
-	_, err = conn.Exec(query)
-	if err != nil {
-		return
-	}
-
-	query = "INSERT INTO " + tableName + " (name, rule) VALUES ($1, $2)"
-	predefined := []string{"none", "manual", "verified", "auto_removed"}
-	for _, rule := range predefined {
-		_, err = conn.Exec(query, rule, "")
-		if err != nil {
-			return
-		}
-	}
+//TableExists : check if table exists in db
+func (manager *Manager) TableExists(tableName string) (exist bool, err error) {
+	return manager.dialect().TableExists(manager.Database, tableName)
+}
 
+//DropTable : drops table if exists
+func (manager *Manager) DropTable(tableName string) (err error) {
+	_, err = manager.Database.Exec(manager.dialect().DropTable(tableName))
 	return
 }
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/megamon/core/utils"
+)
+
+const (
+	//DriverPostgres : default database driver
+	DriverPostgres = "postgres"
+
+	//DriverSQLite : embedded database for single node setups & tests
+	DriverSQLite = "sqlite"
+)
+
+//ColumnTypes : column definitions which differ between databases
+type ColumnTypes struct {
+	//Serial : autoincremented id, not a primary key
+	Serial string
+
+	//SerialKey : autoincremented id used as a primary key
+	SerialKey string
+
+	//Key : constraint of the natural key of the table (shahash)
+	Key string
+
+	Blob string
+	JSON string
+}
+
+//Dialect : differences between supported databases
+//Queries are written for Postgres and rewritten by Rebind
+type Dialect interface {
+	Name() string
+	Rebind(query string) string
+	Types() ColumnTypes
+	TableExists(conn *sql.DB, table string) (bool, error)
+	DropTable(table string) string
+	AddColumn(tx *sql.Tx, table, column, definition string) error
+	DropColumn(tx *sql.Tx, table, column string) error
+
+	//TextMatch : condition matching rows of aliased table whose column contains the bound query
+	//TextQuery converts the raw query into the bound argument
+	TextMatch(table, alias, column, placeholder string) string
+	TextQuery(query string) string
+	CreateTextIndex(tx *sql.Tx, table, column string) error
+	DropTextIndex(tx *sql.Tx, table, column string) error
+
+	//Transient : error is temporary and the statement wasn't applied, so it may be retried
+	Transient(err error) bool
+}
+
+//MinTextQuery : trigram indexes can't match shorter substrings
+const MinTextQuery = 3
+
+//textFunction : immutable bytea to text conversion, so it can be indexed
+//Content which is not valid UTF-8 is indexed in escaped form instead of failing inserts
+const textFunction = "megamon_text"
+
+//likePattern : substring pattern with LIKE wildcards escaped
+func likePattern(query string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(query) + "%"
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return DriverPostgres
+}
+
+func (postgresDialect) Rebind(query string) string {
+	return query
+}
+
+func (postgresDialect) Types() ColumnTypes {
+	return ColumnTypes{Serial: "serial", SerialKey: "serial PRIMARY KEY", Key: "PRIMARY KEY", Blob: "bytea", JSON: "jsonb"}
+}
+
+func (postgresDialect) TableExists(conn *sql.DB, table string) (exist bool, err error) {
+	query := "SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_schema=$2 AND table_name=$1);"
+	err = conn.QueryRow(query, table, "public").Scan(&exist)
+	return
+}
+
+func (postgresDialect) DropTable(table string) string {
+	return "DROP TABLE IF EXISTS " + table + " CASCADE;"
+}
+
+func (postgresDialect) AddColumn(tx *sql.Tx, table, column, definition string) (err error) {
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN IF NOT EXISTS " + column + " " + definition + ";")
+	return
+}
+
+func (postgresDialect) DropColumn(tx *sql.Tx, table, column string) (err error) {
+	_, err = tx.Exec("ALTER TABLE " + table + " DROP COLUMN IF EXISTS " + column + ";")
+	return
+}
+
+func (postgresDialect) TextMatch(table, alias, column, placeholder string) string {
+	return textFunction + "(" + alias + "." + column + ") ILIKE " + placeholder + ` ESCAPE '\'`
+}
+
+func (postgresDialect) TextQuery(query string) string {
+	return likePattern(query)
+}
+
+//CreateTextIndex : trigram index over the converted column, searches without it fall back to unindexed ILIKE
+//pg_trgm is created if it is missing, that needs superuser before Postgres 13 & CREATE on the database since then
+//Without the privilege the index is skipped with a warning, it can be created after pg_trgm is installed by the administrator
+func (postgresDialect) CreateTextIndex(tx *sql.Tx, table, column string) (err error) {
+	err = execAll(tx,
+		"CREATE OR REPLACE FUNCTION "+textFunction+"(data bytea) RETURNS text AS $$ BEGIN RETURN convert_from(data, 'UTF8'); "+
+			"EXCEPTION WHEN others THEN RETURN encode(data, 'escape'); END; $$ LANGUAGE plpgsql IMMUTABLE;",
+	)
+	if err != nil {
+		return
+	}
+
+	trigram, err := createTrigramExtension(tx)
+	if err != nil {
+		return
+	}
+
+	index := "CREATE INDEX IF NOT EXISTS " + table + "_" + column + "_trgm ON " + table + " USING gin (" + textFunction + "(" + column + ") gin_trgm_ops);"
+	if !trigram {
+		utils.ErrorLogger.Printf("warning: pg_trgm is not installed, search over %s.%s is not indexed; install it & run: %s", table, column, index)
+		return
+	}
+	return execAll(tx, index)
+}
+
+//createTrigramExtension : false if pg_trgm is missing & the role can't create it
+//The attempt is made in a savepoint, so its failure doesn't abort the migration
+func createTrigramExtension(tx *sql.Tx) (installed bool, err error) {
+	err = tx.QueryRow("SELECT EXISTS (SELECT FROM pg_extension WHERE extname='pg_trgm');").Scan(&installed)
+	if err != nil || installed {
+		return
+	}
+
+	err = execAll(tx, "SAVEPOINT pg_trgm;")
+	if err != nil {
+		return
+	}
+
+	_, createErr := tx.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm;")
+	if createErr != nil {
+		utils.ErrorLogger.Printf("warning: can't create extension pg_trgm: %s", createErr.Error())
+		return false, execAll(tx, "ROLLBACK TO SAVEPOINT pg_trgm;")
+	}
+	return true, execAll(tx, "RELEASE SAVEPOINT pg_trgm;")
+}
+
+//DropTextIndex : conversion function is kept, other indexes may still use it
+func (postgresDialect) DropTextIndex(tx *sql.Tx, table, column string) (err error) {
+	return execAll(tx, "DROP INDEX IF EXISTS "+table+"_"+column+"_trgm;")
+}
+
+type sqliteDialect struct{}
+
+//placeholderExpr : $N is a named parameter in SQLite, ?N is the positional one
+var placeholderExpr = regexp.MustCompile(`\$([0-9]+)`)
+
+func (sqliteDialect) Name() string {
+	return DriverSQLite
+}
+
+func (sqliteDialect) Rebind(query string) string {
+	return placeholderExpr.ReplaceAllString(query, "?$1")
+}
+
+//Types : rowid alias has to be the primary key, so the natural key becomes unique
+func (sqliteDialect) Types() ColumnTypes {
+	serial := "integer PRIMARY KEY AUTOINCREMENT"
+	return ColumnTypes{Serial: serial, SerialKey: serial, Key: "UNIQUE NOT NULL", Blob: "blob", JSON: "text"}
+}
+
+func (sqliteDialect) TableExists(conn *sql.DB, table string) (exist bool, err error) {
+	query := "SELECT EXISTS (SELECT name FROM sqlite_master WHERE type='table' AND name=?1);"
+	err = conn.QueryRow(query, table).Scan(&exist)
+	return
+}
+
+func (sqliteDialect) DropTable(table string) string {
+	return "DROP TABLE IF EXISTS " + table + ";"
+}
+
+func (d sqliteDialect) AddColumn(tx *sql.Tx, table, column, definition string) (err error) {
+	exist, err := sqliteColumnExists(tx, table, column)
+	if err != nil || exist {
+		return
+	}
+
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition + ";")
+	return
+}
+
+func (d sqliteDialect) DropColumn(tx *sql.Tx, table, column string) (err error) {
+	exist, err := sqliteColumnExists(tx, table, column)
+	if err != nil || !exist {
+		return
+	}
+
+	_, err = tx.Exec("ALTER TABLE " + table + " DROP COLUMN " + column + ";")
+	return
+}
+
+func sqliteColumnExists(tx *sql.Tx, table, column string) (exist bool, err error) {
+	query := "SELECT EXISTS (SELECT name FROM pragma_table_info(?1) WHERE name=?2);"
+	err = tx.QueryRow(query, table, column).Scan(&exist)
+	return
+}
+
+//searchTable : FTS5 table indexing column of the table, kept in sync by triggers
+func searchTable(table, column string) string {
+	return table + "_" + column + "_search"
+}
+
+func (sqliteDialect) TextMatch(table, alias, column, placeholder string) string {
+	search := searchTable(table, column)
+	return alias + ".id IN (SELECT rowid FROM " + search + " WHERE " + search + " MATCH " + placeholder + ")"
+}
+
+//TextQuery : query is matched as a phrase, so FTS syntax in it has no effect
+func (sqliteDialect) TextQuery(query string) string {
+	return `"` + strings.ReplaceAll(query, `"`, `""`) + `"`
+}
+
+//CreateTextIndex : external content FTS5 table with trigram tokenizer, matches substrings like trigram indexes of Postgres
+func (sqliteDialect) CreateTextIndex(tx *sql.Tx, table, column string) (err error) {
+	search := searchTable(table, column)
+	insert := "INSERT INTO " + search + " (rowid, " + column + ") VALUES (new.id, CAST(new." + column + " AS text));"
+	remove := "INSERT INTO " + search + " (" + search + ", rowid, " + column + ") VALUES ('delete', old.id, CAST(old." + column + " AS text));"
+
+	return execAll(tx,
+		"CREATE VIRTUAL TABLE IF NOT EXISTS "+search+" USING fts5("+column+", content='"+table+"', content_rowid='id', tokenize='trigram');",
+		"INSERT INTO "+search+" (rowid, "+column+") SELECT id, CAST("+column+" AS text) FROM "+table+";",
+		"CREATE TRIGGER IF NOT EXISTS "+search+"_insert AFTER INSERT ON "+table+" BEGIN "+insert+" END;",
+		"CREATE TRIGGER IF NOT EXISTS "+search+"_delete AFTER DELETE ON "+table+" BEGIN "+remove+" END;",
+		"CREATE TRIGGER IF NOT EXISTS "+search+"_update AFTER UPDATE OF "+column+" ON "+table+" BEGIN "+remove+" "+insert+" END;",
+	)
+}
+
+func (sqliteDialect) DropTextIndex(tx *sql.Tx, table, column string) (err error) {
+	search := searchTable(table, column)
+	return execAll(tx,
+		"DROP TRIGGER IF EXISTS "+search+"_insert;",
+		"DROP TRIGGER IF EXISTS "+search+"_delete;",
+		"DROP TRIGGER IF EXISTS "+search+"_update;",
+		"DROP TABLE IF EXISTS "+search+";",
+	)
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Page : sorting & pagination of selection, zero values are ignored
+//Sort is a column name, prefixed with "-" for descending order
+type Page struct {
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+//FragmentFilter : conditions of fragment selection, zero values are ignored
+//Source fields are matched exactly against the source of the report
+type FragmentFilter struct {
+	ID        int
+	ReportID  int
+	RejectIDs []int
+	Type      string
+	Source
+	Page
+}
+
+//ReportFilter : conditions of report selection, zero values are ignored
+//Time range is exclusive: Since < time < Until, PurgedBelow selects reports purged less than the level
+type ReportFilter struct {
+	ID          int
+	Type        string
+	Status      string
+	Since       int
+	Until       int
+	PurgedBelow int
+	Page
+}
+
+//fragmentSortColumns : sort keys of fragments & columns of fragments (f) joined with reports (r)
+var fragmentSortColumns = map[string]string{
+	"id": "f.id", "report_id": "f.report_id", "reject_id": "f.reject_id", "score": "f.score", "time": "r.time",
+	"url": "r.url", "repo": "r.repo", "owner": "r.owner", "path": "r.path", "language": "r.language", "keyword": "r.keyword",
+}
+
+//reportSortColumns : columns reports may be sorted by
+var reportSortColumns = map[string]string{"id": "id", "time": "time", "status": "status"}
+
+//where : conditions with bound parameters, numbered in order of addition
+type where struct {
+	conds []string
+	args  []interface{}
+}
+
+//bind : add argument & return its placeholder
+func (w *where) bind(arg interface{}) string {
+	w.args = append(w.args, arg)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+func (w *where) add(column, op string, arg interface{}) {
+	w.conds = append(w.conds, column+op+w.bind(arg))
+}
+
+func (w *where) in(column string, values []int) {
+	placeholders := make([]string, 0, len(values))
+	for _, value := range values {
+		placeholders = append(placeholders, w.bind(value))
+	}
+	w.conds = append(w.conds, column+" IN ("+strings.Join(placeholders, ", ")+")")
+}
+
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+//source : conditions on source columns of the aliased report table
+func (w *where) source(alias string, source Source) {
+	for i, value := range source.values() {
+		if value != "" {
+			w.add(alias+"."+sourceColumns[i], "=", value)
+		}
+	}
+}
+
+//page : ORDER BY, LIMIT & OFFSET clauses, sort key is mapped to the column of allowed ones
+func (w *where) page(page Page, columns map[string]string) (clause string, err error) {
+	if page.Sort != "" {
+		key := strings.TrimPrefix(page.Sort, "-")
+		column, ok := columns[key]
+		if !ok {
+			return "", fmt.Errorf("unknown sort column: %s", key)
+		}
+
+		clause += " ORDER BY " + column
+		if key != page.Sort {
+			clause += " DESC"
+		}
+	}
+
+	if page.Limit < 0 || page.Offset < 0 {
+		return "", fmt.Errorf("limit & offset must not be negative")
+	}
+
+	if page.Limit > 0 {
+		clause += " LIMIT " + w.bind(page.Limit)
+	}
+
+	if page.Offset > 0 {
+		clause += " OFFSET " + w.bind(page.Offset)
+	}
+	return
+}
+
+//where : conditions on fragments (f) joined with their reports (r)
+func (filter FragmentFilter) where() (w *where) {
+	w = &where{}
+	if filter.ID != 0 {
+		w.add("f.id", "=", filter.ID)
+	}
+
+	if filter.ReportID != 0 {
+		w.add("f.report_id", "=", filter.ReportID)
+	}
+
+	if len(filter.RejectIDs) > 0 {
+		w.in("f.reject_id", filter.RejectIDs)
+	}
+
+	if filter.Type != "" {
+		w.add("f.type", "=", filter.Type)
+	}
+
+	w.source("r", filter.Source)
+	return
+}
+
+func (filter ReportFilter) where() (w *where) {
+	w = &where{}
+	if filter.ID != 0 {
+		w.add("id", "=", filter.ID)
+	}
+
+	if filter.Type != "" {
+		w.add("type", "=", filter.Type)
+	}
+
+	if filter.Status != "" {
+		w.add("status", "=", filter.Status)
+	}
+
+	if filter.Since != 0 {
+		w.add("time", ">", filter.Since)
+	}
+
+	if filter.Until != 0 {
+		w.add("time", "<", filter.Until)
+	}
+
+	if filter.PurgedBelow != 0 {
+		w.add("purged", "<", filter.PurgedBelow)
+	}
+	return
+}
@@ -0,0 +1,411 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+//MigrationsTable : global name for table with applied schema migrations
+var MigrationsTable = "schema_migrations"
+
+//Migration : numbered schema change
+//Up & Down run inside a transaction, table names are resolved at run time,
+//so tests may override the global table names
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx, d Dialect) error
+	Down    func(tx *sql.Tx, d Dialect) error
+}
+
+//MigrationState : migration & time it was applied at, 0 if pending
+type MigrationState struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Applied int64  `json:"applied"`
+}
+
+//Migrations : schema history, versions must be strictly increasing
+//Migrations are idempotent (IF NOT EXISTS), so databases created by
+//the old create-if-missing code are adopted without changes
+var Migrations = []Migration{
+	{Version: 1, Name: "initial schema", Up: initialSchemaUp, Down: initialSchemaDown},
+	{Version: 2, Name: "allowlist", Up: allowlistUp, Down: allowlistDown},
+	{Version: 3, Name: "rule kind", Up: ruleKindUp, Down: ruleKindDown},
+	{Version: 4, Name: "fragment score", Up: fragmentScoreUp, Down: fragmentScoreDown},
+	{Version: 5, Name: "report purge level", Up: reportPurgedUp, Down: reportPurgedDown},
+	{Version: 6, Name: "search indexes", Up: searchIndexesUp, Down: searchIndexesDown},
+	{Version: 7, Name: "report source", Up: reportSourceUp, Down: reportSourceDown},
+	{Version: 8, Name: "audit log", Up: auditLogUp, Down: auditLogDown},
+	{Version: 9, Name: "request queue", Up: requestQueueUp, Down: requestQueueDown},
+	{Version: 10, Name: "search watermarks", Up: searchWatermarksUp, Down: searchWatermarksDown},
+	{Version: 11, Name: "search coverage", Up: searchCoverageUp, Down: searchCoverageDown},
+	{Version: 12, Name: "task schedules", Up: taskSchedulesUp, Down: taskSchedulesDown},
+	{Version: 13, Name: "task runs", Up: taskRunsUp, Down: taskRunsDown},
+}
+
+//Migrate : migrate database schema to the latest version
+func (manager *Manager) Migrate() (err error) {
+	return manager.MigrateUp(0)
+}
+
+//MigrateUp : apply pending migrations up to the target version, 0 means latest
+func (manager *Manager) MigrateUp(target int) (err error) {
+	applied, err := manager.appliedMigrations()
+	if err != nil {
+		return
+	}
+
+	for _, migration := range Migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = manager.runMigration(migration, true)
+		if err != nil {
+			return fmt.Errorf("migration %03d (%s): %s", migration.Version, migration.Name, err.Error())
+		}
+	}
+	return
+}
+
+//MigrateDown : revert the last steps applied migrations
+func (manager *Manager) MigrateDown(steps int) (err error) {
+	applied, err := manager.appliedMigrations()
+	if err != nil {
+		return
+	}
+
+	for i := len(Migrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := Migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err = manager.runMigration(migration, false)
+		if err != nil {
+			return fmt.Errorf("migration %03d (%s): %s", migration.Version, migration.Name, err.Error())
+		}
+		steps--
+	}
+	return
+}
+
+//MigrationStatus : state of every known migration
+func (manager *Manager) MigrationStatus() (states []MigrationState, err error) {
+	applied, err := manager.appliedMigrations()
+	if err != nil {
+		return
+	}
+
+	for _, migration := range Migrations {
+		states = append(states, MigrationState{
+			Version: migration.Version,
+			Name:    migration.Name,
+			Applied: applied[migration.Version],
+		})
+	}
+	return
+}
+
+func (manager *Manager) appliedMigrations() (applied map[int]int64, err error) {
+	query := "CREATE TABLE IF NOT EXISTS " + MigrationsTable + " (version integer PRIMARY KEY, name varchar, applied bigint);"
+	_, err = manager.exec(query)
+	if err != nil {
+		return
+	}
+
+	query = "SELECT version, applied FROM " + MigrationsTable + ";"
+	rows, err := manager.query(query)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	applied = make(map[int]int64)
+	for rows.Next() {
+		var version int
+		var at int64
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			return
+		}
+		applied[version] = at
+	}
+	err = rows.Err()
+	return
+}
+
+//runMigration : migration & its record are applied in one transaction
+func (manager *Manager) runMigration(migration Migration, up bool) (err error) {
+	d := manager.dialect()
+	return manager.transaction(func(tx *sql.Tx) (err error) {
+		if up {
+			err = migration.Up(tx, d)
+			if err != nil {
+				return
+			}
+
+			query := "INSERT INTO " + MigrationsTable + " (version, name, applied) VALUES ($1, $2, $3);"
+			_, err = tx.Exec(d.Rebind(query), migration.Version, migration.Name, time.Now().Unix())
+		} else {
+			err = migration.Down(tx, d)
+			if err != nil {
+				return
+			}
+
+			query := "DELETE FROM " + MigrationsTable + " WHERE version=$1;"
+			_, err = tx.Exec(d.Rebind(query), migration.Version)
+		}
+		return
+	})
+}
+
+func execAll(tx *sql.Tx, queries ...string) (err error) {
+	for _, query := range queries {
+		_, err = tx.Exec(query)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func initialSchemaUp(tx *sql.Tx, d Dialect) (err error) {
+	t := d.Types()
+	err = execAll(tx,
+		"CREATE TABLE IF NOT EXISTS "+FragmentTable+" (id "+t.Serial+", content "+t.Blob+", reject_id integer, report_id integer,type varchar, shahash varchar "+t.Key+", keywords "+t.JSON+");",
+		"CREATE TABLE IF NOT EXISTS "+ReportTable+" (id "+t.Serial+", shahash varchar "+t.Key+", status varchar, type varchar, data "+t.Blob+", time integer);",
+		"CREATE TABLE IF NOT EXISTS "+KeywordsTable+" (id "+t.Serial+", type int, keyword varchar);",
+		"CREATE TABLE IF NOT EXISTS "+RuleTable+" (id "+t.Serial+", name varchar, rule varchar);",
+	)
+	if err != nil {
+		return
+	}
+
+	//predefined rules are seeded only into a new table
+	var count int
+	err = tx.QueryRow("SELECT COUNT(id) FROM " + RuleTable + ";").Scan(&count)
+	if err != nil || count > 0 {
+		return
+	}
+
+	query := d.Rebind("INSERT INTO " + RuleTable + " (name, rule) VALUES ($1, $2)")
+	for _, rule := range PredefinedRules {
+		_, err = tx.Exec(query, rule, "")
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func initialSchemaDown(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx,
+		d.DropTable(FragmentTable),
+		d.DropTable(ReportTable),
+		d.DropTable(KeywordsTable),
+		d.DropTable(RuleTable),
+	)
+}
+
+func allowlistUp(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx, "CREATE TABLE IF NOT EXISTS "+AllowlistTable+" (id "+d.Types().SerialKey+", type varchar, value varchar, hits integer DEFAULT 0);")
+}
+
+func allowlistDown(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx, d.DropTable(AllowlistTable))
+}
+
+func ruleKindUp(tx *sql.Tx, d Dialect) (err error) {
+	return d.AddColumn(tx, RuleTable, "kind", "varchar DEFAULT 'regexp'")
+}
+
+func ruleKindDown(tx *sql.Tx, d Dialect) (err error) {
+	return d.DropColumn(tx, RuleTable, "kind")
+}
+
+func fragmentScoreUp(tx *sql.Tx, d Dialect) (err error) {
+	return d.AddColumn(tx, FragmentTable, "score", "real DEFAULT -1")
+}
+
+func fragmentScoreDown(tx *sql.Tx, d Dialect) (err error) {
+	return d.DropColumn(tx, FragmentTable, "score")
+}
+
+func reportPurgedUp(tx *sql.Tx, d Dialect) (err error) {
+	return d.AddColumn(tx, ReportTable, "purged", "integer DEFAULT 0")
+}
+
+func reportPurgedDown(tx *sql.Tx, d Dialect) (err error) {
+	return d.DropColumn(tx, ReportTable, "purged")
+}
+
+func searchIndexesUp(tx *sql.Tx, d Dialect) (err error) {
+	err = d.CreateTextIndex(tx, FragmentTable, "content")
+	if err != nil {
+		return
+	}
+	return d.CreateTextIndex(tx, ReportTable, "data")
+}
+
+func searchIndexesDown(tx *sql.Tx, d Dialect) (err error) {
+	err = d.DropTextIndex(tx, FragmentTable, "content")
+	if err != nil {
+		return
+	}
+	return d.DropTextIndex(tx, ReportTable, "data")
+}
+
+//sourceIndexes : source columns reports are usually filtered by
+var sourceIndexes = []string{"repo", "owner", "language", "keyword"}
+
+//reportSourceUp : source columns backfilled from data of existing reports
+func reportSourceUp(tx *sql.Tx, d Dialect) (err error) {
+	for _, column := range sourceColumns {
+		err = d.AddColumn(tx, ReportTable, column, "varchar DEFAULT ''")
+		if err != nil {
+			return
+		}
+	}
+
+	for _, column := range sourceIndexes {
+		err = execAll(tx, "CREATE INDEX IF NOT EXISTS "+ReportTable+"_"+column+" ON "+ReportTable+" ("+column+");")
+		if err != nil {
+			return
+		}
+	}
+
+	rows, err := tx.Query("SELECT id, type, data FROM " + ReportTable + " WHERE repo='' AND path='';")
+	if err != nil {
+		return
+	}
+
+	//rows are read before updates, lib/pq can't execute queries while rows are open
+	sources := make(map[int]Source)
+	for rows.Next() {
+		var ID int
+		var reportType string
+		var data []byte
+
+		err = rows.Scan(&ID, &reportType, &data)
+		if err != nil {
+			rows.Close()
+			return
+		}
+
+		//reports with broken data are left without source
+		if source, err := SourceFromData(reportType, data); err == nil && source != (Source{}) {
+			sources[ID] = source
+		}
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	query := d.Rebind("UPDATE " + ReportTable + " SET url=$2, repo=$3, owner=$4, path=$5, language=$6, keyword=$7 WHERE id=$1;")
+	for ID, source := range sources {
+		_, err = tx.Exec(query, append([]interface{}{ID}, source.values()...)...)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func reportSourceDown(tx *sql.Tx, d Dialect) (err error) {
+	for _, column := range sourceIndexes {
+		err = execAll(tx, "DROP INDEX IF EXISTS "+ReportTable+"_"+column+";")
+		if err != nil {
+			return
+		}
+	}
+
+	for _, column := range sourceColumns {
+		err = d.DropColumn(tx, ReportTable, column)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func auditLogUp(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx,
+		"CREATE TABLE IF NOT EXISTS "+AuditTable+" (id "+d.Types().SerialKey+", time integer, actor varchar, action varchar, target varchar, "+
+			"target_ids varchar, old_value text, new_value text, client_ip varchar);",
+		"CREATE INDEX IF NOT EXISTS "+AuditTable+"_time ON "+AuditTable+" (time);",
+	)
+}
+
+func auditLogDown(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx, d.DropTable(AuditTable))
+}
+
+func requestQueueUp(tx *sql.Tx, d Dialect) (err error) {
+	t := d.Types()
+	return execAll(tx,
+		"CREATE TABLE IF NOT EXISTS "+QueueRunTable+" (id "+t.SerialKey+", stage varchar, status varchar, built integer, started integer, finished integer);",
+		"CREATE TABLE IF NOT EXISTS "+QueueTable+" (id "+t.SerialKey+", run_id integer, url varchar, params text, status varchar, error varchar, updated integer);",
+		"CREATE INDEX IF NOT EXISTS "+QueueRunTable+"_stage ON "+QueueRunTable+" (stage, status);",
+		"CREATE UNIQUE INDEX IF NOT EXISTS "+QueueTable+"_run_url ON "+QueueTable+" (run_id, url);",
+		"CREATE INDEX IF NOT EXISTS "+QueueTable+"_run_status ON "+QueueTable+" (run_id, status);",
+	)
+}
+
+func requestQueueDown(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx, d.DropTable(QueueTable), d.DropTable(QueueRunTable))
+}
+
+func searchWatermarksUp(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx,
+		"CREATE TABLE IF NOT EXISTS "+WatermarkTable+" (id "+d.Types().SerialKey+", query varchar UNIQUE NOT NULL, shahashes text, total integer, updated integer);",
+	)
+}
+
+func searchWatermarksDown(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx, d.DropTable(WatermarkTable))
+}
+
+func searchCoverageUp(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx,
+		"CREATE TABLE IF NOT EXISTS "+CoverageTable+" (id "+d.Types().SerialKey+", keyword varchar UNIQUE NOT NULL, total integer, retrievable integer, "+
+			"partitions integer, truncated integer, probes integer, updated integer);",
+	)
+}
+
+func searchCoverageDown(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx, d.DropTable(CoverageTable))
+}
+
+func taskSchedulesUp(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx,
+		"CREATE TABLE IF NOT EXISTS "+ScheduleTable+" (id "+d.Types().SerialKey+", task varchar UNIQUE NOT NULL, cron varchar, last_run integer, "+
+			"last_status varchar, next_run integer, updated integer);",
+	)
+}
+
+func taskSchedulesDown(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx, d.DropTable(ScheduleTable))
+}
+
+func taskRunsUp(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx,
+		"CREATE TABLE IF NOT EXISTS "+TaskRunTable+" (id "+d.Types().SerialKey+", task varchar NOT NULL, status varchar, error text, started integer, finished integer, "+
+			"requests integer, retries integer, reports integer, fragments integer, rejected integer);",
+		"CREATE INDEX IF NOT EXISTS "+TaskRunTable+"_task ON "+TaskRunTable+" (task, id);",
+		"CREATE TABLE IF NOT EXISTS "+TaskRunLogTable+" (id "+d.Types().SerialKey+", run_id integer NOT NULL, time integer, level varchar, message text);",
+		"CREATE INDEX IF NOT EXISTS "+TaskRunLogTable+"_run ON "+TaskRunLogTable+" (run_id, id);",
+	)
+}
+
+func taskRunsDown(tx *sql.Tx, d Dialect) (err error) {
+	return execAll(tx, d.DropTable(TaskRunLogTable), d.DropTable(TaskRunTable))
+}
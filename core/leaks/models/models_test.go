@@ -1,10 +1,17 @@
 package models
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/megamon/core/utils"
 )
 
@@ -12,23 +19,44 @@ var testFragment string
 var testReport string
 var testRules string
 
+//testDir : holds SQLite database, tests run against Postgres from config if MEGAMON_TEST_DB=postgres
+var testDir string
+
 func setup() {
 	utils.InitConfig("../../../config/config.yaml")
-	creds := utils.Settings.DBCredentials
+	if os.Getenv("MEGAMON_TEST_DB") != DriverPostgres {
+		var err error
+		testDir, err = ioutil.TempDir("", "megamon")
+		if err != nil {
+			panic(err)
+		}
 
-	conn, err := Connect(creds.Name, creds.Password, creds.DBHostName, creds.Database)
-	defer conn.Close()
+		utils.Settings.DBCredentials = utils.DBCredentialsSettings{Driver: DriverSQLite, Path: filepath.Join(testDir, "test.db")}
+	}
 
 	FragmentTable = "fragment_test"
 	ReportTable = "report_test"
 	RuleTable = "rules_test"
 	KeywordsTable = "keywords_test"
+	AllowlistTable = "allowlist_test"
+	MigrationsTable = "schema_migrations_test"
+	AuditTable = "audit_log_test"
+	QueueRunTable = "queue_runs_test"
+	QueueTable = "request_queue_test"
+	WatermarkTable = "search_watermarks_test"
+	CoverageTable = "search_coverage_test"
+	ScheduleTable = "task_schedules_test"
+	TaskRunTable = "task_runs_test"
+	TaskRunLogTable = "task_run_logs_test"
 
+	var manager Manager
+	err := manager.Init()
 	if err != nil {
 		panic(err)
 	}
+	defer manager.Close()
 
-	err = Init(conn)
+	err = manager.Migrate()
 	if err != nil {
 		panic(err)
 	}
@@ -36,17 +64,23 @@ func setup() {
 }
 
 func clean() {
-	creds := utils.Settings.DBCredentials
-	conn, err := Connect(creds.Name, creds.Password, creds.DBHostName, creds.Database)
-	defer conn.Close()
+	var manager Manager
+	err := manager.Init()
+	if err != nil {
+		panic(err)
+	}
+	defer manager.Close()
 
-	tables := []string{FragmentTable, ReportTable, RuleTable, KeywordsTable}
+	tables := []string{FragmentTable, ReportTable, RuleTable, KeywordsTable, AllowlistTable, AuditTable, QueueTable, QueueRunTable, WatermarkTable, CoverageTable, ScheduleTable, TaskRunTable, TaskRunLogTable, MigrationsTable}
 	for _, table := range tables {
-		if err = DropTable(table, conn); err != nil {
+		if err = manager.DropTable(table); err != nil {
 			panic(err)
 		}
 	}
 
+	if testDir != "" {
+		os.RemoveAll(testDir)
+	}
 	return
 }
 
@@ -58,15 +92,16 @@ func TestMain(m *testing.M) {
 }
 
 func TestConnect(t *testing.T) {
-	creds := utils.Settings.DBCredentials
-	conn, err := Connect(creds.Name, creds.Password, creds.DBHostName, creds.Database)
+	var manager Manager
+	err := manager.Init()
 
 	if err != nil {
 		t.Errorf("%s", err.Error())
 		return
 	}
+	defer manager.Close()
 
-	err = conn.Ping()
+	err = manager.Database.Ping()
 
 	if err != nil {
 		t.Errorf("%s", err.Error())
@@ -94,7 +129,7 @@ func TestTextFragmentOps1(t *testing.T) {
 		return
 	}
 
-	tfs, err := manager.SelectTextFragment("id", ID)
+	tfs, err := manager.SelectTextFragments(FragmentFilter{ID: ID})
 
 	if err != nil {
 		t.Errorf("%s", err.Error())
@@ -118,3 +153,664 @@ func TestTextFragmentOps1(t *testing.T) {
 	}
 	return
 }
+
+func TestMigrationsOrder(t *testing.T) {
+	for i := 1; i < len(Migrations); i++ {
+		if Migrations[i].Version <= Migrations[i-1].Version {
+			t.Errorf("Migration versions must be strictly increasing: %d after %d", Migrations[i].Version, Migrations[i-1].Version)
+		}
+	}
+	return
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	states, err := manager.MigrationStatus()
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	for _, state := range states {
+		if state.Applied == 0 {
+			t.Errorf("Expected migration %d to be applied", state.Version)
+		}
+	}
+
+	last := Migrations[len(Migrations)-1].Version
+	if err = manager.MigrateDown(1); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	states, _ = manager.MigrationStatus()
+	if states[len(states)-1].Applied != 0 {
+		t.Errorf("Expected migration %d to be reverted", last)
+	}
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	states, _ = manager.MigrationStatus()
+	if states[len(states)-1].Applied == 0 {
+		t.Errorf("Expected migration %d to be applied again", last)
+	}
+	return
+}
+
+func TestReportOps(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	report := Report{Type: "test", Status: "new", ShaHash: "report_ops", Data: []byte("{}"), Time: 100}
+	ID, err := manager.InsertReport(report)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	exist, err := manager.CheckReportDuplicate(report.ShaHash)
+	if err != nil || !exist {
+		t.Errorf("Expected duplicate report, got: %v %v", exist, err)
+	}
+
+	if err = manager.UpdateReportsStatus("test", "new", "processed"); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	stored, err := manager.SelectReportByID(ID)
+	if err != nil || stored.Status != "processed" {
+		t.Errorf("Expected processed report, got: %v %v", stored, err)
+	}
+
+	count, err := manager.CountReports(ReportFilter{Type: "test", Since: 50})
+	if err != nil || count != 1 {
+		t.Errorf("Expected 1 report, got: %d %v", count, err)
+	}
+
+	if err = manager.DeleteReportByID(ID); err != nil {
+		t.Errorf("%s", err.Error())
+	}
+	return
+}
+
+func TestBatchInserts(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	//more rows than fit one statement
+	reports := make([]Report, 0, insertBatchSize+100)
+	for i := 0; i < insertBatchSize+100; i++ {
+		reports = append(reports, Report{Type: "batch", Status: "fetched", ShaHash: fmt.Sprintf("batch_%d", i), Data: []byte("{}"), Time: 100})
+	}
+
+	inserted, err := manager.InsertReports(reports)
+	if err != nil || inserted != len(reports) {
+		t.Errorf("Expected %d reports inserted, got: %d %v", len(reports), inserted, err)
+		return
+	}
+
+	inserted, err = manager.InsertReports(reports[:10])
+	if err != nil || inserted != 0 {
+		t.Errorf("Expected duplicate reports to be skipped, got: %d %v", inserted, err)
+	}
+
+	stored, err := manager.SelectReports(ReportFilter{Type: "batch", Page: Page{Sort: "id", Limit: 1}})
+	if err != nil || len(stored) != 1 {
+		t.Errorf("Expected stored report, got: %v %v", stored, err)
+		return
+	}
+
+	reportID := stored[0].ID
+	frags := []TextFragment{
+		{ReportID: reportID, Text: "first", Type: "batch", ShaHash: "batch_frag_1", Keywords: [][]int{{0, 5}}},
+		{ReportID: reportID, Text: "second", Type: "batch", ShaHash: "batch_frag_2", Keywords: [][]int{{0, 6}}},
+		{ReportID: reportID, Text: "second", Type: "batch", ShaHash: "batch_frag_2", Keywords: [][]int{{0, 6}}},
+	}
+
+	inserted, err = manager.CommitReportFragments(reportID, frags, "new")
+	if err != nil || inserted != 2 {
+		t.Errorf("Expected 2 fragments inserted, got: %d %v", inserted, err)
+	}
+
+	report, err := manager.SelectReportByID(reportID)
+	if err != nil || report.Status != "new" {
+		t.Errorf("Expected report status to be committed with fragments, got: %v %v", report.Status, err)
+	}
+
+	inserted, err = manager.InsertTextFragments(frags[:1])
+	if err != nil || inserted != 0 {
+		t.Errorf("Expected duplicate fragment to be skipped, got: %d %v", inserted, err)
+	}
+
+	storedFrags, err := manager.SelectTextFragments(FragmentFilter{ReportID: reportID, Page: Page{Sort: "id"}})
+	if err != nil || len(storedFrags) != 2 || storedFrags[1].Text != "second" || len(storedFrags[1].Keywords) != 1 {
+		t.Errorf("Expected fragments of the report, got: %v %v", storedFrags, err)
+	}
+
+	manager.DeleteTextFragmentsByReport(reportID)
+	manager.exec("DELETE FROM "+ReportTable+" WHERE type=$1;", "batch")
+	return
+}
+
+func TestRequestQueue(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	run, resumed, err := manager.OpenQueueRun("queue")
+	if err != nil || resumed || run.Built {
+		t.Errorf("Expected new run, got: %v %v %v", run, resumed, err)
+		return
+	}
+
+	for _, URL := range []string{"a", "b", "c"} {
+		status, err := manager.EnqueueRequest(run.ID, URL, []byte(`{"page":1}`))
+		if err != nil || status != REQUESTPENDING {
+			t.Errorf("Expected pending request, got: %s %v", status, err)
+			return
+		}
+	}
+
+	manager.UpdateQueuedRequest(run.ID, "a", REQUESTDONE, "")
+	manager.UpdateQueuedRequest(run.ID, "b", REQUESTFAILED, "404 Not Found")
+
+	//request queued again keeps its status
+	status, err := manager.EnqueueRequest(run.ID, "a", []byte(`{}`))
+	if err != nil || status != REQUESTDONE {
+		t.Errorf("Expected done request, got: %s %v", status, err)
+	}
+
+	manager.SetQueueRunBuilt(run.ID)
+	resumedRun, resumed, err := manager.OpenQueueRun("queue")
+	if err != nil || !resumed || resumedRun.ID != run.ID || !resumedRun.Built {
+		t.Errorf("Expected built run to be resumed, got: %v %v %v", resumedRun, resumed, err)
+	}
+
+	pending, err := manager.SelectQueuedRequests(run.ID, REQUESTPENDING)
+	if err != nil || len(pending) != 1 || pending[0].URL != "c" || string(pending[0].Params) != `{"page":1}` {
+		t.Errorf("Expected one pending request, got: %v %v", pending, err)
+	}
+
+	progress, err := manager.SelectQueueProgress("queue")
+	if err != nil || progress.Done != 1 || progress.Pending != 1 || progress.Failed != 1 {
+		t.Errorf("Expected 1 done, 1 pending & 1 failed, got: %v %v", progress, err)
+	}
+
+	if err = manager.FinishQueueRun(run.ID); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	next, resumed, err := manager.OpenQueueRun("queue")
+	if err != nil || resumed || next.ID == run.ID {
+		t.Errorf("Expected new run after the finished one, got: %v %v %v", next, resumed, err)
+		return
+	}
+	manager.FinishQueueRun(next.ID)
+
+	//requests of older runs are deleted when the next run finishes
+	pending, err = manager.SelectQueuedRequests(run.ID, REQUESTPENDING)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("Expected requests of the old run to be deleted, got: %v %v", pending, err)
+	}
+
+	if _, err = manager.SelectQueueProgress("unknown"); err != sql.ErrNoRows {
+		t.Errorf("Expected no runs of unknown stage, got: %v", err)
+	}
+	return
+}
+
+func TestWatermark(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	if _, err := manager.SelectWatermark("password+language:Go"); err != sql.ErrNoRows {
+		t.Errorf("Expected no watermark, got: %v", err)
+	}
+
+	for _, hashes := range [][]string{{"a", "b"}, {"c", "d", "e"}} {
+		err := manager.SaveWatermark(Watermark{Query: "password+language:Go", ShaHashes: hashes, Total: len(hashes)})
+		if err != nil {
+			t.Errorf("%s", err.Error())
+			return
+		}
+	}
+
+	wm, err := manager.SelectWatermark("password+language:Go")
+	if err != nil || wm.Total != 3 || !wm.Seen("d") || wm.Seen("a") || wm.Updated == 0 {
+		t.Errorf("Expected watermark to be replaced, got: %v %v", wm, err)
+	}
+
+	for _, c := range []Coverage{{Keyword: "password", Total: 5000, Retrievable: 1000}, {Keyword: "password", Total: 5000, Retrievable: 4000}, {Keyword: "token", Total: 10, Retrievable: 10}} {
+		if err = manager.SaveCoverage(c); err != nil {
+			t.Errorf("%s", err.Error())
+			return
+		}
+	}
+
+	coverage, err := manager.SelectCoverage()
+	if err != nil || len(coverage) != 2 || coverage[0].Keyword != "password" || coverage[0].Ratio != 0.8 || coverage[1].Ratio != 1 {
+		t.Errorf("Expected coverage by keyword, the worst first, got: %v %v", coverage, err)
+	}
+	return
+}
+
+func TestTaskSchedule(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	if _, err := manager.SelectTaskSchedule("github"); err != sql.ErrNoRows {
+		t.Errorf("Expected no schedule, got: %v", err)
+	}
+
+	for _, schedule := range []TaskSchedule{{Task: "github", Cron: "@daily", NextRun: 100}, {Task: "github", Cron: "0 * * * *", LastRun: 100, LastStatus: SCHEDULESTARTED, NextRun: 200}} {
+		if err := manager.SaveTaskSchedule(schedule); err != nil {
+			t.Errorf("%s", err.Error())
+			return
+		}
+	}
+
+	schedules, err := manager.SelectTaskSchedules()
+	if err != nil || len(schedules) != 1 || schedules[0].Cron != "0 * * * *" || schedules[0].NextRun != 200 || schedules[0].LastStatus != SCHEDULESTARTED {
+		t.Errorf("Expected schedule to be replaced, got: %v %v", schedules, err)
+	}
+	return
+}
+
+func TestTaskRuns(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	var runs []TaskRun
+	for _, task := range []string{"github", "gist", "github"} {
+		run, err := manager.StartTaskRun(task)
+		if err != nil {
+			t.Errorf("%s", err.Error())
+			return
+		}
+		runs = append(runs, run)
+	}
+
+	counters := RunCounters{Requests: 12, Retries: 2, Reports: 5, Fragments: 9, Rejected: 4}
+	if err := manager.UpdateTaskRunCounters(runs[0].ID, counters); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	counters.Requests = 15
+	if err := manager.FinishTaskRun(runs[0].ID, TASKFAILED, "rate limited", counters); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	for _, message := range []string{"started", "rate limited"} {
+		if err := manager.InsertTaskRunLog(TaskRunLog{RunID: runs[0].ID, Level: LOGINFO, Message: message}); err != nil {
+			t.Errorf("%s", err.Error())
+			return
+		}
+	}
+
+	run, err := manager.SelectTaskRunByID(runs[0].ID)
+	if err != nil || run.Status != TASKFAILED || run.Error != "rate limited" || run.RunCounters != counters || run.Finished == 0 {
+		t.Errorf("Expected finished run with counters, got: %+v %v", run, err)
+	}
+
+	logs, err := manager.SelectTaskRunLogs(runs[0].ID)
+	if err != nil || len(logs) != 2 || logs[1].Message != "rate limited" {
+		t.Errorf("Expected log lines in order, got: %v %v", logs, err)
+	}
+
+	filter := TaskRunFilter{Task: "github", Page: Page{Sort: "-id"}}
+	selected, err := manager.SelectTaskRuns(filter)
+	count, countErr := manager.CountTaskRuns(filter)
+	if err != nil || countErr != nil || count != 2 || len(selected) != 2 || selected[0].ID != runs[2].ID {
+		t.Errorf("Expected runs of the task, the latest first, got: %d %v %v %v", count, selected, err, countErr)
+	}
+
+	aborted, err := manager.AbortTaskRuns()
+	if err != nil || aborted != 2 {
+		t.Errorf("Expected running runs to be aborted, got: %d %v", aborted, err)
+	}
+
+	if _, err = manager.SelectTaskRunByID(runs[2].ID + 1); err != sql.ErrNoRows {
+		t.Errorf("Expected no run, got: %v", err)
+	}
+	return
+}
+
+func TestRuleKeywordOps(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	ruleID, err := manager.InsertRule(RejectRule{Name: "tests", Rule: `path endswith "_test.go"`, Kind: RULEKINDEXPR})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	rule, err := manager.SelectRuleByID(ruleID)
+	if err != nil || rule.Kind != RULEKINDEXPR || rule.Cond == nil {
+		t.Errorf("Expected compiled expression rule, got: %v %v", rule, err)
+	}
+
+	keywordID, err := manager.InsertKeyword("token", KWINNER)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if err = manager.UpdateKeyword(Keyword{ID: keywordID, Value: "secret", Type: KWINNER}); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	keyword, err := manager.SelectKeywordByID(keywordID)
+	if err != nil || keyword.Value != "secret" {
+		t.Errorf("Expected updated keyword, got: %v %v", keyword, err)
+	}
+
+	manager.DeleteRuleByID(ruleID)
+	manager.DeleteKeyword(keywordID)
+	return
+}
+
+func TestAllowlistOps(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	ID, err := manager.InsertAllowlistEntry(AllowlistEntry{Type: ALLOWOWNER, Value: "octocat"})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.DeleteAllowlistEntry(ID)
+
+	if err = manager.IncrementAllowlistHits(ID, 3); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	entries, err := manager.SelectAllowlist()
+	if err != nil || len(entries) != 1 || entries[0].Hits != 3 {
+		t.Errorf("Expected entry with 3 hits, got: %v %v", entries, err)
+	}
+	return
+}
+
+func TestRebind(t *testing.T) {
+	query := "UPDATE t SET a=$2 WHERE id=$1 AND b=$10;"
+	if rebound := (sqliteDialect{}).Rebind(query); rebound != "UPDATE t SET a=?2 WHERE id=?1 AND b=?10;" {
+		t.Errorf("Wrong SQLite placeholders: %s", rebound)
+	}
+
+	if rebound := (postgresDialect{}).Rebind(query); rebound != query {
+		t.Errorf("Postgres query must not change: %s", rebound)
+	}
+	return
+}
+
+func TestFragmentFilter(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	for i := 0; i < 5; i++ {
+		frag := TextFragment{ReportID: 500, RejectID: i % 2, Type: "filter", ShaHash: fmt.Sprintf("filter_%d", i), Keywords: [][]int{}}
+		if _, err := manager.InsertTextFragment(&frag); err != nil {
+			t.Errorf("%s", err.Error())
+			return
+		}
+	}
+
+	filter := FragmentFilter{ReportID: 500, RejectIDs: []int{0}, Type: "filter", Page: Page{Sort: "-id", Limit: 2, Offset: 1}}
+	frags, err := manager.SelectTextFragments(filter)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if len(frags) != 2 || frags[0].ShaHash != "filter_2" || frags[1].ShaHash != "filter_0" {
+		t.Errorf("Wrong page of fragments: %v", frags)
+	}
+
+	count, err := manager.CountTextFragments(filter)
+	if err != nil || count != 3 {
+		t.Errorf("Expected 3 fragments regardless of pagination, got: %d %v", count, err)
+	}
+
+	count, err = manager.CountTextFragments(FragmentFilter{Type: "filter' OR '1'='1"})
+	if err != nil || count != 0 {
+		t.Errorf("Type must be bound as a value, got: %d %v", count, err)
+	}
+
+	if _, err = manager.SelectTextFragments(FragmentFilter{Page: Page{Sort: "id; DROP TABLE x"}}); err == nil {
+		t.Errorf("Expected error for unknown sort column")
+	}
+	return
+}
+
+func TestSearchTextFragments(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	data := `{"path":"deploy/settings.py","repository":{"full_name":"octocat/deploy","owner":{"login":"octocat"}}}`
+	source := Source{Repo: "octocat/deploy", Owner: "octocat", Path: "deploy/settings.py"}
+	reportID, err := manager.InsertReport(Report{Type: "search", Status: "new", ShaHash: "search_report", Data: []byte(data), Time: 200, Source: source})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.DeleteReportByID(reportID)
+
+	texts := []string{"AWS_SECRET_KEY = 'abc'", "password: 100%_sure", "nothing here"}
+	for i, text := range texts {
+		frag := TextFragment{Text: text, ReportID: reportID, RejectID: i, Type: "search", ShaHash: fmt.Sprintf("search_%d", i), Keywords: [][]int{}}
+		if _, err = manager.InsertTextFragment(&frag); err != nil {
+			t.Errorf("%s", err.Error())
+			return
+		}
+	}
+	defer manager.DeleteTextFragmentsByReport(reportID)
+
+	results, total, err := manager.SearchTextFragments(SearchFilter{Query: "secret_key"})
+	if err != nil || total != 1 || len(results) != 1 || results[0].ShaHash != "search_0" {
+		t.Errorf("Expected case insensitive match of content, got: %v %d %v", results, total, err)
+		return
+	}
+
+	if results[0].Path != "deploy/settings.py" || results[0].Repo != "octocat/deploy" || results[0].Owner != "octocat" || results[0].ReportTime != 200 {
+		t.Errorf("Expected report metadata in result, got: %v", results[0])
+	}
+
+	_, total, err = manager.SearchTextFragments(SearchFilter{Query: "0%_s"})
+	if err != nil || total != 1 {
+		t.Errorf("Wildcards must be matched literally, got: %d %v", total, err)
+	}
+
+	results, total, err = manager.SearchTextFragments(SearchFilter{Query: "octocat/deploy", RejectIDs: []int{1, 2}, Page: Page{Sort: "id", Limit: 1}})
+	if err != nil || total != 2 || len(results) != 1 || results[0].ShaHash != "search_1" {
+		t.Errorf("Expected fragments of matched report filtered by status, got: %v %d %v", results, total, err)
+	}
+
+	_, total, err = manager.SearchTextFragments(SearchFilter{Query: "octocat", Since: 200})
+	if err != nil || total != 0 {
+		t.Errorf("Expected no fragments of older reports, got: %d %v", total, err)
+	}
+
+	if _, _, err = manager.SearchTextFragments(SearchFilter{Query: " a "}); err == nil {
+		t.Errorf("Expected error for too short query")
+	}
+	return
+}
+
+func TestReportSourceBackfill(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	//revert report source & later migrations
+	steps := 0
+	for _, migration := range Migrations {
+		if migration.Version >= 7 {
+			steps++
+		}
+	}
+
+	if err := manager.MigrateDown(steps); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	data := `{"path":"src/app.go","html_url":"https://github.com/octocat/app/blob/master/src/app.go","repository":{"full_name":"octocat/app","owner":{"login":"octocat"}}}`
+	query := "INSERT INTO " + ReportTable + " (shahash, type, status, data, time) VALUES ($1, $2, $3, $4, $5);"
+	_, err := manager.exec(query, "backfill", "github", "new", []byte(data), 300)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	reports, err := manager.SelectReports(ReportFilter{Since: 299, Until: 301})
+	if err != nil || len(reports) != 1 {
+		t.Errorf("Expected backfilled report, got: %v %v", reports, err)
+		return
+	}
+	defer manager.DeleteReportByID(reports[0].ID)
+
+	expected := Source{URL: "https://github.com/octocat/app/blob/master/src/app.go", Repo: "octocat/app", Owner: "octocat", Path: "src/app.go", Language: "Go"}
+	if reports[0].Source != expected {
+		t.Errorf("Expected source from data, got: %v", reports[0].Source)
+	}
+
+	frag := TextFragment{ReportID: reports[0].ID, Type: "github", ShaHash: "backfill_frag", Keywords: [][]int{}}
+	if _, err = manager.InsertTextFragment(&frag); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.DeleteTextFragmentsByReport(reports[0].ID)
+
+	frags, err := manager.SelectTextFragments(FragmentFilter{Source: Source{Owner: "octocat", Language: "Go"}, Page: Page{Sort: "repo"}})
+	if err != nil || len(frags) != 1 || frags[0].Source != expected {
+		t.Errorf("Expected fragment filtered by source of its report, got: %v %v", frags, err)
+	}
+	return
+}
+
+func TestAuditLog(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	entries := []AuditEntry{
+		{Time: 400, Actor: "admin", Action: "fragment.mark", Target: "fragment", TargetIDs: []int{10, 11}, Old: "0", New: "2", ClientIP: "10.0.0.1"},
+		{Time: 401, Actor: "admin", Action: "fragment.mark", Target: "fragment", TargetIDs: []int{1}, Old: "0", New: "1", ClientIP: "10.0.0.1"},
+		{Time: 402, Actor: "reviewer", Action: "task.start", Target: "task", TargetIDs: []int{}, New: `"github"`, ClientIP: "10.0.0.2"},
+	}
+	for _, entry := range entries {
+		if _, err := manager.InsertAuditEntry(entry); err != nil {
+			t.Errorf("%s", err.Error())
+			return
+		}
+	}
+
+	selected, err := manager.SelectAuditEntries(AuditFilter{TargetID: 1})
+	if err != nil || len(selected) != 1 || selected[0].New != "1" {
+		t.Errorf("Target id must match whole ids only, got: %v %v", selected, err)
+	}
+
+	selected, err = manager.SelectAuditEntries(AuditFilter{Actor: "admin", Page: Page{Sort: "-time", Limit: 1}})
+	if err != nil || len(selected) != 1 || selected[0].Time != 401 {
+		t.Errorf("Expected the latest entry of admin, got: %v %v", selected, err)
+	}
+
+	count, err := manager.CountAuditEntries(AuditFilter{Since: 400, Until: 403})
+	if err != nil || count != 2 {
+		t.Errorf("Expected 2 entries, got: %d %v", count, err)
+	}
+
+	selected, err = manager.SelectAuditEntries(AuditFilter{Action: "task.start"})
+	if err != nil || len(selected) != 1 || len(selected[0].TargetIDs) != 0 || selected[0].ClientIP != "10.0.0.2" {
+		t.Errorf("Expected entry without targets, got: %v %v", selected, err)
+	}
+	return
+}
+
+func TestPoolOptions(t *testing.T) {
+	opts := NewPoolOptions(utils.DBPoolSettings{MaxOpenConns: 4, StatementTimeout: 5, Retries: -1})
+	if opts.MaxOpenConns != 4 || opts.MaxIdleConns != DefaultMaxIdleConns || opts.StatementTimeout != 5*time.Second || opts.Retries != 0 {
+		t.Errorf("Wrong pool options: %+v", opts)
+	}
+
+	creds := utils.DBCredentialsSettings{Name: "megamon", Password: "p@ss/word", DBHostName: "db:5432", Database: "leaks"}
+	URI := postgresURI(creds, opts)
+	if URI != "postgres://megamon:p%40ss%2Fword@db:5432/leaks?connect_timeout=10&sslmode=disable&statement_timeout=5000" {
+		t.Errorf("Wrong connection URI: %s", URI)
+	}
+	return
+}
+
+func TestRetryTransient(t *testing.T) {
+	manager := Manager{Dialect: postgresDialect{}, Retries: 2, RetryDelay: time.Millisecond}
+
+	attempts := 0
+	err := manager.retry(func() error {
+		attempts++
+		return &pq.Error{Code: "40001"}
+	})
+	if err == nil || attempts != 3 {
+		t.Errorf("Expected 3 attempts for serialization failure, got: %d %v", attempts, err)
+	}
+
+	attempts = 0
+	manager.retry(func() error {
+		attempts++
+		return &pq.Error{Code: "23505"}
+	})
+	if attempts != 1 {
+		t.Errorf("Unique violation must not be retried, got %d attempts", attempts)
+	}
+
+	attempts = 0
+	err = manager.retry(func() error {
+		attempts++
+		if attempts < 2 {
+			return &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("Expected success after failed dial, got: %d %v", attempts, err)
+	}
+	return
+}
+
+func TestHealth(t *testing.T) {
+	var manager Manager
+	manager.Init()
+	defer manager.Close()
+
+	health := manager.Health(context.Background())
+	if !health.OK || health.Stats.MaxOpenConnections == 0 {
+		t.Errorf("Expected healthy limited pool, got: %+v", health)
+	}
+	return
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/megamon/core/utils"
+	"modernc.org/sqlite"
+)
+
+//Defaults of the connection pool, used for zero settings
+const (
+	DefaultMaxOpenConns     = 10
+	DefaultMaxIdleConns     = 5
+	DefaultConnMaxLifetime  = 30 * time.Minute
+	DefaultConnMaxIdleTime  = 5 * time.Minute
+	DefaultConnectTimeout   = 10 * time.Second
+	DefaultStatementTimeout = 60 * time.Second
+	DefaultRetries          = 3
+	DefaultRetryDelay       = 200 * time.Millisecond
+)
+
+//PoolOptions : connection pool settings with defaults applied
+type PoolOptions struct {
+	MaxOpenConns     int
+	MaxIdleConns     int
+	ConnMaxLifetime  time.Duration
+	ConnMaxIdleTime  time.Duration
+	ConnectTimeout   time.Duration
+	StatementTimeout time.Duration
+	Retries          int
+	RetryDelay       time.Duration
+}
+
+func orDefault(value int, unit, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+	return time.Duration(value) * unit
+}
+
+//NewPoolOptions : options from settings, negative retries disable retrying
+func NewPoolOptions(settings utils.DBPoolSettings) (opts PoolOptions) {
+	opts = PoolOptions{
+		MaxOpenConns:     settings.MaxOpenConns,
+		MaxIdleConns:     settings.MaxIdleConns,
+		ConnMaxLifetime:  orDefault(settings.ConnMaxLifetime, time.Second, DefaultConnMaxLifetime),
+		ConnMaxIdleTime:  orDefault(settings.ConnMaxIdleTime, time.Second, DefaultConnMaxIdleTime),
+		ConnectTimeout:   orDefault(settings.ConnectTimeout, time.Second, DefaultConnectTimeout),
+		StatementTimeout: orDefault(settings.StatementTimeout, time.Second, DefaultStatementTimeout),
+		Retries:          settings.Retries,
+		RetryDelay:       orDefault(settings.RetryDelay, time.Millisecond, DefaultRetryDelay),
+	}
+
+	if opts.MaxOpenConns <= 0 {
+		opts.MaxOpenConns = DefaultMaxOpenConns
+	}
+
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = DefaultMaxIdleConns
+	}
+
+	switch {
+	case opts.Retries == 0:
+		opts.Retries = DefaultRetries
+	case opts.Retries < 0:
+		opts.Retries = 0
+	}
+	return
+}
+
+//OpenPool : process-wide database handle shared by stages, tasks & backend
+//The database is pinged until it answers, so a restarting database doesn't fail the start
+func OpenPool(creds utils.DBCredentialsSettings) (manager Manager, err error) {
+	opts := NewPoolOptions(creds.Pool)
+	manager.Database, manager.Dialect, err = open(creds, opts)
+	if err != nil {
+		return
+	}
+
+	manager.Database.SetMaxOpenConns(opts.MaxOpenConns)
+	manager.Database.SetMaxIdleConns(opts.MaxIdleConns)
+	manager.Database.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	manager.Database.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	manager.Retries = opts.Retries
+	manager.RetryDelay = opts.RetryDelay
+	manager.PingTimeout = opts.ConnectTimeout
+
+	err = manager.retry(func() error {
+		return manager.Ping(context.Background())
+	})
+
+	if err != nil {
+		manager.Close()
+	}
+	return
+}
+
+//Ping : check that the database answers within the connect timeout
+func (manager *Manager) Ping(ctx context.Context) (err error) {
+	timeout := manager.PingTimeout
+	if timeout <= 0 {
+		timeout = DefaultConnectTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return manager.Database.PingContext(ctx)
+}
+
+//Health : state of the pool for monitoring
+type Health struct {
+	Driver  string `json:"driver"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Latency int64  `json:"latency_ms"`
+
+	Stats sql.DBStats `json:"stats"`
+}
+
+//Health : ping database & collect pool statistics
+func (manager *Manager) Health(ctx context.Context) (health Health) {
+	start := time.Now()
+	err := manager.Ping(ctx)
+
+	health.Driver = manager.dialect().Name()
+	health.Latency = time.Since(start).Milliseconds()
+	health.OK = err == nil
+	if err != nil {
+		health.Error = err.Error()
+	}
+
+	health.Stats = manager.Database.Stats()
+	return
+}
+
+//retry : run fn again while it fails with an error the statement surely wasn't applied on
+func (manager *Manager) retry(fn func() error) (err error) {
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= manager.Retries || !manager.dialect().Transient(err) {
+			return
+		}
+
+		time.Sleep(manager.RetryDelay * time.Duration(attempt+1))
+	}
+}
+
+//transientPostgres : failures to connect, serialization failures & deadlocks, the transaction is not applied in all of them
+var transientPostgres = map[pq.ErrorCode]bool{
+	"08001": true, //sqlclient_unable_to_establish_sqlconnection
+	"08004": true, //sqlserver_rejected_establishment_of_sqlconnection
+	"40001": true, //serialization_failure
+	"40P01": true, //deadlock_detected
+	"53300": true, //too_many_connections
+	"57P03": true, //cannot_connect_now
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (postgresDialect) Transient(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return transientPostgres[pqErr.Code]
+	}
+	return isDialError(err)
+}
+
+//Transient : database is busy or locked by another connection even after busy timeout
+func (sqliteDialect) Transient(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	//primary result codes SQLITE_BUSY & SQLITE_LOCKED
+	code := sqliteErr.Code() & 0xff
+	return code == 5 || code == 6
+}
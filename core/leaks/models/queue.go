@@ -0,0 +1,194 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+//QueueRunTable : global name for table with runs of resumable stages
+var QueueRunTable = "queue_runs"
+
+//QueueTable : global name for table with requests built by the runs
+var QueueTable = "request_queue"
+
+const (
+	//RUNACTIVE : run is in progress or was interrupted & will be resumed
+	RUNACTIVE = "active"
+
+	//RUNDONE : all requests of the run were sent
+	RUNDONE = "done"
+)
+
+const (
+	//REQUESTPENDING : request wasn't sent or its response wasn't processed yet
+	REQUESTPENDING = "pending"
+
+	//REQUESTDONE : response of the request was processed
+	REQUESTDONE = "done"
+
+	//REQUESTFAILED : request was skipped or its response failed to process, it isn't repeated
+	REQUESTFAILED = "failed"
+)
+
+//QueueRun : run of a stage, requests are built once per run
+//Built is set when all requests of the run are in the queue, so a resumed run doesn't build them again
+type QueueRun struct {
+	ID       int    `json:"id"`
+	Stage    string `json:"stage"`
+	Status   string `json:"status"`
+	Built    bool   `json:"built"`
+	Started  int64  `json:"started"`
+	Finished int64  `json:"finished"`
+}
+
+//QueuedRequest : persisted request, URL identifies it within the run
+//Params are stage specific & JSON encoded, credentials are never stored
+type QueuedRequest struct {
+	ID      int    `json:"id"`
+	RunID   int    `json:"run_id"`
+	URL     string `json:"url"`
+	Params  []byte `json:"params"`
+	Status  string `json:"status"`
+	Error   string `json:"error"`
+	Updated int64  `json:"updated"`
+}
+
+//QueueProgress : requests of the run by status
+type QueueProgress struct {
+	Run     QueueRun `json:"run"`
+	Done    int      `json:"done"`
+	Pending int      `json:"pending"`
+	Failed  int      `json:"failed"`
+}
+
+func queueRunColumns() string {
+	return "id, stage, status, built, started, finished"
+}
+
+func scanQueueRun(row *sql.Row) (run QueueRun, err error) {
+	var built int
+	err = row.Scan(&run.ID, &run.Stage, &run.Status, &built, &run.Started, &run.Finished)
+	run.Built = built != 0
+	return
+}
+
+//OpenQueueRun : active run of the stage, new run is started if there is none
+func (manager *Manager) OpenQueueRun(stage string) (run QueueRun, resumed bool, err error) {
+	query := "SELECT " + queueRunColumns() + " FROM " + QueueRunTable + " WHERE stage=$1 AND status=$2 ORDER BY id DESC LIMIT 1;"
+	run, err = scanQueueRun(manager.queryRow(query, stage, RUNACTIVE))
+	if err == nil {
+		return run, true, nil
+	}
+
+	if err != sql.ErrNoRows {
+		return
+	}
+
+	run = QueueRun{Stage: stage, Status: RUNACTIVE, Started: time.Now().Unix()}
+	query = "INSERT INTO " + QueueRunTable + " (stage, status, built, started, finished) VALUES ($1, $2, 0, $3, 0) RETURNING id;"
+	err = manager.queryRow(query, run.Stage, run.Status, run.Started).Scan(&run.ID)
+	return
+}
+
+//SetQueueRunBuilt : all requests of the run are in the queue
+func (manager *Manager) SetQueueRunBuilt(runID int) (err error) {
+	query := "UPDATE " + QueueRunTable + " SET built=1 WHERE id=$1;"
+	_, err = manager.exec(query, runID)
+	return
+}
+
+//FinishQueueRun : mark run done, requests of older runs of the stage are deleted
+func (manager *Manager) FinishQueueRun(runID int) (err error) {
+	return manager.transaction(func(tx *sql.Tx) (err error) {
+		d := manager.dialect()
+		query := "UPDATE " + QueueRunTable + " SET status=$2, finished=$3 WHERE id=$1;"
+		_, err = tx.Exec(d.Rebind(query), runID, RUNDONE, time.Now().Unix())
+		if err != nil {
+			return
+		}
+
+		older := "SELECT o.id FROM " + QueueRunTable + " o, " + QueueRunTable + " r WHERE r.id=$1 AND o.stage=r.stage AND o.id<r.id"
+		_, err = tx.Exec(d.Rebind("DELETE FROM "+QueueTable+" WHERE run_id IN ("+older+");"), runID)
+		if err != nil {
+			return
+		}
+
+		_, err = tx.Exec(d.Rebind("DELETE FROM "+QueueRunTable+" WHERE id IN ("+older+");"), runID)
+		return
+	})
+}
+
+//EnqueueRequest : persist pending request, status of the request already queued by the run is returned as is
+func (manager *Manager) EnqueueRequest(runID int, URL string, params []byte) (status string, err error) {
+	query := "INSERT INTO " + QueueTable + " (run_id, url, params, status, error, updated) VALUES ($1, $2, $3, $4, '', $5) ON CONFLICT (run_id, url) DO NOTHING;"
+	_, err = manager.exec(query, runID, URL, params, REQUESTPENDING, time.Now().Unix())
+	if err != nil {
+		return
+	}
+
+	query = "SELECT status FROM " + QueueTable + " WHERE run_id=$1 AND url=$2;"
+	err = manager.queryRow(query, runID, URL).Scan(&status)
+	return
+}
+
+//UpdateQueuedRequest : set status of the request & error it failed with
+func (manager *Manager) UpdateQueuedRequest(runID int, URL, status, reason string) (err error) {
+	query := "UPDATE " + QueueTable + " SET status=$3, error=$4, updated=$5 WHERE run_id=$1 AND url=$2;"
+	_, err = manager.exec(query, runID, URL, status, reason, time.Now().Unix())
+	return
+}
+
+//SelectQueuedRequests : requests of the run with the status in order they were queued
+func (manager *Manager) SelectQueuedRequests(runID int, status string) (requests []QueuedRequest, err error) {
+	query := "SELECT id, run_id, url, params, status, error, updated FROM " + QueueTable + " WHERE run_id=$1 AND status=$2 ORDER BY id;"
+	rows, err := manager.query(query, runID, status)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var request QueuedRequest
+		err = rows.Scan(&request.ID, &request.RunID, &request.URL, &request.Params, &request.Status, &request.Error, &request.Updated)
+		if err != nil {
+			return
+		}
+		requests = append(requests, request)
+	}
+	return
+}
+
+//SelectQueueProgress : progress of the last run of the stage, sql.ErrNoRows if the stage never ran
+func (manager *Manager) SelectQueueProgress(stage string) (progress QueueProgress, err error) {
+	query := "SELECT " + queueRunColumns() + " FROM " + QueueRunTable + " WHERE stage=$1 ORDER BY id DESC LIMIT 1;"
+	progress.Run, err = scanQueueRun(manager.queryRow(query, stage))
+	if err != nil {
+		return
+	}
+
+	query = "SELECT status, COUNT(id) FROM " + QueueTable + " WHERE run_id=$1 GROUP BY status;"
+	rows, err := manager.query(query, progress.Run.ID)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return
+		}
+
+		switch status {
+		case REQUESTDONE:
+			progress.Done = count
+		case REQUESTPENDING:
+			progress.Pending = count
+		case REQUESTFAILED:
+			progress.Failed = count
+		}
+	}
+	return
+}
@@ -0,0 +1,198 @@
+package models
+
+import "time"
+
+//TaskRunTable : global name for table with runs of tasks
+var TaskRunTable = "task_runs"
+
+//TaskRunLogTable : global name for table with log lines of task runs
+var TaskRunLogTable = "task_run_logs"
+
+const (
+	//TASKRUNNING : run is in progress
+	TASKRUNNING = "running"
+
+	//TASKDONE : task returned without error
+	TASKDONE = "done"
+
+	//TASKFAILED : task returned error or the service stopped while it was running
+	TASKFAILED = "failed"
+
+	//TASKCANCELED : task was stopped
+	TASKCANCELED = "canceled"
+)
+
+const (
+	//LOGINFO : progress of the run
+	LOGINFO = "info"
+
+	//LOGERROR : error the run continued or failed with
+	LOGERROR = "error"
+)
+
+//RunCounters : work done by the run, updated while it runs
+//Requests include retries, Fragments include the Rejected ones
+type RunCounters struct {
+	Requests  int `json:"requests"`
+	Retries   int `json:"retries"`
+	Reports   int `json:"reports"`
+	Fragments int `json:"fragments"`
+	Rejected  int `json:"rejected"`
+}
+
+//TaskRun : run of a task, Finished is 0 while it runs
+type TaskRun struct {
+	ID       int    `json:"id"`
+	Task     string `json:"task"`
+	Status   string `json:"status"`
+	Error    string `json:"error"`
+	Started  int64  `json:"started"`
+	Finished int64  `json:"finished"`
+	RunCounters
+}
+
+//TaskRunLog : log line of the run
+type TaskRunLog struct {
+	ID      int    `json:"id"`
+	RunID   int    `json:"run_id"`
+	Time    int64  `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+//TaskRunFilter : conditions of task run selection, zero values are ignored
+type TaskRunFilter struct {
+	Task   string
+	Status string
+	Page
+}
+
+//taskRunSortColumns : columns task runs may be sorted by
+var taskRunSortColumns = map[string]string{"id": "id", "started": "started", "finished": "finished", "task": "task"}
+
+func (filter TaskRunFilter) where() (w *where) {
+	w = &where{}
+	if filter.Task != "" {
+		w.add("task", "=", filter.Task)
+	}
+
+	if filter.Status != "" {
+		w.add("status", "=", filter.Status)
+	}
+	return
+}
+
+func taskRunColumns() string {
+	return "id, task, status, error, started, finished, requests, retries, reports, fragments, rejected"
+}
+
+//scanner : single row or current row of rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTaskRun(row scanner) (run TaskRun, err error) {
+	err = row.Scan(&run.ID, &run.Task, &run.Status, &run.Error, &run.Started, &run.Finished,
+		&run.Requests, &run.Retries, &run.Reports, &run.Fragments, &run.Rejected)
+	return
+}
+
+//StartTaskRun : record new run of the task
+func (manager *Manager) StartTaskRun(task string) (run TaskRun, err error) {
+	run = TaskRun{Task: task, Status: TASKRUNNING, Started: time.Now().Unix()}
+	query := "INSERT INTO " + TaskRunTable + " (task, status, error, started, finished, requests, retries, reports, fragments, rejected) " +
+		"VALUES ($1, $2, '', $3, 0, 0, 0, 0, 0, 0) RETURNING id;"
+	err = manager.queryRow(query, run.Task, run.Status, run.Started).Scan(&run.ID)
+	return
+}
+
+//UpdateTaskRunCounters : set counters of the running task
+func (manager *Manager) UpdateTaskRunCounters(runID int, counters RunCounters) (err error) {
+	query := "UPDATE " + TaskRunTable + " SET requests=$2, retries=$3, reports=$4, fragments=$5, rejected=$6 WHERE id=$1;"
+	_, err = manager.exec(query, runID, counters.Requests, counters.Retries, counters.Reports, counters.Fragments, counters.Rejected)
+	return
+}
+
+//FinishTaskRun : set final status, error & counters of the run
+func (manager *Manager) FinishTaskRun(runID int, status, reason string, counters RunCounters) (err error) {
+	query := "UPDATE " + TaskRunTable + " SET status=$2, error=$3, finished=$4, requests=$5, retries=$6, reports=$7, fragments=$8, rejected=$9 WHERE id=$1;"
+	_, err = manager.exec(query, runID, status, reason, time.Now().Unix(),
+		counters.Requests, counters.Retries, counters.Reports, counters.Fragments, counters.Rejected)
+	return
+}
+
+//AbortTaskRuns : fail runs left running by the stopped service
+func (manager *Manager) AbortTaskRuns() (count int, err error) {
+	query := "UPDATE " + TaskRunTable + " SET status=$1, error=$2, finished=$3 WHERE status=$4;"
+	result, err := manager.exec(query, TASKFAILED, "interrupted", time.Now().Unix(), TASKRUNNING)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+//CountTaskRuns : return count of runs matching the filter, pagination is ignored
+func (manager *Manager) CountTaskRuns(filter TaskRunFilter) (count int, err error) {
+	w := filter.where()
+	query := "SELECT COUNT(id) FROM " + TaskRunTable + w.String() + ";"
+	err = manager.queryRow(query, w.args...).Scan(&count)
+	return
+}
+
+//SelectTaskRuns : select runs matching the filter
+func (manager *Manager) SelectTaskRuns(filter TaskRunFilter) (runs []TaskRun, err error) {
+	w := filter.where()
+	page, err := w.page(filter.Page, taskRunSortColumns)
+	if err != nil {
+		return
+	}
+
+	rows, err := manager.query("SELECT "+taskRunColumns()+" FROM "+TaskRunTable+w.String()+page+";", w.args...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		run, err := scanTaskRun(rows)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, run)
+	}
+	return
+}
+
+//SelectTaskRunByID : run by id, sql.ErrNoRows if there is none
+func (manager *Manager) SelectTaskRunByID(ID int) (run TaskRun, err error) {
+	return scanTaskRun(manager.queryRow("SELECT "+taskRunColumns()+" FROM "+TaskRunTable+" WHERE id=$1;", ID))
+}
+
+//InsertTaskRunLog : append line to the log of the run
+func (manager *Manager) InsertTaskRunLog(entry TaskRunLog) (err error) {
+	query := "INSERT INTO " + TaskRunLogTable + " (run_id, time, level, message) VALUES ($1, $2, $3, $4);"
+	_, err = manager.exec(query, entry.RunID, entry.Time, entry.Level, entry.Message)
+	return
+}
+
+//SelectTaskRunLogs : log of the run in order it was written
+func (manager *Manager) SelectTaskRunLogs(runID int) (logs []TaskRunLog, err error) {
+	query := "SELECT id, run_id, time, level, message FROM " + TaskRunLogTable + " WHERE run_id=$1 ORDER BY id;"
+	rows, err := manager.query(query, runID)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var entry TaskRunLog
+		err = rows.Scan(&entry.ID, &entry.RunID, &entry.Time, &entry.Level, &entry.Message)
+		if err != nil {
+			return
+		}
+		logs = append(logs, entry)
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+//ScheduleTable : global name for table with schedules of tasks
+var ScheduleTable = "task_schedules"
+
+const (
+	//SCHEDULESTARTED : task was started by the scheduler
+	SCHEDULESTARTED = "started"
+
+	//SCHEDULESKIPPED : task was still running when it was due, the run was skipped
+	SCHEDULESKIPPED = "skipped"
+)
+
+//TaskSchedule : cron schedule of the task & its runs, times are unix timestamps
+//NextRun is kept across restarts, so the run missed while the service was down is started once it is up
+type TaskSchedule struct {
+	Task       string `json:"task"`
+	Cron       string `json:"cron"`
+	LastRun    int64  `json:"last_run"`
+	LastStatus string `json:"last_status"`
+	NextRun    int64  `json:"next_run"`
+	Updated    int64  `json:"updated"`
+}
+
+//SelectTaskSchedule : schedule of the task, sql.ErrNoRows if it was never scheduled
+func (manager *Manager) SelectTaskSchedule(task string) (schedule TaskSchedule, err error) {
+	query := "SELECT task, cron, last_run, last_status, next_run, updated FROM " + ScheduleTable + " WHERE task=$1;"
+	err = manager.queryRow(query, task).Scan(&schedule.Task, &schedule.Cron, &schedule.LastRun, &schedule.LastStatus, &schedule.NextRun, &schedule.Updated)
+	return
+}
+
+//SelectTaskSchedules : schedules of all tasks ordered by task
+func (manager *Manager) SelectTaskSchedules() (schedules []TaskSchedule, err error) {
+	query := "SELECT task, cron, last_run, last_status, next_run, updated FROM " + ScheduleTable + " ORDER BY task;"
+	rows, err := manager.query(query)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var schedule TaskSchedule
+		err = rows.Scan(&schedule.Task, &schedule.Cron, &schedule.LastRun, &schedule.LastStatus, &schedule.NextRun, &schedule.Updated)
+		if err != nil {
+			return
+		}
+		schedules = append(schedules, schedule)
+	}
+	return
+}
+
+//SaveTaskSchedule : replace schedule of the task
+func (manager *Manager) SaveTaskSchedule(schedule TaskSchedule) (err error) {
+	query := "INSERT INTO " + ScheduleTable + " (task, cron, last_run, last_status, next_run, updated) VALUES ($1, $2, $3, $4, $5, $6) " +
+		"ON CONFLICT (task) DO UPDATE SET cron=excluded.cron, last_run=excluded.last_run, last_status=excluded.last_status, " +
+		"next_run=excluded.next_run, updated=excluded.updated;"
+	_, err = manager.exec(query, schedule.Task, schedule.Cron, schedule.LastRun, schedule.LastStatus, schedule.NextRun, time.Now().Unix())
+	return
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+//SearchFilter : text query over fragment content & report metadata, zero values are ignored
+//Since & Until bound the time of the report
+type SearchFilter struct {
+	Query     string
+	RejectIDs []int
+	Type      string
+	Since     int
+	Until     int
+	Page
+}
+
+//SearchResult : matched fragment with time of its report
+type SearchResult struct {
+	TextFragment
+	ReportTime int64 `json:"report_time"`
+}
+
+func (filter SearchFilter) where(d Dialect) (w *where, err error) {
+	query := strings.TrimSpace(filter.Query)
+	if len([]rune(query)) < MinTextQuery {
+		return nil, fmt.Errorf("search query must be at least %d characters long", MinTextQuery)
+	}
+
+	//the same bound query is matched against content & metadata
+	w = &where{}
+	placeholder := w.bind(d.TextQuery(query))
+	w.conds = append(w.conds, "("+d.TextMatch(FragmentTable, "f", "content", placeholder)+" OR "+d.TextMatch(ReportTable, "r", "data", placeholder)+")")
+
+	if len(filter.RejectIDs) > 0 {
+		w.in("f.reject_id", filter.RejectIDs)
+	}
+
+	if filter.Type != "" {
+		w.add("f.type", "=", filter.Type)
+	}
+
+	if filter.Since != 0 {
+		w.add("r.time", ">", filter.Since)
+	}
+
+	if filter.Until != 0 {
+		w.add("r.time", "<", filter.Until)
+	}
+	return
+}
+
+//SearchTextFragments : fragments whose content or report metadata contains the query
+//Total is the number of matches regardless of pagination
+func (manager *Manager) SearchTextFragments(filter SearchFilter) (results []SearchResult, total int, err error) {
+	w, err := filter.where(manager.dialect())
+	if err != nil {
+		return
+	}
+
+	err = manager.queryRow("SELECT COUNT(f.id)"+fragmentsFrom()+w.String()+";", w.args...).Scan(&total)
+	if err != nil {
+		return
+	}
+
+	page := filter.Page
+	if page.Sort == "" {
+		page.Sort = "-id"
+	}
+
+	pagination, err := w.page(page, fragmentSortColumns)
+	if err != nil {
+		return
+	}
+
+	query := "SELECT " + fragmentColumns() + ", COALESCE(r.time, 0)" + fragmentsFrom() + w.String() + pagination + ";"
+	rows, err := manager.query(query, w.args...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var result SearchResult
+		result.TextFragment, err = scanFragment(rows, &result.ReportTime)
+		if err != nil {
+			return
+		}
+
+		results = append(results, result)
+	}
+	return
+}
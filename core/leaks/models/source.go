@@ -0,0 +1,116 @@
+package models
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+)
+
+//Source : where the reported file was found, stored in report columns so it can be queried
+//Fragments carry the source of their report
+type Source struct {
+	URL      string `json:"url"`
+	Repo     string `json:"repo"`
+	Owner    string `json:"owner"`
+	Path     string `json:"path"`
+	Language string `json:"language"`
+	Keyword  string `json:"keyword"`
+}
+
+//sourceColumns : report columns holding Source fields, in order of the fields
+var sourceColumns = []string{"url", "repo", "owner", "path", "language", "keyword"}
+
+//fields : scan destinations in order of sourceColumns
+func (source *Source) fields() []interface{} {
+	return []interface{}{&source.URL, &source.Repo, &source.Owner, &source.Path, &source.Language, &source.Keyword}
+}
+
+//values : column values in order of sourceColumns
+func (source Source) values() []interface{} {
+	return []interface{}{source.URL, source.Repo, source.Owner, source.Path, source.Language, source.Keyword}
+}
+
+//selectSource : source columns of aliased report table, empty if the report is missing
+func selectSource(alias string) string {
+	columns := make([]string, 0, len(sourceColumns))
+	for _, column := range sourceColumns {
+		columns = append(columns, "COALESCE("+alias+"."+column+", '')")
+	}
+	return strings.Join(columns, ", ")
+}
+
+//extensionLangs : file extensions of the most common languages
+var extensionLangs = map[string]string{
+	".c": "C", ".h": "C", ".cs": "C#", ".cpp": "C++", ".cc": "C++", ".hpp": "C++",
+	".coffee": "CoffeeScript", ".css": "CSS", ".dart": "Dart", ".ex": "Elixir", ".exs": "Elixir",
+	".go": "Go", ".groovy": "Groovy", ".gradle": "Groovy", ".html": "HTML", ".htm": "HTML",
+	".java": "Java", ".js": "JavaScript", ".kt": "Kotlin", ".m": "Objective-C", ".pl": "Perl",
+	".php": "PHP", ".ps1": "PowerShell", ".py": "Python", ".rb": "Ruby", ".rs": "Rust",
+	".scala": "Scala", ".sh": "Shell", ".bash": "Shell", ".swift": "Swift", ".ts": "TypeScript",
+	".csv": "CSV", ".json": "JSON", ".md": "Markdown", ".yml": "YAML", ".yaml": "YAML",
+	".xml": "XML", ".diff": "Diff", ".patch": "Diff", ".erl": "Erlang", ".graphql": "GraphQL",
+	".ipynb": "Jupyter Notebook", ".lua": "Lua", ".proto": "Protocol Buffer", ".pub": "Public Key",
+	".sql": "SQL", ".txt": "Text", ".env": "Dotenv", ".properties": "Java Properties",
+}
+
+//LanguageExtensions : known extensions of the language without the dot, sorted
+func LanguageExtensions(lang string) (exts []string) {
+	for ext, extLang := range extensionLangs {
+		if extLang == lang {
+			exts = append(exts, strings.TrimPrefix(ext, "."))
+		}
+	}
+	sort.Strings(exts)
+	return
+}
+
+//LanguageByPath : guess language of the file by its extension
+func LanguageByPath(filePath string) string {
+	if lang, ok := extensionLangs[strings.ToLower(path.Ext(filePath))]; ok {
+		return lang
+	}
+
+	switch path.Base(filePath) {
+	case "Makefile":
+		return "Makefile"
+	case "Dockerfile":
+		return "Dockerfile"
+	}
+	return ""
+}
+
+//githubItem : fields of github search item stored in data of github reports
+type githubItem struct {
+	Path    string `json:"path"`
+	HTMLURL string `json:"html_url"`
+	Repo    struct {
+		FullName string `json:"full_name"`
+		Owner    struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+//SourceFromData : source of the report stored before source columns were added
+//Only github reports keep search item in data, keyword of the search is lost
+func SourceFromData(reportType string, data []byte) (source Source, err error) {
+	if reportType != "github" {
+		return
+	}
+
+	var item githubItem
+	err = json.Unmarshal(data, &item)
+	if err != nil {
+		return
+	}
+
+	source = Source{
+		URL:      item.HTMLURL,
+		Repo:     item.Repo.FullName,
+		Owner:    item.Repo.Owner.Login,
+		Path:     item.Path,
+		Language: LanguageByPath(item.Path),
+	}
+	return
+}
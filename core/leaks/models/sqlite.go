@@ -0,0 +1,61 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"modernc.org/sqlite"
+)
+
+//sqliteDriverName : SQLite driver registered by models
+const sqliteDriverName = "megamon-sqlite"
+
+func init() {
+	sql.Register(sqliteDriverName, sqliteDriver{&sqlite.Driver{}})
+}
+
+//sqliteDriver : SQLite driver with statements run without context
+//The driver interrupts the connection from a goroutine watching the context of a statement. Transaction cancels
+//its context when it ends, so the goroutine of an already finished statement may interrupt the connection
+//after it was returned to the pool & fail an unrelated statement. Without context the goroutine is never started
+type sqliteDriver struct {
+	driver driver.Driver
+}
+
+func (d sqliteDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteConn{conn}, nil
+}
+
+//sqliteConn : exposes only methods of the connection that don't take context
+type sqliteConn struct {
+	conn driver.Conn
+}
+
+func (c sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(query)
+}
+
+func (c sqliteConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c sqliteConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+func (c sqliteConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return c.conn.(driver.Execer).Exec(query, args)
+}
+
+func (c sqliteConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.conn.(driver.Queryer).Query(query, args)
+}
+
+func (c sqliteConn) Ping(ctx context.Context) error {
+	return c.conn.(driver.Pinger).Ping(context.Background())
+}
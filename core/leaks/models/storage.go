@@ -0,0 +1,90 @@
+package models
+
+import "context"
+
+//Storage : persistence of reports, fragments, rules, keywords, allowlist, request queue, search watermarks, coverage, task schedules, task runs & audit log
+//Manager implements it on top of database/sql, the database is chosen by the Dialect
+//Stages, tasks, the backend & commands depend on Storage, only opening the pool & migrations need the Manager
+type Storage interface {
+	InsertTextFragment(frag *TextFragment) (ID int, err error)
+	InsertTextFragments(frags []TextFragment) (inserted int, err error)
+	CommitReportFragments(reportID int, frags []TextFragment, status string) (inserted int, err error)
+	UpdateTextFragmentRejectID(ID, rejectID int) (err error)
+	DeleteTextFragmentByID(ID int) (err error)
+	DeleteTextFragmentsByReport(reportID int) (count int, err error)
+	CountTextFragments(filter FragmentFilter) (count int, err error)
+	SelectTextFragments(filter FragmentFilter) (frags []TextFragment, err error)
+	SelectTextFragmentByID(ID int) (frag TextFragment, err error)
+	CheckTextFragmentDuplicate(ShaHash string) (exist bool, err error)
+	SearchTextFragments(filter SearchFilter) (results []SearchResult, total int, err error)
+
+	InsertReport(report Report) (ID int, err error)
+	InsertReports(reports []Report) (inserted int, err error)
+	UpdateReportStatus(reportID int, status string) (err error)
+	UpdateReportsStatus(reportType, prev, next string) (err error)
+	UpdateReportTime(reportID int, timestamp int) (err error)
+	UpdateReportPurged(reportID int, level int) (err error)
+	DeleteReportByID(ID int) (err error)
+	SelectReportByID(ID int) (rep Report, err error)
+	SelectReportTypes() (types []string, err error)
+	SelectReports(filter ReportFilter) (reports []Report, err error)
+	CheckReportDuplicate(ShaHash string) (exist bool, err error)
+	CountReports(filter ReportFilter) (count int, err error)
+
+	InsertRule(rule RejectRule) (ID int, err error)
+	UpdateRule(rule RejectRule) (err error)
+	DeleteRuleByID(ID int) (err error)
+	SelectRuleByID(ID int) (rule RejectRule, err error)
+	SelectAllRules() (rules []RejectRule, err error)
+
+	InsertKeyword(keyword string, wordType int) (ID int, err error)
+	UpdateKeyword(keyword Keyword) (err error)
+	DeleteKeyword(ID int) (err error)
+	SelectKeywordByType(wordType int) (keywords []Keyword, err error)
+	SelectAllKeywords() (keywords []Keyword, err error)
+	SelectKeywordByID(ID int) (keyword Keyword, err error)
+
+	InsertAllowlistEntry(entry AllowlistEntry) (ID int, err error)
+	DeleteAllowlistEntry(ID int) (err error)
+	IncrementAllowlistHits(ID int, count int) (err error)
+	SelectAllowlist() (entries []AllowlistEntry, err error)
+
+	OpenQueueRun(stage string) (run QueueRun, resumed bool, err error)
+	SetQueueRunBuilt(runID int) (err error)
+	FinishQueueRun(runID int) (err error)
+	EnqueueRequest(runID int, URL string, params []byte) (status string, err error)
+	UpdateQueuedRequest(runID int, URL, status, reason string) (err error)
+	SelectQueuedRequests(runID int, status string) (requests []QueuedRequest, err error)
+	SelectQueueProgress(stage string) (progress QueueProgress, err error)
+
+	SelectWatermark(query string) (wm Watermark, err error)
+	SaveWatermark(wm Watermark) (err error)
+	SaveCoverage(coverage Coverage) (err error)
+	SelectCoverage() (coverage []Coverage, err error)
+
+	SelectTaskSchedule(task string) (schedule TaskSchedule, err error)
+	SelectTaskSchedules() (schedules []TaskSchedule, err error)
+	SaveTaskSchedule(schedule TaskSchedule) (err error)
+
+	StartTaskRun(task string) (run TaskRun, err error)
+	UpdateTaskRunCounters(runID int, counters RunCounters) (err error)
+	FinishTaskRun(runID int, status, reason string, counters RunCounters) (err error)
+	AbortTaskRuns() (count int, err error)
+	CountTaskRuns(filter TaskRunFilter) (count int, err error)
+	SelectTaskRuns(filter TaskRunFilter) (runs []TaskRun, err error)
+	SelectTaskRunByID(ID int) (run TaskRun, err error)
+	InsertTaskRunLog(entry TaskRunLog) (err error)
+	SelectTaskRunLogs(runID int) (logs []TaskRunLog, err error)
+
+	InsertAuditEntry(entry AuditEntry) (ID int, err error)
+	CountAuditEntries(filter AuditFilter) (count int, err error)
+	SelectAuditEntries(filter AuditFilter) (entries []AuditEntry, err error)
+
+	//Transaction : run fn with storage bound to one transaction, it is committed if fn returns nil
+	Transaction(fn func(tx Storage) error) (err error)
+
+	Health(ctx context.Context) (health Health)
+	Close()
+}
+
+var _ Storage = (*Manager)(nil)
@@ -1,6 +1,10 @@
 package models
 
-import "regexp"
+import (
+	"regexp"
+
+	"github.com/megamon/core/leaks/expr"
+)
 
 //Report : report structure
 type Report struct {
@@ -10,14 +14,30 @@ type Report struct {
 	Status  string `json:"status"`
 	Data    []byte `json:"data"`
 	ID      int    `json:"id"`
+
+	//Purged : retention level applied to the report, PURGENONE if it is intact
+	Purged int `json:"purged"`
+
+	Source
 }
 
 //RejectRule : description of reject rule
+//Kind defines how Rule is compiled: regexp into Expr, expression into Cond
 type RejectRule struct {
 	ID   int    `json:"id"`
 	Rule string `json:"rule"`
 	Name string `json:"name"`
+	Kind string `json:"kind"`
 	Expr *regexp.Regexp
+	Cond *expr.Expr `json:"-"`
+}
+
+//AllowlistEntry : known-safe source, results matching it are suppressed
+type AllowlistEntry struct {
+	ID    int    `json:"id"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Hits  int    `json:"hits"`
 }
 
 //TextFragment : fragments of text with keywords
@@ -29,6 +49,12 @@ type TextFragment struct {
 	ReportID int     `json:"report_id"`
 	RejectID int     `json:"reject_id"`
 	Keywords [][]int `json:"keywords"`
+
+	//Score : leak probability estimated by classifier, -1 if not scored
+	Score float64 `json:"score"`
+
+	//Source : source of the report, filled on selection only
+	Source
 }
 
 //Keyword : auxilary data type
@@ -38,6 +64,20 @@ type Keyword struct {
 	Type  int    `json:"type"`
 }
 
+const (
+	//PURGENONE : report is intact
+	PURGENONE = iota
+
+	//PURGECONTENT : fetched content was deleted
+	PURGECONTENT
+
+	//PURGEFRAGMENTS : content & fragments were deleted, report is kept for deduplication
+	PURGEFRAGMENTS
+
+	//PURGEREPORT : report itself is deleted, used by retention only
+	PURGEREPORT
+)
+
 const (
 	//KWSEARCHABLE : searchable keyword type
 	KWSEARCHABLE = iota
@@ -59,3 +99,38 @@ const (
 	//RULEAUTOREMOVED : fragment was automatically removed by regexp
 	RULEAUTOREMOVED
 )
+
+//PredefinedRules : names of the status rules seeded into a new rules table, in order of their ids
+var PredefinedRules = []string{"none", "manual", "verified", "auto_removed"}
+
+//IsPredefinedRule : name is taken by a status rule, other rules must not use it
+func IsPredefinedRule(name string) bool {
+	for _, predefined := range PredefinedRules {
+		if name == predefined {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	//ALLOWREPO : allowlist entry with repository full name (owner/repo)
+	ALLOWREPO = "repo"
+
+	//ALLOWOWNER : allowlist entry with owner login
+	ALLOWOWNER = "owner"
+
+	//ALLOWSHA : allowlist entry with blob sha hash
+	ALLOWSHA = "sha"
+
+	//ALLOWPATH : allowlist entry with file path glob
+	ALLOWPATH = "path"
+)
+
+const (
+	//RULEKINDREGEXP : rule is a regular expression matched against keyword context
+	RULEKINDREGEXP = "regexp"
+
+	//RULEKINDEXPR : rule is a boolean expression over fragment & report metadata
+	RULEKINDEXPR = "expr"
+)
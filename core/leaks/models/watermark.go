@@ -0,0 +1,116 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+//WatermarkTable : global name for table with search watermarks
+var WatermarkTable = "search_watermarks"
+
+//Watermark : results of the search query seen by the last complete run, the newest first
+//Results are ordered by index time, so a page of already seen results ends the walk over the query
+type Watermark struct {
+	Query     string   `json:"query"`
+	ShaHashes []string `json:"sha_hashes"`
+	Total     int      `json:"total"`
+	Updated   int64    `json:"updated"`
+}
+
+//Seen : hash is among the results seen by the last complete run
+func (wm Watermark) Seen(shaHash string) bool {
+	for _, seen := range wm.ShaHashes {
+		if seen == shaHash {
+			return true
+		}
+	}
+	return false
+}
+
+//SelectWatermark : watermark of the query, sql.ErrNoRows if the query never ran
+func (manager *Manager) SelectWatermark(query string) (wm Watermark, err error) {
+	var shaHashes string
+	row := manager.queryRow("SELECT query, shahashes, total, updated FROM "+WatermarkTable+" WHERE query=$1;", query)
+	err = row.Scan(&wm.Query, &shaHashes, &wm.Total, &wm.Updated)
+	if err != nil {
+		return
+	}
+
+	if shaHashes != "" {
+		wm.ShaHashes = strings.Split(shaHashes, ",")
+	}
+	return
+}
+
+//SaveWatermark : replace watermark of the query
+func (manager *Manager) SaveWatermark(wm Watermark) (err error) {
+	query := "INSERT INTO " + WatermarkTable + " (query, shahashes, total, updated) VALUES ($1, $2, $3, $4) " +
+		"ON CONFLICT (query) DO UPDATE SET shahashes=excluded.shahashes, total=excluded.total, updated=excluded.updated;"
+	_, err = manager.exec(query, wm.Query, strings.Join(wm.ShaHashes, ","), wm.Total, time.Now().Unix())
+	return
+}
+
+//CoverageTable : global name for table with search coverage of keywords
+var CoverageTable = "search_coverage"
+
+//Coverage : how many results of the keyword the last run could reach
+//Query is split into partitions of at most 1000 results, Truncated partitions couldn't be split further
+type Coverage struct {
+	Keyword     string  `json:"keyword"`
+	Total       int     `json:"total"`
+	Retrievable int     `json:"retrievable"`
+	Partitions  int     `json:"partitions"`
+	Truncated   int     `json:"truncated"`
+	Probes      int     `json:"probes"`
+	Ratio       float64 `json:"ratio"`
+	Updated     int64   `json:"updated"`
+}
+
+//CoverageRatio : share of the results reachable by pagination, partitions by language & path may overlap
+func CoverageRatio(total, retrievable int) float64 {
+	if total == 0 || retrievable >= total {
+		return 1
+	}
+	return float64(retrievable) / float64(total)
+}
+
+//SaveCoverage : replace coverage of the keyword
+func (manager *Manager) SaveCoverage(coverage Coverage) (err error) {
+	query := "INSERT INTO " + CoverageTable + " (keyword, total, retrievable, partitions, truncated, probes, updated) VALUES ($1, $2, $3, $4, $5, $6, $7) " +
+		"ON CONFLICT (keyword) DO UPDATE SET total=excluded.total, retrievable=excluded.retrievable, partitions=excluded.partitions, " +
+		"truncated=excluded.truncated, probes=excluded.probes, updated=excluded.updated;"
+	_, err = manager.exec(query, coverage.Keyword, coverage.Total, coverage.Retrievable, coverage.Partitions, coverage.Truncated, coverage.Probes, time.Now().Unix())
+	return
+}
+
+//SelectCoverage : coverage of all searched keywords, the worst covered first
+func (manager *Manager) SelectCoverage() (coverage []Coverage, err error) {
+	query := "SELECT keyword, total, retrievable, partitions, truncated, probes, updated FROM " + CoverageTable + " ORDER BY keyword;"
+	rows, err := manager.query(query)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var c Coverage
+		err = rows.Scan(&c.Keyword, &c.Total, &c.Retrievable, &c.Partitions, &c.Truncated, &c.Probes, &c.Updated)
+		if err != nil {
+			return
+		}
+
+		c.Ratio = CoverageRatio(c.Total, c.Retrievable)
+		coverage = append(coverage, c)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	sort.SliceStable(coverage, func(i, j int) bool {
+		return coverage[i].Ratio < coverage[j].Ratio
+	})
+	return
+}
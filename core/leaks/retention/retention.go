@@ -0,0 +1,211 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/megamon/core/leaks/content"
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/utils"
+)
+
+const (
+	//ActionContent : delete fetched content, report & fragments are kept
+	ActionContent = "content"
+
+	//ActionFragments : delete content & fragments, report is kept to deduplicate search results
+	ActionFragments = "fragments"
+
+	//ActionReport : delete everything, the same file may be reported again
+	ActionReport = "report"
+)
+
+//DefaultInterval : time between maintenance runs if neither schedule nor retention_interval is set
+const DefaultInterval = 24 * time.Hour
+
+var actionLevels = map[string]int{
+	ActionContent:   models.PURGECONTENT,
+	ActionFragments: models.PURGEFRAGMENTS,
+	ActionReport:    models.PURGEREPORT,
+}
+
+//Item : report selected by a policy
+type Item struct {
+	ReportID  int    `json:"report_id"`
+	ShaHash   string `json:"sha1"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	Time      int64  `json:"time"`
+	Action    string `json:"action"`
+	Fragments int    `json:"fragments"`
+}
+
+//Result : what was (or would be in dry run) purged
+type Result struct {
+	DryRun    bool   `json:"dry_run"`
+	Created   int64  `json:"created"`
+	Items     []Item `json:"items"`
+	Contents  int    `json:"contents"`
+	Fragments int    `json:"fragments"`
+	Reports   int    `json:"reports"`
+}
+
+//Validate : check policy before it is applied
+func Validate(policy utils.RetentionPolicy) (err error) {
+	if _, ok := actionLevels[policy.Action]; !ok {
+		return fmt.Errorf("retention: unknown action %q", policy.Action)
+	}
+
+	if policy.Status == "" {
+		return fmt.Errorf("retention: status is required, reports in progress must not be purged")
+	}
+
+	if policy.Days <= 0 {
+		return fmt.Errorf("retention: days must be positive, got %d", policy.Days)
+	}
+	return
+}
+
+//Plan : reports to purge, report matched by several policies gets the strongest action
+func Plan(manager models.Storage, policies []utils.RetentionPolicy, now time.Time) (items []Item, err error) {
+	selected := make(map[int]int)
+	for _, policy := range policies {
+		if err = Validate(policy); err != nil {
+			return
+		}
+
+		level := actionLevels[policy.Action]
+		cutoff := now.Add(-time.Duration(policy.Days) * 24 * time.Hour).Unix()
+
+		reports, err := manager.SelectReports(models.ReportFilter{
+			Type:        policy.Type,
+			Status:      policy.Status,
+			Until:       int(cutoff),
+			PurgedBelow: level,
+			Page:        models.Page{Sort: "id"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, report := range reports {
+			if i, ok := selected[report.ID]; ok {
+				if level > actionLevels[items[i].Action] {
+					items[i].Action = policy.Action
+				}
+				continue
+			}
+
+			selected[report.ID] = len(items)
+			items = append(items, Item{
+				ReportID: report.ID,
+				ShaHash:  report.ShaHash,
+				Type:     report.Type,
+				Status:   report.Status,
+				Time:     report.Time,
+				Action:   policy.Action,
+			})
+		}
+	}
+
+	for i := range items {
+		if items[i].Action == ActionContent {
+			continue
+		}
+
+		items[i].Fragments, err = manager.CountTextFragments(models.FragmentFilter{ReportID: items[i].ReportID})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//Apply : purge planned items, content is deleted first so a failure never leaves orphaned files
+func Apply(manager models.Storage, store content.Store, items []Item) (result Result, err error) {
+	for _, item := range items {
+		level := actionLevels[item.Action]
+
+		err = store.Delete(item.ShaHash)
+		if err != nil {
+			return
+		}
+		result.Contents++
+
+		if level >= models.PURGEFRAGMENTS {
+			deleted, err := manager.DeleteTextFragmentsByReport(item.ReportID)
+			if err != nil {
+				return result, err
+			}
+			result.Fragments += deleted
+		}
+
+		if level >= models.PURGEREPORT {
+			err = manager.DeleteReportByID(item.ReportID)
+			result.Reports++
+		} else {
+			err = manager.UpdateReportPurged(item.ReportID, level)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//Run : plan & apply policies, in dry run nothing is deleted
+func Run(manager models.Storage, store content.Store, policies []utils.RetentionPolicy, now time.Time, dryRun bool) (result Result, err error) {
+	items, err := Plan(manager, policies, now)
+	if err != nil {
+		return
+	}
+
+	if dryRun {
+		for _, item := range items {
+			result.Contents++
+			result.Fragments += item.Fragments
+			if item.Action == ActionReport {
+				result.Reports++
+			}
+		}
+	} else {
+		result, err = Apply(manager, store, items)
+	}
+
+	result.DryRun = dryRun
+	result.Created = now.Unix()
+	result.Items = items
+	return
+}
+
+//RunOnce : apply policies from settings
+func RunOnce(manager models.Storage, dryRun bool) (result Result, err error) {
+	store, err := content.Default()
+	if err != nil {
+		return
+	}
+
+	return Run(manager, store, utils.Settings.LeakGlobals.Retention, time.Now(), dryRun)
+}
+
+//RunMaintenance : task applying retention policies once, the scheduler repeats it
+func RunMaintenance(ctx context.Context, manager models.Storage) (err error) {
+	result, err := RunOnce(manager, false)
+	if err != nil {
+		return
+	}
+
+	utils.InfoLogger.Printf("retention: %d contents, %d fragments, %d reports purged", result.Contents, result.Fragments, result.Reports)
+	return
+}
+
+//DefaultSchedule : schedule of the maintenance task if settings have none, every retention_interval hours
+func DefaultSchedule() string {
+	interval := time.Duration(utils.Settings.LeakGlobals.RetentionInterval) * time.Hour
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return fmt.Sprintf("@every %s", interval)
+}
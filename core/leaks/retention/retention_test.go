@@ -0,0 +1,114 @@
+package retention
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/megamon/core/leaks/content"
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/utils"
+)
+
+func setup(t *testing.T) (manager *models.Manager, store content.Store, dir string) {
+	dir, err := ioutil.TempDir("", "retention")
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	utils.Settings.DBCredentials = utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")}
+	manager = &models.Manager{}
+	if err = manager.Init(); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if err = manager.Migrate(); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	store = &content.FSStore{Dir: dir, Codec: content.Codecs[0]}
+	return
+}
+
+func addReport(t *testing.T, manager models.Storage, store content.Store, status string, age time.Duration, fragments int) int {
+	sha := fmt.Sprintf("%s%d", status, age)
+	ID, err := manager.InsertReport(models.Report{ShaHash: sha, Type: "github", Status: status, Data: []byte("{}"), Time: time.Now().Add(-age).Unix()})
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	for i := 0; i < fragments; i++ {
+		frag := models.TextFragment{ReportID: ID, ShaHash: fmt.Sprintf("%s_%d", sha, i), Type: "github", Keywords: [][]int{}}
+		if _, err = manager.InsertTextFragment(&frag); err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+	}
+
+	if err = store.Put(sha, []byte("content")); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	return ID
+}
+
+func TestRun(t *testing.T) {
+	manager, store, dir := setup(t)
+	defer os.RemoveAll(dir)
+	defer manager.Close()
+
+	day := 24 * time.Hour
+	oldClosed := addReport(t, manager, store, "closed", 40*day, 2)
+	newClosed := addReport(t, manager, store, "closed", 10*day, 1)
+	oldVerified := addReport(t, manager, store, "validated", 100*day, 1)
+
+	policies := []utils.RetentionPolicy{
+		{Status: "closed", Days: 30, Action: ActionContent},
+		{Type: "github", Status: "closed", Days: 30, Action: ActionFragments},
+		{Status: "validated", Days: 365, Action: ActionReport},
+	}
+
+	dry, err := Run(manager, store, policies, time.Now(), true)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if len(dry.Items) != 1 || dry.Items[0].ReportID != oldClosed || dry.Items[0].Action != ActionFragments || dry.Fragments != 2 {
+		t.Errorf("Wrong dry run: %+v", dry)
+	}
+
+	if exist, _ := store.Exists(dry.Items[0].ShaHash); !exist {
+		t.Errorf("Dry run must not delete content")
+	}
+
+	result, err := Run(manager, store, policies, time.Now(), false)
+	if err != nil || result.Contents != 1 || result.Fragments != 2 || result.Reports != 0 {
+		t.Errorf("Wrong result: %+v %v", result, err)
+	}
+
+	report, err := manager.SelectReportByID(oldClosed)
+	if err != nil || report.Purged != models.PURGEFRAGMENTS {
+		t.Errorf("Expected report to be kept for deduplication: %+v %v", report, err)
+	}
+
+	if exist, _ := store.Exists(report.ShaHash); exist {
+		t.Errorf("Expected content to be deleted")
+	}
+
+	for _, ID := range []int{newClosed, oldVerified} {
+		if count, _ := manager.CountTextFragments(models.FragmentFilter{ReportID: ID}); count != 1 {
+			t.Errorf("Fragments of report %d must be kept", ID)
+		}
+	}
+
+	//purged reports are not selected again
+	if again, _ := Run(manager, store, policies, time.Now(), true); len(again.Items) != 0 {
+		t.Errorf("Expected nothing to purge, got: %+v", again.Items)
+	}
+
+	if _, err = Run(manager, store, []utils.RetentionPolicy{{Days: 1, Action: ActionReport}}, time.Now(), true); err == nil {
+		t.Errorf("Expected error for policy without status")
+	}
+	return
+}
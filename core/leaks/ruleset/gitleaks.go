@@ -0,0 +1,138 @@
+package ruleset
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+//searchableTag : gitleaks rule tag marking keywords used in github search
+const searchableTag = "megamon:searchable"
+
+type gitleaksAllowlist struct {
+	Description string   `toml:"description,omitempty"`
+	Regexes     []string `toml:"regexes,omitempty"`
+	Paths       []string `toml:"paths,omitempty"`
+	StopWords   []string `toml:"stopwords,omitempty"`
+}
+
+type gitleaksRule struct {
+	ID          string              `toml:"id"`
+	Description string              `toml:"description,omitempty"`
+	Regex       string              `toml:"regex,omitempty"`
+	Path        string              `toml:"path,omitempty"`
+	Keywords    []string            `toml:"keywords,omitempty"`
+	Tags        []string            `toml:"tags,omitempty"`
+	Allowlist   *gitleaksAllowlist  `toml:"allowlist,omitempty"`
+	Allowlists  []gitleaksAllowlist `toml:"allowlists,omitempty"`
+}
+
+type gitleaksConfig struct {
+	Title     string             `toml:"title,omitempty"`
+	Allowlist *gitleaksAllowlist `toml:"allowlist,omitempty"`
+	Rules     []gitleaksRule     `toml:"rules,omitempty"`
+}
+
+//parseGitleaks : map gitleaks config onto reject rules & keywords
+//Allowlist regexes & stopwords become reject rules, rule keywords become inner keywords
+//(or searchable ones, if the rule is tagged with searchableTag).
+//Detection regexes & path allowlists have no counterpart and are reported as skipped
+func parseGitleaks(data []byte) (set RuleSet, err error) {
+	var config gitleaksConfig
+	_, err = toml.Decode(string(data), &config)
+	if err != nil {
+		return
+	}
+
+	if config.Allowlist != nil {
+		set.addAllowlist("gitleaks", *config.Allowlist)
+	}
+
+	for _, rule := range config.Rules {
+		if rule.Allowlist != nil {
+			set.addAllowlist(rule.ID, *rule.Allowlist)
+		}
+
+		for _, allowlist := range rule.Allowlists {
+			set.addAllowlist(rule.ID, allowlist)
+		}
+
+		if len(rule.Keywords) == 0 {
+			set.Skipped = append(set.Skipped, fmt.Sprintf("rule %q: no keywords", rule.ID))
+			continue
+		}
+
+		searchable := false
+		for _, tag := range rule.Tags {
+			if tag == searchableTag {
+				searchable = true
+			}
+		}
+
+		for _, keyword := range rule.Keywords {
+			set.Keywords = append(set.Keywords, Keyword{Value: keyword, Searchable: searchable})
+		}
+	}
+	return
+}
+
+func (set *RuleSet) addAllowlist(prefix string, allowlist gitleaksAllowlist) {
+	for i, expr := range allowlist.Regexes {
+		set.Rules = append(set.Rules, Rule{Name: fmt.Sprintf("%s:allowlist:%d", prefix, i), Rule: expr})
+	}
+
+	for _, word := range allowlist.StopWords {
+		set.Rules = append(set.Rules, Rule{Name: fmt.Sprintf("%s:stopword:%s", prefix, word), Rule: "(?i)" + regexp.QuoteMeta(word)})
+	}
+
+	for _, path := range allowlist.Paths {
+		set.Skipped = append(set.Skipped, fmt.Sprintf("%s: path allowlist %q", prefix, path))
+	}
+	return
+}
+
+//marshalGitleaks : reject rules go to the global allowlist, every keyword becomes a rule
+//Expression rules have no gitleaks counterpart and are left out
+func marshalGitleaks(set RuleSet) (data []byte, err error) {
+	var config gitleaksConfig
+	config.Title = "megamon rule set"
+
+	for _, rule := range set.Rules {
+		if rule.Kind != "" {
+			continue
+		}
+
+		if config.Allowlist == nil {
+			config.Allowlist = &gitleaksAllowlist{Description: "megamon reject rules"}
+		}
+		config.Allowlist.Regexes = append(config.Allowlist.Regexes, rule.Rule)
+	}
+
+	for _, keyword := range set.Keywords {
+		rule := gitleaksRule{
+			ID:          "megamon-" + ruleID(keyword.Value),
+			Description: "megamon keyword " + keyword.Value,
+			Regex:       "(?i)" + regexp.QuoteMeta(keyword.Value),
+			Keywords:    []string{strings.ToLower(keyword.Value)},
+		}
+
+		if keyword.Searchable {
+			rule.Tags = []string{searchableTag}
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+
+	var buf bytes.Buffer
+	err = toml.NewEncoder(&buf).Encode(config)
+	data = buf.Bytes()
+	return
+}
+
+var nonIdentifier = regexp.MustCompile(`[^a-z0-9]+`)
+
+func ruleID(value string) string {
+	return strings.Trim(nonIdentifier.ReplaceAllString(strings.ToLower(value), "-"), "-")
+}
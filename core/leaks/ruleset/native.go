@@ -0,0 +1,24 @@
+package ruleset
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+func parseYAML(data []byte) (set RuleSet, err error) {
+	err = yaml.Unmarshal(data, &set)
+	if err != nil {
+		return
+	}
+
+	if set.Version > Version {
+		err = fmt.Errorf("unsupported rule set version: %d", set.Version)
+	}
+	return
+}
+
+func marshalYAML(set RuleSet) (data []byte, err error) {
+	set.Version = Version
+	return yaml.Marshal(set)
+}
@@ -0,0 +1,362 @@
+package ruleset
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/megamon/core/leaks/models"
+)
+
+const (
+	//FormatYAML : native megamon rule set format
+	FormatYAML = "yaml"
+
+	//FormatGitleaks : gitleaks TOML config format
+	FormatGitleaks = "gitleaks"
+)
+
+const (
+	//ConflictSkip : keep existing entries, ignore imported duplicates
+	ConflictSkip = "skip"
+
+	//ConflictReplace : overwrite existing entries with imported ones
+	ConflictReplace = "replace"
+
+	//ConflictFail : abort import if any duplicate differs from the existing entry
+	ConflictFail = "fail"
+)
+
+//Version : current version of the native format
+const Version = 1
+
+//Rule : portable reject rule, regexp unless kind says otherwise
+type Rule struct {
+	Name string `yaml:"name" json:"name"`
+	Rule string `yaml:"rule" json:"rule"`
+	Kind string `yaml:"kind,omitempty" json:"kind,omitempty"`
+}
+
+//Keyword : portable keyword
+type Keyword struct {
+	Value      string `yaml:"value" json:"value"`
+	Searchable bool   `yaml:"searchable" json:"searchable"`
+}
+
+//RuleSet : set of reject rules & keywords to move between instances
+type RuleSet struct {
+	Version  int       `yaml:"version" json:"version"`
+	Rules    []Rule    `yaml:"rules" json:"rules"`
+	Keywords []Keyword `yaml:"keywords" json:"keywords"`
+
+	//Skipped : entries of the source that could not be mapped
+	Skipped []string `yaml:"-" json:"skipped,omitempty"`
+}
+
+//Summary : result of the import
+type Summary struct {
+	RulesAdded      int      `json:"rules_added"`
+	RulesUpdated    int      `json:"rules_updated"`
+	RulesSkipped    int      `json:"rules_skipped"`
+	KeywordsAdded   int      `json:"keywords_added"`
+	KeywordsUpdated int      `json:"keywords_updated"`
+	KeywordsSkipped int      `json:"keywords_skipped"`
+	Skipped         []string `json:"skipped,omitempty"`
+}
+
+//ConflictError : returned by Apply with ConflictFail policy
+type ConflictError struct {
+	Conflicts []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("import conflicts with existing entries: %s", strings.Join(e.Conflicts, "; "))
+}
+
+//Parse : decode rule set in the given format
+func Parse(format string, data []byte) (set RuleSet, err error) {
+	switch format {
+	case FormatYAML:
+		set, err = parseYAML(data)
+	case FormatGitleaks:
+		set, err = parseGitleaks(data)
+	default:
+		err = fmt.Errorf("unknown rule set format: %s", format)
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	err = set.normalize()
+	return
+}
+
+//Marshal : encode rule set in the given format
+func Marshal(format string, set RuleSet) (data []byte, err error) {
+	switch format {
+	case FormatYAML:
+		return marshalYAML(set)
+	case FormatGitleaks:
+		return marshalGitleaks(set)
+	}
+
+	err = fmt.Errorf("unknown rule set format: %s", format)
+	return
+}
+
+//Load : read rule set from the database
+func Load(manager models.Storage) (set RuleSet, err error) {
+	rules, err := manager.SelectAllRules()
+	if err != nil {
+		return
+	}
+
+	keywords, err := manager.SelectAllKeywords()
+	if err != nil {
+		return
+	}
+
+	set.Version = Version
+	for _, rule := range rules {
+		if isReserved(rule) {
+			continue
+		}
+		set.Rules = append(set.Rules, Rule{Name: rule.Name, Rule: rule.Rule, Kind: portableKind(rule.Kind)})
+	}
+
+	for _, keyword := range keywords {
+		set.Keywords = append(set.Keywords, Keyword{Value: keyword.Value, Searchable: keyword.Type == models.KWSEARCHABLE})
+	}
+	return
+}
+
+//Apply : write rule set into the database resolving duplicates with conflict policy
+//The rule set is written in one transaction, nothing is imported if any statement fails
+func Apply(manager models.Storage, set RuleSet, conflict string) (summary Summary, err error) {
+	if conflict == "" {
+		conflict = ConflictSkip
+	}
+
+	if conflict != ConflictSkip && conflict != ConflictReplace && conflict != ConflictFail {
+		err = fmt.Errorf("unknown conflict policy: %s", conflict)
+		return
+	}
+
+	err = manager.Transaction(func(tx models.Storage) (err error) {
+		summary, err = apply(tx, set, conflict)
+		return
+	})
+
+	if err != nil {
+		summary = Summary{}
+	}
+	return
+}
+
+//apply : import of the rule set into the transaction
+func apply(manager models.Storage, set RuleSet, conflict string) (summary Summary, err error) {
+	for _, rule := range set.Rules {
+		if models.IsPredefinedRule(rule.Name) {
+			err = fmt.Errorf("rule %q: name is reserved for a status rule", rule.Name)
+			return
+		}
+	}
+
+	rules, err := manager.SelectAllRules()
+	if err != nil {
+		return
+	}
+
+	keywords, err := manager.SelectAllKeywords()
+	if err != nil {
+		return
+	}
+
+	ruleActions, keywordActions, conflicts := plan(set, rules, keywords)
+	if conflict == ConflictFail && len(conflicts) > 0 {
+		err = &ConflictError{Conflicts: conflicts}
+		return
+	}
+
+	summary.Skipped = set.Skipped
+	for _, action := range ruleActions {
+		switch {
+		case action.existing == nil:
+			_, err = manager.InsertRule(models.RejectRule{Name: action.rule.Name, Rule: action.rule.Rule, Kind: action.rule.Kind})
+			if err != nil {
+				return
+			}
+			summary.RulesAdded++
+
+		case action.conflict && conflict == ConflictReplace:
+			updated := *action.existing
+			updated.Name = action.rule.Name
+			updated.Rule = action.rule.Rule
+			updated.Kind = action.rule.Kind
+
+			err = manager.UpdateRule(updated)
+			if err != nil {
+				return
+			}
+			summary.RulesUpdated++
+
+		default:
+			summary.RulesSkipped++
+		}
+	}
+
+	for _, action := range keywordActions {
+		switch {
+		case action.existing == nil:
+			_, err = manager.InsertKeyword(action.keyword.Value, keywordType(action.keyword))
+			if err != nil {
+				return
+			}
+			summary.KeywordsAdded++
+
+		case action.conflict && conflict == ConflictReplace:
+			updated := *action.existing
+			updated.Type = keywordType(action.keyword)
+
+			err = manager.UpdateKeyword(updated)
+			if err != nil {
+				return
+			}
+			summary.KeywordsUpdated++
+
+		default:
+			summary.KeywordsSkipped++
+		}
+	}
+
+	return
+}
+
+type ruleAction struct {
+	rule     Rule
+	existing *models.RejectRule
+	conflict bool
+}
+
+type keywordAction struct {
+	keyword  Keyword
+	existing *models.Keyword
+	conflict bool
+}
+
+//plan : match imported entries against existing ones
+//Rules are duplicates if they share either name or expression, keywords if they share value
+func plan(set RuleSet, rules []models.RejectRule, keywords []models.Keyword) (ruleActions []ruleAction, keywordActions []keywordAction, conflicts []string) {
+	for _, rule := range set.Rules {
+		action := ruleAction{rule: rule}
+		for i := range rules {
+			if isReserved(rules[i]) {
+				continue
+			}
+
+			if rules[i].Name == rule.Name || rules[i].Rule == rule.Rule {
+				action.existing = &rules[i]
+				action.conflict = rules[i].Name != rule.Name || rules[i].Rule != rule.Rule || portableKind(rules[i].Kind) != rule.Kind
+				break
+			}
+		}
+
+		if action.conflict {
+			conflicts = append(conflicts, fmt.Sprintf("rule %q differs from existing rule %q", rule.Name, action.existing.Name))
+		}
+		ruleActions = append(ruleActions, action)
+	}
+
+	for _, keyword := range set.Keywords {
+		action := keywordAction{keyword: keyword}
+		for i := range keywords {
+			if keywords[i].Value == keyword.Value {
+				action.existing = &keywords[i]
+				action.conflict = keywords[i].Type != keywordType(keyword)
+				break
+			}
+		}
+
+		if action.conflict {
+			conflicts = append(conflicts, fmt.Sprintf("keyword %q has different type", keyword.Value))
+		}
+		keywordActions = append(keywordActions, action)
+	}
+	return
+}
+
+//normalize : validate expressions & drop duplicates inside of the set
+func (set *RuleSet) normalize() (err error) {
+	set.Version = Version
+
+	rules := make([]Rule, 0, len(set.Rules))
+	seen := make(map[string]bool, len(set.Rules))
+	seenNames := make(map[string]bool, len(set.Rules))
+	for _, rule := range set.Rules {
+		if rule.Rule == "" {
+			set.Skipped = append(set.Skipped, fmt.Sprintf("rule %q: empty expression", rule.Name))
+			continue
+		}
+
+		rule.Kind = portableKind(rule.Kind)
+		compiled := models.RejectRule{Rule: rule.Rule, Kind: rule.Kind}
+		if err = compiled.Compile(); err != nil {
+			return fmt.Errorf("rule %q: %s", rule.Name, err.Error())
+		}
+
+		if seen[rule.Rule] {
+			continue
+		}
+		seen[rule.Rule] = true
+
+		//names of status rules are reserved
+		switch {
+		case rule.Name == "":
+			rule.Name = fmt.Sprintf("imported_%d", len(rules))
+		case seenNames[rule.Name] || models.IsPredefinedRule(rule.Name):
+			rule.Name = fmt.Sprintf("%s_imported_%d", rule.Name, len(rules))
+		}
+		seenNames[rule.Name] = true
+		rules = append(rules, rule)
+	}
+
+	keywords := make([]Keyword, 0, len(set.Keywords))
+	seenKeywords := make(map[string]int, len(set.Keywords))
+	for _, keyword := range set.Keywords {
+		if keyword.Value == "" {
+			continue
+		}
+
+		if id, ok := seenKeywords[keyword.Value]; ok {
+			keywords[id].Searchable = keywords[id].Searchable || keyword.Searchable
+			continue
+		}
+		seenKeywords[keyword.Value] = len(keywords)
+		keywords = append(keywords, keyword)
+	}
+
+	set.Rules = rules
+	set.Keywords = keywords
+	return
+}
+
+//isReserved : predefined rules (none, manual, ...) are statuses, not expressions
+func isReserved(rule models.RejectRule) bool {
+	return rule.Rule == ""
+}
+
+//portableKind : regexp is the default kind and is omitted
+func portableKind(kind string) string {
+	if kind == models.RULEKINDREGEXP {
+		return ""
+	}
+	return kind
+}
+
+func keywordType(keyword Keyword) int {
+	if keyword.Searchable {
+		return models.KWSEARCHABLE
+	}
+	return models.KWINNER
+}
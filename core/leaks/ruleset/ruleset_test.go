@@ -0,0 +1,259 @@
+package ruleset
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLoggers("test.log")
+
+	retCode := m.Run()
+	err := os.Remove("test.log")
+	if err != nil {
+		fmt.Println("Unable to remove test.log")
+		fmt.Println(err.Error())
+	}
+	os.Exit(retCode)
+}
+
+const gitleaksConfigSample = `
+title = "test config"
+
+[allowlist]
+description = "global allowlist"
+regexes = ['''password\s*=\s*"changeme"''']
+paths = ['''(.*?)(jpg|gif)$''']
+
+[[rules]]
+id = "generic-password"
+regex = '''(?i)password\s*=\s*"[^"]+"'''
+keywords = ["password", "passwd"]
+tags = ["megamon:searchable"]
+
+    [rules.allowlist]
+    stopwords = ["example"]
+
+[[rules]]
+id = "no-keywords"
+regex = '''[0-9a-f]{40}'''
+`
+
+func TestParseGitleaks(t *testing.T) {
+	set, err := Parse(FormatGitleaks, []byte(gitleaksConfigSample))
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if len(set.Rules) != 2 {
+		t.Errorf("Expected 2 rules, got: %d %v", len(set.Rules), set.Rules)
+		return
+	}
+
+	if set.Rules[1].Rule != "(?i)example" {
+		t.Errorf("Expected stopword rule (?i)example, got: %s", set.Rules[1].Rule)
+	}
+
+	if len(set.Keywords) != 2 || !set.Keywords[0].Searchable {
+		t.Errorf("Expected 2 searchable keywords, got: %v", set.Keywords)
+	}
+
+	if len(set.Skipped) != 2 {
+		t.Errorf("Expected path allowlist and rule without keywords to be skipped, got: %v", set.Skipped)
+	}
+	return
+}
+
+func TestGitleaksRoundTrip(t *testing.T) {
+	set := RuleSet{
+		Rules:    []Rule{{Name: "hash", Rule: "password_hash"}, {Name: "tests", Rule: `path endswith "_test.go"`, Kind: models.RULEKINDEXPR}},
+		Keywords: []Keyword{{Value: "token", Searchable: true}, {Value: "secret"}},
+	}
+
+	data, err := Marshal(FormatGitleaks, set)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	parsed, err := Parse(FormatGitleaks, data)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if len(parsed.Rules) != 1 || parsed.Rules[0].Rule != "password_hash" {
+		t.Errorf("Expected rule password_hash, got: %v", parsed.Rules)
+	}
+
+	if len(parsed.Keywords) != 2 || !parsed.Keywords[0].Searchable || parsed.Keywords[1].Searchable {
+		t.Errorf("Keywords were not preserved: %v", parsed.Keywords)
+	}
+	return
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	set := RuleSet{
+		Rules:    []Rule{{Name: "hash", Rule: "password_hash"}, {Name: "dup", Rule: "password_hash"}},
+		Keywords: []Keyword{{Value: "token", Searchable: true}},
+	}
+
+	data, err := Marshal(FormatYAML, set)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	parsed, err := Parse(FormatYAML, data)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if len(parsed.Rules) != 1 || parsed.Rules[0].Name != "hash" {
+		t.Errorf("Expected duplicate rule to be dropped, got: %v", parsed.Rules)
+	}
+
+	//renamed rules keep their name readable, status rule names are not taken
+	parsed, err = Parse(FormatYAML, []byte("rules:\n  - name: foo\n    rule: a\n  - name: foo\n    rule: b\n  - name: none\n    rule: c\n  - rule: d\n"))
+	if err != nil || len(parsed.Rules) != 4 || parsed.Rules[1].Name != "foo_imported_1" || parsed.Rules[2].Name != "none_imported_2" || parsed.Rules[3].Name != "imported_3" {
+		t.Errorf("Expected renamed rules, got: %v %v", parsed.Rules, err)
+	}
+
+	if _, err = Parse(FormatYAML, []byte("rules:\n  - name: broken\n    rule: \"(\"\n")); err == nil {
+		t.Errorf("Expected error for invalid expression")
+	}
+
+	parsed, err = Parse(FormatYAML, []byte("rules:\n  - name: tests\n    kind: expr\n    rule: path endswith \"_test.go\"\n"))
+	if err != nil || len(parsed.Rules) != 1 || parsed.Rules[0].Kind != models.RULEKINDEXPR {
+		t.Errorf("Expected expression rule, got: %v %v", parsed.Rules, err)
+	}
+
+	if _, err = Parse(FormatYAML, []byte("rules:\n  - name: broken\n    kind: expr\n    rule: path endswith\n")); err == nil {
+		t.Errorf("Expected error for invalid rule expression")
+	}
+	return
+}
+
+func TestPlanConflicts(t *testing.T) {
+	rules := []models.RejectRule{
+		{ID: 1, Name: "none"},
+		{ID: 5, Name: "hash", Rule: "password_hash"},
+		{ID: 6, Name: "example", Rule: "example"},
+	}
+	keywords := []models.Keyword{{ID: 1, Value: "token", Type: models.KWINNER}}
+
+	set := RuleSet{
+		Rules: []Rule{
+			{Name: "hash", Rule: "password_hash"},
+			{Name: "example", Rule: "(?i)example"},
+			{Name: "none", Rule: "new"},
+		},
+		Keywords: []Keyword{{Value: "token", Searchable: true}, {Value: "secret"}},
+	}
+
+	ruleActions, keywordActions, conflicts := plan(set, rules, keywords)
+
+	if ruleActions[0].existing == nil || ruleActions[0].conflict {
+		t.Errorf("Expected identical rule to be a plain duplicate")
+	}
+
+	if ruleActions[1].existing == nil || !ruleActions[1].conflict {
+		t.Errorf("Expected rule with the same name to conflict")
+	}
+
+	if ruleActions[2].existing != nil {
+		t.Errorf("Reserved rules must not be matched")
+	}
+
+	if !keywordActions[0].conflict || keywordActions[1].existing != nil {
+		t.Errorf("Wrong keyword actions: %v", keywordActions)
+	}
+
+	if len(conflicts) != 2 {
+		t.Errorf("Expected 2 conflicts, got: %v", conflicts)
+	}
+	return
+}
+
+func TestApplyRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megamon")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := models.OpenPool(utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.Close()
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	before, err := manager.SelectAllRules()
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	//second rule fails to compile after the first one is inserted
+	set := RuleSet{
+		Rules: []Rule{
+			{Name: "valid", Rule: "password_[0-9]+"},
+			{Name: "invalid", Rule: "password_("},
+		},
+		Keywords: []Keyword{{Value: "imported"}},
+	}
+
+	summary, err := Apply(&manager, set, ConflictSkip)
+	if err == nil {
+		t.Errorf("Expected invalid rule to fail the import")
+		return
+	}
+
+	if summary.RulesAdded != 0 {
+		t.Errorf("Expected empty summary, got: %v", summary)
+	}
+
+	after, err := manager.SelectAllRules()
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if len(after) != len(before) {
+		t.Errorf("Expected no rules imported, got: %v", after)
+	}
+
+	//manager keeps working after the rollback
+	set.Rules = set.Rules[:1]
+	summary, err = Apply(&manager, set, ConflictSkip)
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if summary.RulesAdded != 1 || summary.KeywordsAdded != 1 {
+		t.Errorf("Wrong summary: %v", summary)
+	}
+
+	//status rule is not duplicated by the imported one
+	_, err = Apply(&manager, RuleSet{Rules: []Rule{{Name: "manual", Rule: "password_manual"}}}, ConflictSkip)
+	if err == nil {
+		t.Errorf("Expected reserved rule name to be rejected")
+	}
+	return
+}
@@ -7,52 +7,57 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/megamon/core/leaks/expr"
 	"github.com/megamon/core/leaks/fragment"
 	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/leaks/taskrun"
 )
 
 //Fragmentize : calculate text fragments and process it
-func Fragmentize(ctx context.Context, stage Interface, nWorkers int) {
-	var wg sync.WaitGroup
-	textQueue := make(chan ReportText, MAXCHANCAP)
-	fragmentQueue := make(chan models.TextFragment, MAXCHANCAP)
-
-	logInfo("initializing text queue")
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		defer close(textQueue)
-
-		err := stage.GetTextsToProcess(textQueue)
-		if err != nil {
-			logErr(err)
-			return
-		}
-
-		return
-	}()
-
+//The error of producing the texts is returned, the error of the context once it is done
+func Fragmentize(ctx context.Context, stage Interface, opts Options) (err error) {
 	manager := stage.GetDBManager()
 	keywords, err := manager.SelectAllKeywords()
-
 	if err != nil {
 		logErr(err)
 		return
 	}
 
-	rules, err := manager.SelectAllRules()
+	//without keywords no fragmenter would read the texts
+	if len(keywords) == 0 {
+		return
+	}
 
+	rules, err := manager.SelectAllRules()
 	if err != nil {
 		logErr(err)
 		return
 	}
 
+	var wg sync.WaitGroup
+	textQueue := make(chan ReportText, MAXCHANCAP)
+	fragmentQueue := make(chan ReportFragments, MAXCHANCAP)
+
+	var textErr error
+	logInfo("initializing text queue")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(textQueue)
+
+		textErr = stage.GetTextsToProcess(ctx, textQueue)
+		if textErr != nil && ctx.Err() == nil {
+			logErr(textErr)
+		}
+		return
+	}()
+
 	logInfo("initializing fragmenter workers")
-	for i := 0; i < nWorkers; i++ {
+	for i := 0; i < opts.FragmentizeWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			fragmenter(ctx, textQueue, fragmentQueue, &keywords, &rules)
+			fragmenter(ctx, textQueue, fragmentQueue, &keywords, &rules, opts)
 			return
 		}()
 	}
@@ -63,11 +68,22 @@ func Fragmentize(ctx context.Context, stage Interface, nWorkers int) {
 	wgProcessor.Add(1)
 	go func() {
 		defer wgProcessor.Done()
-		for textFragment := range fragmentQueue {
-			err := stage.ProcessTextFragment(textFragment)
+		run := taskrun.FromContext(ctx)
+		for report := range fragmentQueue {
+			err := stage.ProcessTextFragments(report.ReportID, report.Fragments)
 			if err != nil {
 				logErr(err)
+				run.Log(models.LOGERROR, fmt.Sprintf("fragments of report %d: %s", report.ReportID, err.Error()))
+				continue
+			}
+
+			rejected := 0
+			for _, frag := range report.Fragments {
+				if frag.RejectID != models.RULENONE {
+					rejected++
+				}
 			}
+			run.AddFragments(len(report.Fragments), rejected)
 		}
 		return
 	}()
@@ -75,7 +91,11 @@ func Fragmentize(ctx context.Context, stage Interface, nWorkers int) {
 	wg.Wait()
 	close(fragmentQueue)
 	wgProcessor.Wait()
-	return
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return textErr
 }
 
 func buildTextFragment(reportText ReportText, context fragment.Fragment, keywords *[]fragment.Fragment, RejectID int) (textFragment models.TextFragment, err error) {
@@ -100,8 +120,10 @@ func buildTextFragment(reportText ReportText, context fragment.Fragment, keyword
 
 //checkKeywordFragment : checks if fragment with keyword matches the expression
 //If we throw the keyword from fragment & it still matches, then that is false positive
-func checkKeywordFragment(rules *[]models.RejectRule, frag, keyword fragment.Fragment, text string) (match bool, id int, err error) {
+//Expression rules are evaluated against the fragment and report metadata as is
+func checkKeywordFragment(rules *[]models.RejectRule, frag, keyword fragment.Fragment, reportText ReportText, keywords *[]models.Keyword) (match bool, id int, err error) {
 	var builder strings.Builder
+	text := reportText.Text
 	fragmentText, err := frag.Apply(text)
 
 	if err != nil {
@@ -117,13 +139,28 @@ func checkKeywordFragment(rules *[]models.RejectRule, frag, keyword fragment.Fra
 	builder.WriteString(text[keyword.Offset+keyword.Length : frag.Offset+frag.Length])
 	stripped := builder.String()
 
+	var env expr.Env
 	for _, rule := range *rules {
+		if rule.Cond != nil {
+			if env == nil {
+				env = ruleEnv(reportText, fragmentText, text[keyword.Offset:keyword.Offset+keyword.Length], keywords)
+			}
+
+			if rule.Cond.Eval(env) {
+				return true, rule.ID, err
+			}
+			continue
+		}
+
+		if rule.Expr == nil {
+			continue
+		}
 
 		if rule.Expr.Match([]byte(fragmentText)) {
 			if rule.Expr.Match([]byte(stripped)) {
 				continue
 			} else {
-				return true, id, err
+				return true, rule.ID, err
 			}
 		}
 	}
@@ -131,22 +168,44 @@ func checkKeywordFragment(rules *[]models.RejectRule, frag, keyword fragment.Fra
 	return false, -1, err
 }
 
-func filterKeywordContexts(ctx context.Context, reportText ReportText, keyword string, fragmentQueue chan models.TextFragment, rules *[]models.RejectRule) (keywords, contexts []fragment.Fragment) {
+//ruleEnv : variables for expression rules
+func ruleEnv(reportText ReportText, fragmentText, keyword string, keywords *[]models.Keyword) expr.Env {
+	hits := make([]string, 0, len(*keywords))
+	for _, kw := range *keywords {
+		if strings.Contains(fragmentText, kw.Value) {
+			hits = append(hits, kw.Value)
+		}
+	}
+
+	return expr.Env{
+		"text":     fragmentText,
+		"keyword":  keyword,
+		"keywords": hits,
+		"path":     reportText.Path,
+		"lang":     reportText.Lang,
+		"repo":     reportText.Repo,
+		"owner":    reportText.Owner,
+		"type":     reportText.Type,
+	}
+}
+
+//filterKeywordContexts : split keyword contexts into rejected fragments & contexts left for merging
+func filterKeywordContexts(reportText ReportText, keyword string, contextLen int, rules *[]models.RejectRule, keywords *[]models.Keyword) (checkedKeywords, contexts []fragment.Fragment, rejected []models.TextFragment) {
 	keywordFragments := fragment.GetKeywordFragments(reportText.Text, keyword)
 	checkedFragments := make([]fragment.Fragment, 0, len(keywordFragments))
 	kwContexts := make([]fragment.Fragment, 0, len(keywordFragments))
 
 	for _, keyword := range keywordFragments {
-		kwContext := fragment.GetKeywordContext(reportText.Text, CONTEXTLEN, keyword)
+		kwContext := fragment.GetKeywordContext(reportText.Text, contextLen, keyword)
 
-		match, id, err := checkKeywordFragment(rules, kwContext, keyword, reportText.Text)
+		match, id, err := checkKeywordFragment(rules, kwContext, keyword, reportText, keywords)
 		if err != nil {
 			logErr(err)
 			continue
 		}
 
 		if match {
-			fragmentKeywords := []fragment.Fragment{{Offset: keyword.Length, Length: keyword.Offset}}
+			fragmentKeywords := []fragment.Fragment{{Offset: keyword.Offset, Length: keyword.Length}}
 			textFragment, err := buildTextFragment(reportText, kwContext, &fragmentKeywords, id)
 
 			if err != nil {
@@ -154,22 +213,17 @@ func filterKeywordContexts(ctx context.Context, reportText ReportText, keyword s
 				continue
 			}
 
-			select {
-			case <-ctx.Done():
-				return
-
-			case fragmentQueue <- textFragment:
-			}
-
+			rejected = append(rejected, textFragment)
 		} else {
 			kwContexts = append(kwContexts, kwContext)
 			checkedFragments = append(checkedFragments, keyword)
 		}
 	}
-	return checkedFragments, kwContexts
+	return checkedFragments, kwContexts, rejected
 }
 
-func fragmenter(ctx context.Context, textQueue chan ReportText, fragmentQueue chan models.TextFragment, keywords *[]models.Keyword, rules *[]models.RejectRule) {
+//fragmenter : fragments of every report are sent at once, after the whole text is processed
+func fragmenter(ctx context.Context, textQueue chan ReportText, fragmentQueue chan ReportFragments, keywords *[]models.Keyword, rules *[]models.RejectRule, opts Options) {
 	if len(*keywords) == 0 {
 		return
 	}
@@ -177,14 +231,16 @@ func fragmenter(ctx context.Context, textQueue chan ReportText, fragmentQueue ch
 	for reportText := range textQueue {
 		var mergedContexts []fragment.Fragment
 		var mergedKeywords []fragment.Fragment
+		report := ReportFragments{ReportID: reportText.ReportID}
 
 		for _, keyword := range *keywords {
-			fragmentKeywords, fragmentContexts := filterKeywordContexts(ctx, reportText, keyword.Value, fragmentQueue, rules)
+			fragmentKeywords, fragmentContexts, rejected := filterKeywordContexts(reportText, keyword.Value, opts.ContextLen, rules, keywords)
+			report.Fragments = append(report.Fragments, rejected...)
 			mergedKeywords = fragment.Merge(&mergedKeywords, &fragmentKeywords)
 			mergedContexts = fragment.Merge(&mergedContexts, &fragmentContexts)
 		}
 
-		mergedContexts = fragment.Join(&mergedContexts, MAXCONTEXTLEN)
+		mergedContexts = fragment.Join(&mergedContexts, opts.MaxContextLen)
 		kwInFrags := fragment.GetKeywordsInFragments(mergedKeywords, mergedContexts)
 
 		for id := range kwInFrags {
@@ -203,12 +259,14 @@ func fragmenter(ctx context.Context, textQueue chan ReportText, fragmentQueue ch
 				continue
 			}
 
-			select {
-			case <-ctx.Done():
-				return
+			report.Fragments = append(report.Fragments, textFragment)
+		}
 
-			case fragmentQueue <- textFragment:
-			}
+		select {
+		case <-ctx.Done():
+			return
+
+		case fragmentQueue <- report:
 		}
 	}
 
@@ -0,0 +1,113 @@
+package stage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/megamon/core/utils"
+)
+
+//Defaults of stage options, used for zero settings
+const (
+	DefaultRequestWorkers     = 1
+	DefaultProcessWorkers     = 1
+	DefaultFragmentizeWorkers = 2
+
+	//MAXWORKERS : max number of workers of each kind
+	MAXWORKERS = 256
+)
+
+//Names : stages configured in settings (globals.stages)
+var Names = []string{"search", "fetch", "gist"}
+
+//Options : concurrency & limits of the stage with defaults applied
+type Options struct {
+	RequestWorkers     int
+	ProcessWorkers     int
+	FragmentizeWorkers int
+	ContextLen         int
+	MaxContextLen      int
+	MaxRetries         int
+	Backoff            time.Duration
+
+	//Clock : time source of the retry policy, SystemClock if nil
+	Clock Clock
+}
+
+func orDefault(value, def int) int {
+	if value <= 0 {
+		return def
+	}
+	return value
+}
+
+//NewOptions : options from settings
+func NewOptions(settings utils.StageSettings) Options {
+	return Options{
+		RequestWorkers:     orDefault(settings.RequestWorkers, DefaultRequestWorkers),
+		ProcessWorkers:     orDefault(settings.ProcessWorkers, DefaultProcessWorkers),
+		FragmentizeWorkers: orDefault(settings.FragmentizeWorkers, DefaultFragmentizeWorkers),
+		ContextLen:         orDefault(settings.ContextLen, CONTEXTLEN),
+		MaxContextLen:      orDefault(settings.MaxContextLen, MAXCONTEXTLEN),
+		MaxRetries:         orDefault(settings.MaxRetries, MAXRETRIES),
+		Backoff:            time.Duration(orDefault(settings.Backoff, TIMEWAIT)) * time.Second,
+	}
+}
+
+//OptionsOf : options of the stage from current settings, read when the stage starts
+func OptionsOf(name string) Options {
+	return NewOptions(utils.Settings.LeakGlobals.Stages[name])
+}
+
+//ValidateSettings : check stage settings before they are saved, zero values mean defaults
+func ValidateSettings(stages map[string]utils.StageSettings) (err error) {
+	for name, settings := range stages {
+		known := false
+		for _, stage := range Names {
+			known = known || stage == name
+		}
+
+		if !known {
+			return fmt.Errorf("stage %s: unknown stage, expected one of %v", name, Names)
+		}
+
+		values := map[string]int{
+			"request_workers":     settings.RequestWorkers,
+			"process_workers":     settings.ProcessWorkers,
+			"fragmentize_workers": settings.FragmentizeWorkers,
+			"context_len":         settings.ContextLen,
+			"max_context_len":     settings.MaxContextLen,
+			"max_retries":         settings.MaxRetries,
+			"backoff":             settings.Backoff,
+		}
+
+		for field, value := range values {
+			if value < 0 {
+				return fmt.Errorf("stage %s: %s must not be negative", name, field)
+			}
+		}
+
+		opts := NewOptions(settings)
+		if opts.RequestWorkers > MAXWORKERS || opts.ProcessWorkers > MAXWORKERS || opts.FragmentizeWorkers > MAXWORKERS {
+			return fmt.Errorf("stage %s: at most %d workers of each kind are allowed", name, MAXWORKERS)
+		}
+
+		if opts.ContextLen > opts.MaxContextLen {
+			return fmt.Errorf("stage %s: context_len %d exceeds max_context_len %d", name, opts.ContextLen, opts.MaxContextLen)
+		}
+	}
+	return
+}
+
+//Settings : options in units of settings, effective values are shown as defaults in the settings UI
+func (opts Options) Settings() utils.StageSettings {
+	return utils.StageSettings{
+		RequestWorkers:     opts.RequestWorkers,
+		ProcessWorkers:     opts.ProcessWorkers,
+		FragmentizeWorkers: opts.FragmentizeWorkers,
+		ContextLen:         opts.ContextLen,
+		MaxContextLen:      opts.MaxContextLen,
+		MaxRetries:         opts.MaxRetries,
+		Backoff:            int(opts.Backoff / time.Second),
+	}
+}
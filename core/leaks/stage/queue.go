@@ -0,0 +1,183 @@
+package stage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/megamon/core/leaks/models"
+)
+
+//Checkpointer : stage whose requests are persisted, interrupted run is resumed from the queue after restart
+type Checkpointer interface {
+	//Name : runs of stages with the same name resume each other
+	Name() string
+
+	//RestoreRequest : rebuild request from Params saved when it was built
+	RestoreRequest(requestID int, params []byte) (req Request, err error)
+}
+
+//Checkpoint : persisted queue of the current run, nil checkpoint keeps nothing
+//Requests are identified by URL, so they must not contain credentials
+type Checkpoint struct {
+	manager models.Storage
+	run     models.QueueRun
+
+	mu   sync.Mutex
+	urls map[int]string
+}
+
+//OpenCheckpoint : resume the active run of the stage or start a new one
+func OpenCheckpoint(manager models.Storage, name string) (cp *Checkpoint, err error) {
+	run, resumed, err := manager.OpenQueueRun(name)
+	if err != nil {
+		return
+	}
+
+	if resumed {
+		logInfo(fmt.Sprintf("resuming run %d of %s", run.ID, name))
+	}
+
+	cp = &Checkpoint{manager: manager, run: run, urls: make(map[int]string)}
+	return
+}
+
+//Fill : queue pending requests of the run, requests are built only if the run didn't build all of them
+//Requests done or failed in the interrupted run are not sent again
+//Request that can't be stored is sent untracked, the run is left unbuilt then & built again by the next start
+func (cp *Checkpoint) Fill(ctx context.Context, stage MiddlewareInterface, reqQueue chan Request) (err error) {
+	if cp.run.Built {
+		return cp.restore(ctx, stage.(Checkpointer), reqQueue)
+	}
+
+	built := make(chan Request)
+	buildErr := make(chan error, 1)
+	go func() {
+		defer close(built)
+		buildErr <- stage.BuildRequests(ctx, built)
+	}()
+
+	var storeErr error
+	for req := range built {
+		pending, err := cp.store(req)
+		if err != nil {
+			logErr(err)
+			if storeErr == nil {
+				storeErr = fmt.Errorf("request %d is not stored in run %d: %s", req.ID, cp.run.ID, err.Error())
+			}
+		}
+
+		if err == nil && !pending {
+			continue
+		}
+
+		if err := Enqueue(ctx, reqQueue, req); err != nil {
+			//builder returns once it sees the context is done
+			for range built {
+			}
+			return err
+		}
+	}
+
+	err = <-buildErr
+	if err != nil {
+		return
+	}
+
+	if storeErr != nil {
+		return storeErr
+	}
+
+	cp.run.Built = true
+	return cp.manager.SetQueueRunBuilt(cp.run.ID)
+}
+
+//store : persist the built request, false if it was done or failed by the interrupted run
+func (cp *Checkpoint) store(req Request) (pending bool, err error) {
+	params, err := json.Marshal(req.Params)
+	if err != nil {
+		return
+	}
+
+	URL := req.Req.URL.String()
+	status, err := cp.manager.EnqueueRequest(cp.run.ID, URL, params)
+	if err != nil || status != models.REQUESTPENDING {
+		return
+	}
+
+	cp.track(req.ID, URL)
+	return true, nil
+}
+
+func (cp *Checkpoint) restore(ctx context.Context, stage Checkpointer, reqQueue chan Request) (err error) {
+	pending, err := cp.manager.SelectQueuedRequests(cp.run.ID, models.REQUESTPENDING)
+	if err != nil {
+		return
+	}
+
+	logInfo(fmt.Sprintf("restoring %d pending requests of run %d", len(pending), cp.run.ID))
+	for id, queued := range pending {
+		req, err := stage.RestoreRequest(id, queued.Params)
+		if err != nil {
+			logErr(err)
+			cp.update(queued.URL, models.REQUESTFAILED, err.Error())
+			continue
+		}
+
+		cp.track(req.ID, queued.URL)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case reqQueue <- req:
+		}
+	}
+	return
+}
+
+//track : URL the request is stored with, restored request is updated by the stored URL even if the stage builds it differently
+func (cp *Checkpoint) track(requestID int, URL string) {
+	cp.mu.Lock()
+	cp.urls[requestID] = URL
+	cp.mu.Unlock()
+}
+
+func (cp *Checkpoint) update(URL, status, reason string) {
+	err := cp.manager.UpdateQueuedRequest(cp.run.ID, URL, status, reason)
+	if err != nil {
+		logErr(err)
+	}
+}
+
+func (cp *Checkpoint) set(requestID int, status string, reason string) {
+	if cp == nil {
+		return
+	}
+
+	cp.mu.Lock()
+	URL, ok := cp.urls[requestID]
+	delete(cp.urls, requestID)
+	cp.mu.Unlock()
+
+	if ok {
+		cp.update(URL, status, reason)
+	}
+}
+
+//Done : response of the request was processed
+func (cp *Checkpoint) Done(requestID int) {
+	cp.set(requestID, models.REQUESTDONE, "")
+}
+
+//Fail : request is given up, it is not repeated when the run is resumed
+func (cp *Checkpoint) Fail(requestID int, reason string) {
+	cp.set(requestID, models.REQUESTFAILED, reason)
+}
+
+//Finish : close the run if every request was built, otherwise it is resumed next time
+func (cp *Checkpoint) Finish() (err error) {
+	if cp == nil || !cp.run.Built {
+		return
+	}
+	return cp.manager.FinishQueueRun(cp.run.ID)
+}
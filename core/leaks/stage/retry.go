@@ -0,0 +1,188 @@
+package stage
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	//MAXBACKOFF : longest exponential backoff, waits required by rate limits may be longer
+	MAXBACKOFF = 5 * time.Minute
+
+	//SECONDARYLIMITWAIT : least wait after secondary rate limit without retry headers
+	SECONDARYLIMITWAIT = time.Minute
+)
+
+//Clock : time source of the retry policy, tests replace it with a fake one
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+//SystemClock : real time, used if options have no clock
+var SystemClock Clock = systemClock{}
+
+//Retry : decision of the retry policy about the failed request
+type Retry struct {
+	Repeat bool
+	Delay  time.Duration
+	Reason string
+
+	//Quota : the delay waits for the quota of the credential the attempt was signed with
+	Quota bool
+}
+
+//RetryPolicy : whether & after what delay the failed request is repeated
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Clock      Clock
+}
+
+//NewRetryPolicy : policy of the stage options
+func NewRetryPolicy(opts Options) RetryPolicy {
+	policy := RetryPolicy{MaxRetries: opts.MaxRetries, Backoff: opts.Backoff, MaxBackoff: MAXBACKOFF, Clock: opts.Clock}
+	if policy.Clock == nil {
+		policy.Clock = SystemClock
+	}
+
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = policy.Backoff
+	}
+	return policy
+}
+
+//Decide : retry of the request failed attempt times, either the response or the error of the last attempt is set
+//404 & 451 are skipped at once, rate limits wait for Retry-After or x-ratelimit-reset, 5xx & timeouts back off exponentially
+//401 is repeated at once, the Authorizer stage signs the next attempt with another credential
+func (p RetryPolicy) Decide(resp *http.Response, err error, attempt int) (retry Retry) {
+	if err != nil {
+		netErr, ok := err.(net.Error)
+		if !ok || !netErr.Timeout() {
+			return Retry{Reason: err.Error()}
+		}
+		retry = Retry{Repeat: true, Delay: p.backoff(attempt), Reason: err.Error()}
+
+	} else {
+		switch code := resp.StatusCode; {
+		case code == http.StatusNotFound || code == http.StatusUnavailableForLegalReasons:
+			return Retry{Reason: resp.Status}
+
+		case code == http.StatusUnauthorized:
+			retry = Retry{Repeat: true, Reason: resp.Status}
+
+		case code == http.StatusTooManyRequests:
+			retry = Retry{Repeat: true, Delay: p.rateLimitWait(resp.Header), Reason: "rate limited"}
+			retry.Quota = retry.Delay > 0
+			if retry.Delay == 0 {
+				retry.Delay = p.backoff(attempt)
+			}
+
+		//primary limit has no quota left, secondary one may come without headers
+		case code == http.StatusForbidden:
+			retry = Retry{Repeat: true, Delay: p.rateLimitWait(resp.Header), Reason: "rate limited"}
+			retry.Quota = retry.Delay > 0
+			if retry.Delay == 0 {
+				retry.Delay, retry.Reason = p.backoff(attempt), "secondary rate limit"
+				if retry.Delay < SECONDARYLIMITWAIT {
+					retry.Delay = SECONDARYLIMITWAIT
+				}
+			}
+
+		case code >= 500:
+			retry = Retry{Repeat: true, Delay: p.backoff(attempt), Reason: resp.Status}
+
+		default:
+			return Retry{Reason: resp.Status}
+		}
+	}
+
+	if attempt >= p.MaxRetries {
+		return Retry{Reason: fmt.Sprintf("given up after %d attempts, %s", attempt, retry.Reason)}
+	}
+	return
+}
+
+//Reauthorized : retry of the request signed again before the next attempt
+//Wait for the quota of the credential is shortened to the first backoff, the Authorizer waits if no other credential has quota left
+func (p RetryPolicy) Reauthorized(retry Retry) Retry {
+	if retry.Quota {
+		retry.Delay = p.backoff(1)
+	}
+	return retry
+}
+
+//backoff : exponential delay after the attempt, upper half of it is random
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if delay < 2 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+//rateLimitWait : wait required by Retry-After or exhausted x-ratelimit-remaining, 0 if the headers require none
+//Retry-After is seconds or HTTP date, a second is added to x-ratelimit-reset for clock skew
+func (p RetryPolicy) rateLimitWait(header http.Header) time.Duration {
+	now := p.Clock.Now()
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return atLeastSecond(time.Duration(seconds) * time.Second)
+		}
+
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return atLeastSecond(date.Sub(now))
+		}
+	}
+
+	if header.Get("x-ratelimit-remaining") != "0" {
+		return 0
+	}
+
+	reset, err := strconv.ParseInt(header.Get("x-ratelimit-reset"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return atLeastSecond(time.Unix(reset, 0).Sub(now) + time.Second)
+}
+
+func atLeastSecond(d time.Duration) time.Duration {
+	if d < time.Second {
+		return time.Second
+	}
+	return d
+}
+
+//Sleep : wait for the delay, false if the context is done first
+func (p RetryPolicy) Sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-p.Clock.After(d):
+		return true
+	}
+}
@@ -4,82 +4,137 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"net"
-	"net/http"
-	"strconv"
 	"sync"
-	"time"
 
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/leaks/taskrun"
 	"github.com/megamon/core/utils"
 )
 
-//DoRequests : common part of leak search
-func DoRequests(ctx context.Context, stage MiddlewareInterface, reqQueue chan Request, rl RateLimiter, responses chan Response) {
-	reqCount := make(map[int]int)
+//Enqueue : send request to the queue, the error of the context is returned once it is done
+func Enqueue(ctx context.Context, reqQueue chan Request, req Request) (err error) {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case reqQueue <- req:
+	}
+	return
+}
+
+//EnqueueText : send report text to the queue, the error of the context is returned once it is done
+func EnqueueText(ctx context.Context, textQueue chan ReportText, text ReportText) (err error) {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case textQueue <- text:
+	}
+	return
+}
+
+//DoRequests : common part of leak search, requests given up are marked failed in the checkpoint
+//Requests the Skipper stage doesn't need anymore are not sent, sent & repeated requests are counted in the task run
+//Responses the stage doesn't accept are repeated or given up as the retry policy of the options decides
+//Requests of the Authorizer stage are signed before every attempt, it waits for the quota instead of the retry policy
+//Requests left in the queue of the cancelled run are not sent, they stay pending in the checkpoint
+func DoRequests(ctx context.Context, stage MiddlewareInterface, reqQueue chan Request, rl RateLimiter, responses chan Response, cp *Checkpoint, opts Options) {
+	run := taskrun.FromContext(ctx)
+	policy := NewRetryPolicy(opts)
+	skipper, _ := stage.(Skipper)
+	authorizer, _ := stage.(Authorizer)
 	for r := range reqQueue {
+		if ctx.Err() != nil {
+			return
+		}
 
-	DOREQUEST:
-		for {
-			logInfo(fmt.Sprintf("request to %s; count: %d; id: %d", r.Req.URL.String(), reqCount[r.ID], r.ID))
+		if skipper != nil && skipper.SkipRequest(r) {
+			logInfo("skipping " + r.Req.URL.String() + ": no new results expected")
+			cp.Done(r.ID)
+			continue
+		}
 
-			httpResp, rErr := utils.DoRequest(r.Req)
-			reqCount[r.ID]++
-
-			if rErr != nil {
-				//If timeout, check for request count
-				if err, ok := rErr.(net.Error); ok && err.Timeout() {
-					reqCount[r.ID]++
-					if reqCount[r.ID] > MAXRETRIES {
-						break DOREQUEST
-					}
+	DOREQUEST:
+		for attempt := 1; ; attempt++ {
+			logInfo(fmt.Sprintf("request to %s; attempt: %d; id: %d", r.Req.URL.String(), attempt, r.ID))
+
+			var credential string
+			if authorizer != nil {
+				var err error
+				credential, err = authorizer.Authorize(ctx, r.Req)
+				if ctx.Err() != nil {
+					return
+				}
 
+				if err != nil {
 					logErr(err)
-					_ = rl.Wait(ctx, &http.Response{})
-					continue
-
-				} else {
-					logErr(rErr)
+					run.Log(models.LOGERROR, r.Req.URL.String()+": "+err.Error())
+					cp.Fail(r.ID, err.Error())
 					break DOREQUEST
 				}
 			}
 
-			_ = rl.Wait(ctx, httpResp)
-			resp := Response{r.ID, httpResp}
-			check := stage.CheckResponse(resp, reqCount[r.ID])
+			httpResp, rErr := utils.DoRequest(r.Req)
+			run.AddRequests(1)
+			if authorizer != nil {
+				authorizer.Observe(credential, httpResp, rErr)
+			}
 
-			switch check {
-			case OK:
-				responses <- resp
-				break DOREQUEST
-			case WAIT:
-				<-time.After(TIMEWAIT * time.Second)
-			case SKIP:
-				logInfo("skipping " + r.Req.URL.String() + " after " + strconv.Itoa(reqCount[r.ID]) + " attempts")
-				break DOREQUEST
-			default:
-				if reqCount[r.ID] > MAXRETRIES {
+			if rErr == nil {
+				_ = rl.Wait(ctx, httpResp)
+				resp := Response{RequesID: r.ID, Resp: httpResp, Params: r.Params}
+
+				switch stage.CheckResponse(resp, attempt) {
+				case OK:
+					//processors return once the context is done, nobody may read the response
+					select {
+					case <-ctx.Done():
+						httpResp.Body.Close()
+						return
+					case responses <- resp:
+					}
+					break DOREQUEST
+				case SKIP:
+					httpResp.Body.Close()
+					logInfo("skipping " + r.Req.URL.String() + ": " + httpResp.Status)
+					run.Log(models.LOGERROR, r.Req.URL.String()+": skipped, "+httpResp.Status)
+					cp.Fail(r.ID, httpResp.Status)
 					break DOREQUEST
 				}
-				<-time.After(TIMEWAIT * time.Second)
+				httpResp.Body.Close()
+			}
+
+			retry := policy.Decide(httpResp, rErr, attempt)
+			if authorizer != nil {
+				retry = policy.Reauthorized(retry)
+			}
+
+			if !retry.Repeat {
+				logInfo("giving up " + r.Req.URL.String() + ": " + retry.Reason)
+				run.Log(models.LOGERROR, r.Req.URL.String()+": "+retry.Reason)
+				cp.Fail(r.ID, retry.Reason)
+				break DOREQUEST
 			}
 
-			select {
-			case <-ctx.Done():
+			logInfo(fmt.Sprintf("repeating %s in %s: %s", r.Req.URL.String(), retry.Delay, retry.Reason))
+			run.AddRetries(1)
+
+			//cancelled run keeps the request pending
+			if !policy.Sleep(ctx, retry.Delay) {
 				return
-			default:
 			}
 		}
 	}
 }
 
-//ProcessResponses : common part of leak search
-func ProcessResponses(ctx context.Context, stage MiddlewareInterface, respQueue chan Response) {
+//ProcessResponses : common part of leak search, processed requests are marked done in the checkpoint
+func ProcessResponses(ctx context.Context, stage MiddlewareInterface, respQueue chan Response, cp *Checkpoint) {
+	run := taskrun.FromContext(ctx)
 	for resp := range respQueue {
 		logInfo(fmt.Sprintf("processing response from request: %d", resp.RequesID))
 
 		bodyReader, err := utils.GetBodyReader(resp.Resp)
 		if err != nil {
 			logErr(err)
+			cp.Fail(resp.RequesID, err.Error())
 			continue
 		}
 
@@ -88,12 +143,17 @@ func ProcessResponses(ctx context.Context, stage MiddlewareInterface, respQueue
 
 		if err != nil {
 			logErr(err)
+			cp.Fail(resp.RequesID, err.Error())
 			continue
 		}
 
-		err = stage.ProcessResponse(body, resp.RequesID)
+		err = stage.ProcessResponse(body, resp.RequesID, resp.Params)
 		if err != nil {
 			logErr(err)
+			run.Log(models.LOGERROR, fmt.Sprintf("response of request %d: %s", resp.RequesID, err.Error()))
+			cp.Fail(resp.RequesID, err.Error())
+		} else {
+			cp.Done(resp.RequesID)
 		}
 
 		select {
@@ -105,36 +165,57 @@ func ProcessResponses(ctx context.Context, stage MiddlewareInterface, respQueue
 }
 
 //RunMiddlewareStage : Middleware processing function
-func RunMiddlewareStage(ctx context.Context, stage MiddlewareInterface, limiter RateLimiter, nRequestWorkers, nProcessWorkers int) (err error) {
+//Stages implementing Checkpointer persist their requests & continue the interrupted run
+//The error of building the requests is returned after the built ones are processed, the error of the context once it is done
+func RunMiddlewareStage(ctx context.Context, stage MiddlewareInterface, limiter RateLimiter, opts Options) (err error) {
+	var cp *Checkpoint
+	if checkpointer, ok := stage.(Checkpointer); ok {
+		cp, err = OpenCheckpoint(stage.GetDBManager(), checkpointer.Name())
+		if err != nil {
+			return
+		}
+	}
+
 	logInfo("building request queue")
 	reqQueue := make(chan Request, MAXCHANCAP)
 	respQueue := make(chan Response, MAXCHANCAP)
 
+	var buildErr error
 	var wgRequests sync.WaitGroup
 	wgRequests.Add(1)
 	go func() {
 		defer close(reqQueue)
 		defer wgRequests.Done()
-		_ = stage.BuildRequests(reqQueue)
+
+		if cp == nil {
+			buildErr = stage.BuildRequests(ctx, reqQueue)
+		} else {
+			buildErr = cp.Fill(ctx, stage, reqQueue)
+		}
+
+		if buildErr != nil && ctx.Err() == nil {
+			logErr(buildErr)
+			taskrun.FromContext(ctx).Log(models.LOGERROR, "building requests: "+buildErr.Error())
+		}
 		return
 	}()
 
 	logInfo("initializing stage request workers")
-	for i := 0; i < nRequestWorkers; i++ {
+	for i := 0; i < opts.RequestWorkers; i++ {
 		wgRequests.Add(1)
 		go func() {
 			defer wgRequests.Done()
-			DoRequests(ctx, stage, reqQueue, limiter, respQueue)
+			DoRequests(ctx, stage, reqQueue, limiter, respQueue, cp, opts)
 		}()
 	}
 
 	var wg sync.WaitGroup
 	logInfo("initializing stage processing workers")
-	for i := 0; i < nProcessWorkers; i++ {
+	for i := 0; i < opts.ProcessWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			ProcessResponses(ctx, stage, respQueue)
+			ProcessResponses(ctx, stage, respQueue, cp)
 		}()
 	}
 
@@ -142,15 +223,31 @@ func RunMiddlewareStage(ctx context.Context, stage MiddlewareInterface, limiter
 	close(respQueue)
 	wg.Wait()
 
-	return
+	//cancelled run keeps pending requests for the next start
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = cp.Finish()
+	if err != nil {
+		return
+	}
+	return buildErr
 }
 
-//RunStage : Main processing function
-func RunStage(ctx context.Context, stage Interface, limiter RateLimiter, nRequestWorkers, nProcessWorkers, nFragmentizeWorkers int) (err error) {
+//RunStage : Main processing function, the first error of the requests or the fragmenter is returned
+//Reports fetched before the requests failed are fragmentized anyway, cancelled run returns at once
+func RunStage(ctx context.Context, stage Interface, limiter RateLimiter, opts Options) (err error) {
 	middleware := stage.(MiddlewareInterface)
-	RunMiddlewareStage(ctx, middleware, limiter, nRequestWorkers, nProcessWorkers)
+	err = RunMiddlewareStage(ctx, middleware, limiter, opts)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
 	logInfo("fragmentizing reports")
-	Fragmentize(ctx, stage, nFragmentizeWorkers)
+	fragErr := Fragmentize(ctx, stage, opts)
+	if err == nil {
+		err = fragErr
+	}
 	return
 }
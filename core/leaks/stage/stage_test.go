@@ -2,9 +2,20 @@ package stage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/megamon/core/leaks/models"
 	"github.com/megamon/core/utils"
@@ -45,17 +56,17 @@ func TestFragmener(t *testing.T) {
 	textQueue <- ReportText{ReportID: 1, Text: text}
 	close(textQueue)
 
-	fragmentQueue := make(chan models.TextFragment, 10)
+	fragmentQueue := make(chan ReportFragments, 10)
 	keywords := []models.Keyword{{Value: "test"}}
 	rules := []models.RejectRule{}
 
-	fragmenter(ctx, textQueue, fragmentQueue, &keywords, &rules)
+	fragmenter(ctx, textQueue, fragmentQueue, &keywords, &rules, NewOptions(utils.StageSettings{}))
 	close(fragmentQueue)
 
 	result := make([]models.TextFragment, 0, 10)
 
-	for frag := range fragmentQueue {
-		result = append(result, frag)
+	for report := range fragmentQueue {
+		result = append(result, report.Fragments...)
 	}
 
 	if len(result) != 2 {
@@ -79,3 +90,874 @@ func TestFragmener(t *testing.T) {
 
 	return
 }
+
+func TestFragmenterExpressionRule(t *testing.T) {
+	text := "db.password = load_password() // test password of the fixture"
+
+	ctx := context.Background()
+	textQueue := make(chan ReportText, 10)
+	textQueue <- ReportText{ReportID: 1, Text: text, Path: "db/db_test.go"}
+	textQueue <- ReportText{ReportID: 2, Text: text, Path: "db/db.go"}
+	close(textQueue)
+
+	rule := models.RejectRule{ID: 7, Kind: models.RULEKINDEXPR, Rule: `path endswith "_test.go" and keyword == "password" and entropy(text) < 4`}
+	if err := rule.Compile(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	fragmentQueue := make(chan ReportFragments, 10)
+	keywords := []models.Keyword{{Value: "password"}}
+	rules := []models.RejectRule{rule}
+
+	fragmenter(ctx, textQueue, fragmentQueue, &keywords, &rules, NewOptions(utils.StageSettings{}))
+	close(fragmentQueue)
+
+	rejected := make(map[int]int)
+	accepted := make(map[int]int)
+	for report := range fragmentQueue {
+		for _, frag := range report.Fragments {
+			if frag.RejectID == rule.ID {
+				rejected[frag.ReportID]++
+			} else {
+				accepted[frag.ReportID]++
+			}
+		}
+	}
+
+	if rejected[1] == 0 || accepted[1] != 0 {
+		t.Errorf("Expected all fragments of the test file to be rejected: rejected %d, accepted %d", rejected[1], accepted[1])
+	}
+
+	if rejected[2] != 0 || accepted[2] == 0 {
+		t.Errorf("Expected fragments of the regular file to be accepted: rejected %d, accepted %d", rejected[2], accepted[2])
+	}
+	return
+}
+
+func TestFragmenterRegexpRule(t *testing.T) {
+	text := "config: example_password = 1"
+
+	ctx := context.Background()
+	textQueue := make(chan ReportText, 10)
+	textQueue <- ReportText{ReportID: 1, Text: text}
+	close(textQueue)
+
+	//the rule matches the context only with the keyword in it
+	rule := models.RejectRule{ID: 5, Rule: `example_password`}
+	if err := rule.Compile(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	fragmentQueue := make(chan ReportFragments, 10)
+	keywords := []models.Keyword{{Value: "password"}}
+	rules := []models.RejectRule{rule}
+
+	fragmenter(ctx, textQueue, fragmentQueue, &keywords, &rules, NewOptions(utils.StageSettings{}))
+	close(fragmentQueue)
+
+	var result []models.TextFragment
+	for report := range fragmentQueue {
+		result = append(result, report.Fragments...)
+	}
+
+	//rejected fragment refers to its rule & keeps offset, length of the keyword in the fragment
+	offset := strings.Index(text, "password")
+	if len(result) != 1 || result[0].RejectID != rule.ID || result[0].Text != text {
+		t.Errorf("Expected one fragment rejected by rule %d, got: %+v", rule.ID, result)
+		return
+	}
+
+	if len(result[0].Keywords) != 1 || result[0].Keywords[0][0] != offset || result[0].Keywords[0][1] != len("password") {
+		t.Errorf("Expected keyword at [%d %d], got: %v", offset, len("password"), result[0].Keywords)
+	}
+	return
+}
+
+func TestFragmenterBatchesReport(t *testing.T) {
+	ctx := context.Background()
+	textQueue := make(chan ReportText, 10)
+	textQueue <- ReportText{ReportID: 1, Text: "password = 1; nothing else"}
+	textQueue <- ReportText{ReportID: 2, Text: "no keywords in this text"}
+	close(textQueue)
+
+	fragmentQueue := make(chan ReportFragments, 10)
+	keywords := []models.Keyword{{Value: "password"}}
+	rules := []models.RejectRule{}
+
+	fragmenter(ctx, textQueue, fragmentQueue, &keywords, &rules, NewOptions(utils.StageSettings{}))
+	close(fragmentQueue)
+
+	reports := make(map[int]int)
+	for report := range fragmentQueue {
+		reports[report.ReportID] = len(report.Fragments)
+	}
+
+	//report without fragments is sent too, so its status is changed
+	if len(reports) != 2 || reports[1] != 1 || reports[2] != 0 {
+		t.Errorf("Expected one batch per report with 1 & 0 fragments, got: %v", reports)
+	}
+	return
+}
+
+//textStage : queues texts more than both queues of the fragmenter hold, processing of the first report cancels the run
+type textStage struct {
+	queueStage
+	texts  int
+	sent   int32
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (s *textStage) GetTextsToProcess(ctx context.Context, textQueue chan ReportText) (err error) {
+	for id := 0; id < s.texts; id++ {
+		err = EnqueueText(ctx, textQueue, ReportText{ReportID: id, Text: "password = 1"})
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&s.sent, 1)
+	}
+	return
+}
+
+func (s *textStage) ProcessTextFragments(reportID int, fragments []models.TextFragment) (err error) {
+	//the run is cancelled once the text & fragment queues are full
+	s.once.Do(func() {
+		for atomic.LoadInt32(&s.sent) < 2*MAXCHANCAP {
+			time.Sleep(time.Millisecond)
+		}
+		s.cancel()
+	})
+	return
+}
+
+func TestFragmentizeCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megamon")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := models.OpenPool(utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.Close()
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if _, err = manager.InsertKeyword("password", models.KWINNER); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stage := &textStage{queueStage: queueStage{manager: &manager}, texts: 3 * MAXCHANCAP, cancel: cancel}
+
+	done := make(chan error)
+	go func() {
+		done <- Fragmentize(ctx, stage, NewOptions(utils.StageSettings{}))
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(30 * time.Second):
+		t.Errorf("Expected Fragmentize to return after cancel with %d pending texts", stage.texts-2*MAXCHANCAP)
+		return
+	}
+
+	if err != context.Canceled {
+		t.Errorf("Expected Fragmentize to be canceled, got: %v", err)
+	}
+
+	if sent := atomic.LoadInt32(&stage.sent); int(sent) >= stage.texts {
+		t.Errorf("Expected texts not to be queued after cancel, got %d of %d", sent, stage.texts)
+	}
+	return
+}
+
+//queueStage : builds requests for pages, pages listed in failing are not built
+type queueStage struct {
+	manager models.Storage
+	pages   int
+	failing map[int]bool
+}
+
+func (s *queueStage) Init(manager models.Storage) (err error) {
+	return
+}
+
+func (s *queueStage) GetDBManager() models.Storage {
+	return s.manager
+}
+
+func (s *queueStage) Name() string {
+	return "queue"
+}
+
+func (s *queueStage) CheckResponse(resp Response, reqCount int) (res int) {
+	return OK
+}
+
+func (s *queueStage) ProcessResponse(resp []byte, requestID int, params interface{}) (err error) {
+	return
+}
+
+func (s *queueStage) request(requestID, page int) (req Request, err error) {
+	httpReq, err := http.NewRequest("GET", fmt.Sprintf("http://localhost/search?page=%d", page), nil)
+	return Request{ID: requestID, Req: httpReq, Params: page}, err
+}
+
+func (s *queueStage) BuildRequests(ctx context.Context, reqQueue chan Request) (err error) {
+	for page := 0; page < s.pages; page++ {
+		if s.failing[page] {
+			return fmt.Errorf("page %d can't be built", page)
+		}
+
+		req, err := s.request(page, page)
+		if err != nil {
+			return err
+		}
+		if err = Enqueue(ctx, reqQueue, req); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func (s *queueStage) RestoreRequest(requestID int, params []byte) (req Request, err error) {
+	var page int
+	err = json.Unmarshal(params, &page)
+	if err != nil {
+		return
+	}
+	return s.request(requestID, page)
+}
+
+//collect : run Fill of the checkpoint & return queued requests
+func collect(cp *Checkpoint, stage *queueStage) (reqs []Request, err error) {
+	reqQueue := make(chan Request, 10)
+	err = cp.Fill(context.Background(), stage, reqQueue)
+	close(reqQueue)
+
+	for req := range reqQueue {
+		reqs = append(reqs, req)
+	}
+	return
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megamon")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := models.OpenPool(utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.Close()
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	//building fails on the last page, the run isn't built
+	stage := &queueStage{manager: &manager, pages: 4, failing: map[int]bool{3: true}}
+	cp, err := OpenCheckpoint(&manager, stage.Name())
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	reqs, err := collect(cp, stage)
+	if err == nil || len(reqs) != 3 {
+		t.Errorf("Expected 3 requests & build error, got: %d %v", len(reqs), err)
+		return
+	}
+
+	cp.Done(reqs[0].ID)
+	cp.Fail(reqs[1].ID, "404 Not Found")
+	if err = cp.Finish(); err != nil {
+		t.Errorf("%s", err.Error())
+	}
+
+	//restart: requests are built again, processed ones are skipped
+	stage.failing = nil
+	cp, err = OpenCheckpoint(&manager, stage.Name())
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	reqs, err = collect(cp, stage)
+	if err != nil || len(reqs) != 2 || reqs[0].Params != 2 || reqs[1].Params != 3 {
+		t.Errorf("Expected pages 2 & 3 to be queued, got: %v %v", reqs, err)
+		return
+	}
+	cp.Done(reqs[0].ID)
+
+	//restart of the built run: pending requests are restored without building
+	stage.pages = 0
+	cp, err = OpenCheckpoint(&manager, stage.Name())
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	reqs, err = collect(cp, stage)
+	if err != nil || len(reqs) != 1 || reqs[0].Req.URL.String() != "http://localhost/search?page=3" {
+		t.Errorf("Expected page 3 to be restored, got: %v %v", reqs, err)
+		return
+	}
+	cp.Done(reqs[0].ID)
+
+	progress, err := manager.SelectQueueProgress(stage.Name())
+	if err != nil || progress.Done != 3 || progress.Failed != 1 || progress.Pending != 0 || !progress.Run.Built {
+		t.Errorf("Expected 3 done & 1 failed requests, got: %v %v", progress, err)
+	}
+
+	if err = cp.Finish(); err != nil {
+		t.Errorf("%s", err.Error())
+	}
+
+	_, resumed, err := manager.OpenQueueRun(stage.Name())
+	if err != nil || resumed {
+		t.Errorf("Expected finished run not to be resumed, got: %v %v", resumed, err)
+	}
+	return
+}
+
+//skipStage : all requests are skipped
+type skipStage struct {
+	queueStage
+}
+
+func (s *skipStage) SkipRequest(req Request) bool {
+	return true
+}
+
+func TestDoRequestsSkipper(t *testing.T) {
+	stage := &skipStage{queueStage{pages: 3}}
+	reqQueue := make(chan Request, 10)
+	stage.BuildRequests(context.Background(), reqQueue)
+	close(reqQueue)
+
+	//requests are dropped before they are sent
+	responses := make(chan Response, 10)
+	DoRequests(context.Background(), stage, reqQueue, nil, responses, nil, NewOptions(utils.StageSettings{}))
+	close(responses)
+
+	if len(responses) != 0 {
+		t.Errorf("Expected skipped requests not to be sent, got %d responses", len(responses))
+	}
+	return
+}
+
+func TestCheckpointFillCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megamon")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := models.OpenPool(utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.Close()
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	stage := &queueStage{manager: &manager, pages: 100}
+	cp, err := OpenCheckpoint(&manager, stage.Name())
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	//nobody reads the queue after the first request, the builder is stopped by the context
+	ctx, cancel := context.WithCancel(context.Background())
+	reqQueue := make(chan Request)
+	filled := make(chan error)
+	go func() {
+		filled <- cp.Fill(ctx, stage, reqQueue)
+	}()
+
+	<-reqQueue
+	cancel()
+	if err = <-filled; err != context.Canceled {
+		t.Errorf("Expected Fill to be canceled, got: %v", err)
+	}
+
+	progress, err := manager.SelectQueueProgress(stage.Name())
+	if err != nil || progress.Run.Built || progress.Pending+progress.Done >= stage.pages {
+		t.Errorf("Expected run not to be built, got: %v %v", progress, err)
+	}
+	return
+}
+
+//failingQueue : storage failing to store the request of the page once
+type failingQueue struct {
+	models.Storage
+	failed bool
+}
+
+func (q *failingQueue) EnqueueRequest(runID int, URL string, params []byte) (status string, err error) {
+	if !q.failed && strings.HasSuffix(URL, "page=1") {
+		q.failed = true
+		return "", errors.New("database is locked")
+	}
+	return q.Storage.EnqueueRequest(runID, URL, params)
+}
+
+func TestCheckpointFillStoreFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megamon")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := models.OpenPool(utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.Close()
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	storage := &failingQueue{Storage: &manager}
+	stage := &queueStage{manager: storage, pages: 3}
+	cp, err := OpenCheckpoint(storage, stage.Name())
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	//request that isn't stored is still sent, the run stays unbuilt
+	reqQueue := make(chan Request, 3)
+	if err = cp.Fill(context.Background(), stage, reqQueue); err == nil {
+		t.Errorf("Expected Fill to report the request that isn't stored")
+	}
+
+	if len(reqQueue) != 3 {
+		t.Errorf("Expected every request to be sent, got: %d", len(reqQueue))
+	}
+
+	if err = cp.Finish(); err != nil {
+		t.Errorf("%s", err.Error())
+	}
+
+	progress, err := manager.SelectQueueProgress(stage.Name())
+	if err != nil || progress.Run.Built || progress.Pending != 2 {
+		t.Errorf("Expected unbuilt run with 2 stored requests, got: %+v %v", progress, err)
+	}
+
+	//next start builds the run again & stores the missing request
+	cp, err = OpenCheckpoint(storage, stage.Name())
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	reqQueue = make(chan Request, 3)
+	if err = cp.Fill(context.Background(), stage, reqQueue); err != nil {
+		t.Errorf("%s", err.Error())
+	}
+
+	progress, err = manager.SelectQueueProgress(stage.Name())
+	if err != nil || !progress.Run.Built || progress.Pending != 3 {
+		t.Errorf("Expected built run with 3 stored requests, got: %+v %v", progress, err)
+	}
+	return
+}
+
+//paramStage : pages are requested from the test server, params of processed responses are recorded
+type paramStage struct {
+	queueStage
+	url string
+
+	mu        sync.Mutex
+	processed map[int]interface{}
+}
+
+func (s *paramStage) BuildRequests(ctx context.Context, reqQueue chan Request) (err error) {
+	for page := 0; page < s.pages; page++ {
+		httpReq, err := http.NewRequest("GET", fmt.Sprintf("%s/search?page=%d", s.url, page), nil)
+		if err != nil {
+			return err
+		}
+
+		err = Enqueue(ctx, reqQueue, Request{ID: page, Req: httpReq, Params: fmt.Sprintf("page %d", page)})
+		if err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func (s *paramStage) ProcessResponse(resp []byte, requestID int, params interface{}) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processed[requestID] = params
+	return
+}
+
+type noLimit struct{}
+
+func (noLimit) Wait(ctx context.Context, resp *http.Response) interface{} {
+	return nil
+}
+
+func TestPipelineParams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	stage := &paramStage{queueStage: queueStage{pages: 20}, url: server.URL, processed: make(map[int]interface{})}
+	reqQueue := make(chan Request, 4)
+	respQueue := make(chan Response, 4)
+	ctx := context.Background()
+
+	go func() {
+		defer close(reqQueue)
+		stage.BuildRequests(ctx, reqQueue)
+	}()
+
+	//params reach the processors with the responses of their requests
+	var requests, processors sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		requests.Add(1)
+		processors.Add(1)
+		go func() {
+			defer requests.Done()
+			DoRequests(ctx, stage, reqQueue, noLimit{}, respQueue, nil, NewOptions(utils.StageSettings{}))
+		}()
+		go func() {
+			defer processors.Done()
+			ProcessResponses(ctx, stage, respQueue, nil)
+		}()
+	}
+
+	requests.Wait()
+	close(respQueue)
+	processors.Wait()
+
+	if len(stage.processed) != stage.pages {
+		t.Errorf("Expected %d processed responses, got: %d", stage.pages, len(stage.processed))
+	}
+
+	for id, params := range stage.processed {
+		if params != fmt.Sprintf("page %d", id) {
+			t.Errorf("Expected params of request %d, got: %v", id, params)
+		}
+	}
+	return
+}
+
+func TestOptions(t *testing.T) {
+	opts := NewOptions(utils.StageSettings{FragmentizeWorkers: 8, Backoff: 2})
+	expected := Options{RequestWorkers: 1, ProcessWorkers: 1, FragmentizeWorkers: 8, ContextLen: CONTEXTLEN,
+		MaxContextLen: MAXCONTEXTLEN, MaxRetries: MAXRETRIES, Backoff: 2 * time.Second}
+	if opts != expected {
+		t.Errorf("Expected defaults for zero settings, got: %+v", opts)
+	}
+
+	if opts.Settings().Backoff != 2 || opts.Settings().ContextLen != CONTEXTLEN {
+		t.Errorf("Expected settings of the options, got: %+v", opts.Settings())
+	}
+
+	valid := map[string]utils.StageSettings{"fetch": {FragmentizeWorkers: 16}, "gist": {ContextLen: 200, MaxContextLen: 300}}
+	if err := ValidateSettings(valid); err != nil {
+		t.Errorf("%s", err.Error())
+	}
+
+	invalid := []map[string]utils.StageSettings{
+		{"fragmenter": {}},
+		{"search": {MaxRetries: -1}},
+		{"fetch": {RequestWorkers: MAXWORKERS + 1}},
+		{"gist": {ContextLen: MAXCONTEXTLEN + 1}},
+	}
+
+	for _, stages := range invalid {
+		if err := ValidateSettings(stages); err == nil {
+			t.Errorf("Expected %v to be rejected", stages)
+		}
+	}
+}
+
+//retryStage : every response is repeated
+type retryStage struct {
+	paramStage
+}
+
+func (s *retryStage) CheckResponse(resp Response, reqCount int) (res int) {
+	return WAIT
+}
+
+func TestDoRequestsRetries(t *testing.T) {
+	var sent int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&sent, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	stage := &retryStage{paramStage{queueStage: queueStage{pages: 2}, url: server.URL}}
+	reqQueue := make(chan Request, 2)
+	stage.BuildRequests(context.Background(), reqQueue)
+	close(reqQueue)
+
+	//requests are given up after max retries of the stage
+	opts := NewOptions(utils.StageSettings{MaxRetries: 4})
+	opts.Backoff = time.Millisecond
+	responses := make(chan Response, 2)
+	DoRequests(context.Background(), stage, reqQueue, noLimit{}, responses, nil, opts)
+
+	if atomic.LoadInt64(&sent) != 8 || len(responses) != 0 {
+		t.Errorf("Expected 4 attempts of each request, got: %d %d", sent, len(responses))
+	}
+	return
+}
+
+//fakeClock : fixed time, waits return at once & are recorded
+type fakeClock struct {
+	now time.Time
+
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+
+	fired := make(chan time.Time, 1)
+	fired <- c.now.Add(d)
+	return fired
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryPolicy(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	policy := NewRetryPolicy(Options{MaxRetries: 5, Backoff: 4 * time.Second, Clock: clock})
+
+	response := func(code int, header map[string]string) *http.Response {
+		resp := &http.Response{StatusCode: code, Status: http.StatusText(code), Header: make(http.Header)}
+		for key, value := range header {
+			resp.Header.Set(key, value)
+		}
+		return resp
+	}
+
+	cases := []struct {
+		name     string
+		resp     *http.Response
+		err      error
+		attempt  int
+		min, max time.Duration
+	}{
+		{"retry after", response(429, map[string]string{"Retry-After": "30"}), nil, 1, 30 * time.Second, 30 * time.Second},
+		{"retry after date", response(403, map[string]string{"Retry-After": clock.now.Add(2 * time.Minute).Format(http.TimeFormat)}), nil, 1, 2 * time.Minute, 2 * time.Minute},
+		{"rate limit reset", response(403, map[string]string{"x-ratelimit-remaining": "0", "x-ratelimit-reset": strconv.FormatInt(clock.now.Unix()+90, 10)}), nil, 1, 91 * time.Second, 91 * time.Second},
+		{"secondary limit", response(403, nil), nil, 1, SECONDARYLIMITWAIT, SECONDARYLIMITWAIT},
+		{"429 without headers", response(429, nil), nil, 2, 4 * time.Second, 8 * time.Second},
+		{"server error", response(502, nil), nil, 1, 2 * time.Second, 4 * time.Second},
+		{"server error backs off", response(503, nil), nil, 3, 8 * time.Second, 16 * time.Second},
+		{"quota left", response(500, map[string]string{"x-ratelimit-remaining": "10"}), nil, 4, 16 * time.Second, 32 * time.Second},
+		{"timeout", nil, timeoutError{}, 1, 2 * time.Second, 4 * time.Second},
+	}
+
+	for _, c := range cases {
+		retry := policy.Decide(c.resp, c.err, c.attempt)
+		if !retry.Repeat || retry.Delay < c.min || retry.Delay > c.max {
+			t.Errorf("%s: expected retry in [%s, %s], got: %+v", c.name, c.min, c.max, retry)
+		}
+	}
+
+	policy.MaxBackoff = 10 * time.Second
+	if retry := policy.Decide(response(500, nil), nil, 4); retry.Delay < 5*time.Second || retry.Delay > 10*time.Second {
+		t.Errorf("Expected backoff to be capped, got: %+v", retry)
+	}
+
+	//requests skipped at once or given up
+	skipped := []struct {
+		resp    *http.Response
+		err     error
+		attempt int
+	}{
+		{response(404, nil), nil, 1},
+		{response(451, nil), nil, 1},
+		{response(400, nil), nil, 1},
+		{nil, fmt.Errorf("connection refused"), 1},
+		{response(502, nil), nil, 5},
+		{response(429, map[string]string{"Retry-After": "1"}), nil, 5},
+	}
+
+	for _, c := range skipped {
+		if retry := policy.Decide(c.resp, c.err, c.attempt); retry.Repeat || retry.Reason == "" {
+			t.Errorf("Expected request not to be repeated, got: %+v", retry)
+		}
+	}
+}
+
+func TestDoRequestsRetryAfter(t *testing.T) {
+	var sent int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("page") == "1":
+			w.WriteHeader(http.StatusNotFound)
+		case atomic.AddInt64(&sent, 1) == 1:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer server.Close()
+
+	stage := &acceptStage{paramStage{queueStage: queueStage{pages: 2}, url: server.URL}}
+	reqQueue := make(chan Request, 2)
+	stage.BuildRequests(context.Background(), reqQueue)
+	close(reqQueue)
+
+	//rate limited page is repeated after Retry-After, missing one is not repeated
+	clock := &fakeClock{now: time.Now()}
+	opts := NewOptions(utils.StageSettings{})
+	opts.Clock = clock
+	responses := make(chan Response, 2)
+	DoRequests(context.Background(), stage, reqQueue, noLimit{}, responses, nil, opts)
+
+	if len(responses) != 1 || len(clock.waits) != 1 || clock.waits[0] != 7*time.Second {
+		t.Errorf("Expected 1 response after waiting 7s, got: %d %v", len(responses), clock.waits)
+	}
+	return
+}
+
+//acceptStage : successful responses are accepted, the rest is left to the retry policy
+type acceptStage struct {
+	paramStage
+}
+
+func (s *acceptStage) CheckResponse(resp Response, reqCount int) (res int) {
+	if resp.Resp.StatusCode == http.StatusOK {
+		return OK
+	}
+	return WAIT
+}
+
+//authStage : attempts are signed with credentials in turn, the first one is rejected
+type authStage struct {
+	acceptStage
+
+	mu          sync.Mutex
+	credentials []string
+	observed    map[string]int
+}
+
+func (s *authStage) Authorize(ctx context.Context, req *http.Request) (credential string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	credential, s.credentials = s.credentials[0], append(s.credentials[1:], s.credentials[0])
+	req.Header.Set("Authorization", "token "+credential)
+	return
+}
+
+func (s *authStage) Observe(credential string, resp *http.Response, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observed[credential] = resp.StatusCode
+}
+
+func TestDoRequestsAuthorizer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	stage := &authStage{acceptStage: acceptStage{paramStage{queueStage: queueStage{pages: 1}, url: server.URL}},
+		credentials: []string{"revoked", "good"}, observed: make(map[string]int)}
+	reqQueue := make(chan Request, 1)
+	stage.BuildRequests(context.Background(), reqQueue)
+	close(reqQueue)
+
+	//rejected request is repeated at once with the next credential
+	clock := &fakeClock{now: time.Now()}
+	opts := NewOptions(utils.StageSettings{})
+	opts.Clock = clock
+	responses := make(chan Response, 1)
+	DoRequests(context.Background(), stage, reqQueue, noLimit{}, responses, nil, opts)
+
+	if len(responses) != 1 || stage.observed["revoked"] != 401 || stage.observed["good"] != 200 || len(clock.waits) != 1 || clock.waits[0] != 0 {
+		t.Errorf("Expected request to succeed with the next credential, got: %d %v %v", len(responses), stage.observed, clock.waits)
+	}
+	return
+}
+
+func TestDoRequestsAuthorizerExhausted(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token good" {
+			w.Header().Set("x-ratelimit-remaining", "0")
+			w.Header().Set("x-ratelimit-reset", strconv.FormatInt(reset.Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	stage := &authStage{acceptStage: acceptStage{paramStage{queueStage: queueStage{pages: 1}, url: server.URL}},
+		credentials: []string{"exhausted", "good"}, observed: make(map[string]int)}
+	reqQueue := make(chan Request, 1)
+	stage.BuildRequests(context.Background(), reqQueue)
+	close(reqQueue)
+
+	//request is repeated with the next credential instead of waiting for the reset of the exhausted one
+	clock := &fakeClock{now: time.Now()}
+	opts := NewOptions(utils.StageSettings{})
+	opts.Clock = clock
+	responses := make(chan Response, 1)
+	DoRequests(context.Background(), stage, reqQueue, noLimit{}, responses, nil, opts)
+
+	if len(responses) != 1 || stage.observed["exhausted"] != 403 || stage.observed["good"] != 200 || len(clock.waits) != 1 || clock.waits[0] > opts.Backoff {
+		t.Errorf("Expected request to succeed with the next credential, got: %d %v %v", len(responses), stage.observed, clock.waits)
+	}
+	return
+}
@@ -21,19 +21,19 @@ const (
 )
 
 const (
-	//MAXRETRIES : max number of requests to probe
+	//MAXRETRIES : default max number of requests to probe
 	MAXRETRIES = 3
 
-	//TIMEWAIT : time to wait after failed request
+	//TIMEWAIT : default seconds to wait after the first failure, the wait doubles with every attempt
 	TIMEWAIT = 5
 
 	//MAXCHANCAP : max channel capacity
 	MAXCHANCAP = 4096
 
-	//CONTEXTLEN : desired length of keyword context
+	//CONTEXTLEN : default desired length of keyword context
 	CONTEXTLEN = 480
 
-	//MAXCONTEXTLEN : max length of keyword context
+	//MAXCONTEXTLEN : default max length of keyword context
 	MAXCONTEXTLEN = 640
 )
 
@@ -58,38 +58,67 @@ const (
 )
 
 //Request : basic request type
+//Params are stage-specific metadata of the request, they are passed to ProcessResponse with its response
+//Params are saved with the request by stages implementing Checkpointer
 type Request struct {
-	ID  int
-	Req *http.Request
+	ID     int
+	Req    *http.Request
+	Params interface{}
 }
 
-//Response : basic response type
+//Response : basic response type, Params of its request are carried with it
 type Response struct {
 	RequesID int
 	Resp     *http.Response
+	Params   interface{}
 }
 
 //ReportText : text with report ID to fragmentize
+//Metadata fields are optional and used by expression rules
 type ReportText struct {
 	ReportID int
 	Text     string
+	Type     string
+	Path     string
+	Lang     string
+	Repo     string
+	Owner    string
+}
+
+//ReportFragments : all fragments of the report, they are stored together with the status of the report
+type ReportFragments struct {
+	ReportID  int
+	Fragments []models.TextFragment
 }
 
 //MiddlewareInterface common pipeline
 type MiddlewareInterface interface {
-	Init() (err error)
-	Close()
-	GetDBManager() models.Manager
-	BuildRequests(res chan Request) (err error)
+	Init(manager models.Storage) (err error)
+	GetDBManager() models.Storage
+	//BuildRequests : queue requests of the run, returns the error of the context once it is done
+	BuildRequests(ctx context.Context, res chan Request) (err error)
 	CheckResponse(resp Response, reqCount int) (res int)
-	ProcessResponse(resp []byte, RequesID int) (err error)
+	ProcessResponse(resp []byte, RequesID int, params interface{}) (err error)
 }
 
 //Interface : common pipeline
 type Interface interface {
 	MiddlewareInterface
-	GetTextsToProcess(chan ReportText) (err error)
-	ProcessTextFragment(fragment models.TextFragment) error
+	//GetTextsToProcess : queue report texts to fragmentize, returns the error of the context once it is done
+	GetTextsToProcess(ctx context.Context, textQueue chan ReportText) (err error)
+	ProcessTextFragments(reportID int, fragments []models.TextFragment) error
+}
+
+//Skipper : stage dropping queued requests whose results became known while the stage runs
+type Skipper interface {
+	SkipRequest(req Request) bool
+}
+
+//Authorizer : stage signing requests at send time, e.g. with the healthiest token of a pool
+//Every authorized request is observed with its response or error
+type Authorizer interface {
+	Authorize(ctx context.Context, req *http.Request) (credential string, err error)
+	Observe(credential string, resp *http.Response, err error)
 }
 
 //RateLimiter : limits requests rate
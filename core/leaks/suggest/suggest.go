@@ -0,0 +1,333 @@
+package suggest
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/megamon/core/leaks/expr"
+	"github.com/megamon/core/leaks/models"
+)
+
+//Options : thresholds of the suggestion engine
+type Options struct {
+	//MinSupport : minimal number of false positives a rule has to explain
+	MinSupport int
+
+	//MinPrecision : minimal share of false positives among labelled fragments matched
+	MinPrecision float64
+
+	//MaxSamples : max number of fragments of each label to load
+	MaxSamples int
+
+	//MaxSuggestions : max number of suggestions to return
+	MaxSuggestions int
+}
+
+//DefaultOptions : reasonable defaults
+var DefaultOptions = Options{MinSupport: 5, MinPrecision: 0.9, MaxSamples: 5000, MaxSuggestions: 20}
+
+//Sample : reviewed fragment with report metadata
+type Sample struct {
+	FragmentID int
+	Text       string
+	Keywords   []string
+	Path       string
+	Repo       string
+	Owner      string
+	Type       string
+
+	//FalsePositive : fragment was rejected manually, otherwise it was verified
+	FalsePositive bool
+}
+
+//Suggestion : candidate reject rule with its estimated quality
+type Suggestion struct {
+	Name      string  `json:"name"`
+	Rule      string  `json:"rule"`
+	Kind      string  `json:"kind"`
+	Support   int     `json:"support"`
+	Verified  int     `json:"verified"`
+	Precision float64 `json:"precision"`
+	Examples  []int   `json:"examples"`
+}
+
+//maxExamples : number of fragment ids kept as examples of the suggestion
+const maxExamples = 5
+
+//maxOverlap : candidates explaining mostly the same fragments as a better one are dropped
+const maxOverlap = 0.8
+
+type candidate struct {
+	Suggestion
+	match   func(sample *Sample) bool
+	matched []int
+}
+
+//Load : read reviewed fragments & metadata of their reports
+func Load(manager models.Storage, keywords []models.Keyword, opts Options) (samples []Sample, err error) {
+	labels := map[int]bool{models.RULEMANUAL: true, models.RULEVERIFIED: false}
+
+	for rejectID, falsePositive := range labels {
+		frags, err := manager.SelectTextFragments(models.FragmentFilter{
+			RejectIDs: []int{rejectID},
+			Page:      models.Page{Sort: "-id", Limit: opts.MaxSamples},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, frag := range frags {
+			samples = append(samples, Sample{
+				FragmentID:    frag.ID,
+				Text:          frag.Text,
+				Keywords:      keywordsIn(frag.Text, keywords),
+				Path:          frag.Path,
+				Repo:          frag.Repo,
+				Owner:         frag.Owner,
+				Type:          frag.Type,
+				FalsePositive: falsePositive,
+			})
+		}
+	}
+	return
+}
+
+//Suggest : propose reject rules explaining manually rejected fragments
+//Candidates are built from words glued to keywords, words next to keywords & file paths,
+//each candidate clusters fragments it matches; precision is estimated against verified fragments
+func Suggest(samples []Sample, existing []models.RejectRule, opts Options) (suggestions []Suggestion) {
+	known := make(map[string]bool, len(existing))
+	for _, rule := range existing {
+		known[rule.Rule] = true
+	}
+
+	candidates := make(map[string]*candidate)
+	for i := range samples {
+		if samples[i].FalsePositive {
+			addCandidates(candidates, &samples[i])
+		}
+	}
+
+	ranked := make([]*candidate, 0, len(candidates))
+	for _, c := range candidates {
+		if known[c.Rule] {
+			continue
+		}
+
+		evaluate(c, samples)
+		if c.Support >= opts.MinSupport && c.Precision >= opts.MinPrecision {
+			ranked = append(ranked, c)
+		}
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Support != ranked[j].Support {
+			return ranked[i].Support > ranked[j].Support
+		}
+		if ranked[i].Precision != ranked[j].Precision {
+			return ranked[i].Precision > ranked[j].Precision
+		}
+		return ranked[i].Rule < ranked[j].Rule
+	})
+
+	covered := make(map[int]bool)
+	for _, c := range ranked {
+		if len(suggestions) >= opts.MaxSuggestions {
+			break
+		}
+
+		overlap := 0
+		for _, id := range c.matched {
+			if covered[id] {
+				overlap++
+			}
+		}
+
+		if float64(overlap) > maxOverlap*float64(len(c.matched)) {
+			continue
+		}
+
+		for _, id := range c.matched {
+			covered[id] = true
+		}
+		suggestions = append(suggestions, c.Suggestion)
+	}
+	return
+}
+
+func evaluate(c *candidate, samples []Sample) {
+	for i := range samples {
+		if !c.match(&samples[i]) {
+			continue
+		}
+
+		if !samples[i].FalsePositive {
+			c.Verified++
+			continue
+		}
+
+		c.Support++
+		c.matched = append(c.matched, samples[i].FragmentID)
+		if len(c.Examples) < maxExamples {
+			c.Examples = append(c.Examples, samples[i].FragmentID)
+		}
+	}
+
+	if total := c.Support + c.Verified; total > 0 {
+		c.Precision = float64(c.Support) / float64(total)
+	}
+	return
+}
+
+func addCandidates(candidates map[string]*candidate, sample *Sample) {
+	lower := strings.ToLower(sample.Text)
+	for _, keyword := range sample.Keywords {
+		kw := strings.ToLower(keyword)
+		for offset := strings.Index(lower, kw); offset != -1; {
+			for _, rule := range contextRules(lower, kw, offset) {
+				addRegexp(candidates, "context of "+keyword, rule)
+			}
+
+			next := strings.Index(lower[offset+len(kw):], kw)
+			if next == -1 {
+				break
+			}
+			offset += len(kw) + next
+		}
+	}
+
+	if sample.Path == "" {
+		return
+	}
+
+	if ext := path.Ext(sample.Path); ext != "" {
+		addExpression(candidates, "files "+ext, fmt.Sprintf("ext(path) == %q", ext))
+	}
+
+	if dir := path.Dir(sample.Path); dir != "." {
+		for _, part := range strings.Split(dir, "/") {
+			addExpression(candidates, "directory "+part, fmt.Sprintf("path startswith %q or path contains %q", part+"/", "/"+part+"/"))
+		}
+	}
+	return
+}
+
+//contextRules : regexps with the keyword & the closest words around it
+func contextRules(text, keyword string, offset int) (rules []string) {
+	end := offset + len(keyword)
+	quoted := regexp.QuoteMeta(keyword)
+
+	//word the keyword is glued to: password_hash, db_password
+	prefixStart := offset
+	for prefixStart > 0 && isWordByte(text[prefixStart-1]) {
+		prefixStart--
+	}
+
+	suffixEnd := end
+	for suffixEnd < len(text) && isWordByte(text[suffixEnd]) {
+		suffixEnd++
+	}
+
+	if prefixStart < offset {
+		rules = append(rules, "(?i)"+regexp.QuoteMeta(text[prefixStart:offset])+quoted)
+	}
+
+	if suffixEnd > end {
+		rules = append(rules, "(?i)"+quoted+regexp.QuoteMeta(text[end:suffixEnd]))
+	}
+
+	//standalone keyword followed by a word: password = example
+	if prefixStart == offset && suffixEnd == end {
+		if word := nextWord(text, end); word != "" {
+			rules = append(rules, `(?i)\b`+quoted+`\W{1,4}`+regexp.QuoteMeta(word)+`\b`)
+		}
+	}
+	return
+}
+
+func nextWord(text string, start int) string {
+	i := start
+	for i < len(text) && i-start <= 4 && !isWordByte(text[i]) {
+		if text[i] == '\n' {
+			return ""
+		}
+		i++
+	}
+
+	wordStart := i
+	for i < len(text) && isWordByte(text[i]) {
+		i++
+	}
+
+	if i-wordStart < 3 {
+		return ""
+	}
+	return text[wordStart:i]
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b < 0x80 && (unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b)))
+}
+
+func addRegexp(candidates map[string]*candidate, name, rule string) {
+	if _, ok := candidates[rule]; ok {
+		return
+	}
+
+	re, err := regexp.Compile(rule)
+	if err != nil {
+		return
+	}
+
+	candidates[rule] = &candidate{
+		Suggestion: Suggestion{Name: "suggested: " + name, Rule: rule, Kind: models.RULEKINDREGEXP},
+		match:      func(sample *Sample) bool { return re.MatchString(sample.Text) },
+	}
+}
+
+func addExpression(candidates map[string]*candidate, name, rule string) {
+	if _, ok := candidates[rule]; ok {
+		return
+	}
+
+	cond, err := expr.Compile(rule)
+	if err != nil {
+		return
+	}
+
+	candidates[rule] = &candidate{
+		Suggestion: Suggestion{Name: "suggested: " + name, Rule: rule, Kind: models.RULEKINDEXPR},
+		match:      func(sample *Sample) bool { return cond.Eval(sampleEnv(sample)) },
+	}
+}
+
+func sampleEnv(sample *Sample) expr.Env {
+	keyword := ""
+	if len(sample.Keywords) > 0 {
+		keyword = sample.Keywords[0]
+	}
+
+	return expr.Env{
+		"text":     sample.Text,
+		"keyword":  keyword,
+		"keywords": sample.Keywords,
+		"path":     sample.Path,
+		"repo":     sample.Repo,
+		"owner":    sample.Owner,
+		"type":     sample.Type,
+	}
+}
+
+func keywordsIn(text string, keywords []models.Keyword) (hits []string) {
+	lower := strings.ToLower(text)
+	for _, keyword := range keywords {
+		if keyword.Value != "" && strings.Contains(lower, strings.ToLower(keyword.Value)) {
+			hits = append(hits, keyword.Value)
+		}
+	}
+	return
+}
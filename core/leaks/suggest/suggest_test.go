@@ -0,0 +1,91 @@
+package suggest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/megamon/core/leaks/models"
+)
+
+func testSamples() (samples []Sample) {
+	id := 0
+	add := func(n int, text, filePath string, falsePositive bool) {
+		for i := 0; i < n; i++ {
+			id++
+			samples = append(samples, Sample{
+				FragmentID:    id,
+				Text:          fmt.Sprintf(text, i),
+				Keywords:      []string{"password"},
+				Path:          fmt.Sprintf(filePath, i),
+				FalsePositive: falsePositive,
+			})
+		}
+	}
+
+	add(10, "user.password_hash = hash(%d)", "src/user%d.py", true)
+	add(6, "password = example%d", "docs/guide%d.md", true)
+	add(3, "password = 'Hunter%d'", "src/settings%d.py", false)
+	add(1, "old_password_hash = 'ab%dcd'", "src/leak%d.py", false)
+	return
+}
+
+func TestSuggest(t *testing.T) {
+	opts := Options{MinSupport: 5, MinPrecision: 0.9, MaxSuggestions: 10}
+	suggestions := Suggest(testSamples(), nil, opts)
+
+	found := make(map[string]Suggestion)
+	for _, s := range suggestions {
+		found[s.Rule] = s
+	}
+
+	hash, ok := found["(?i)password_hash"]
+	if !ok {
+		t.Errorf("Expected password_hash rule, got: %v", suggestions)
+		return
+	}
+
+	if hash.Support != 10 || hash.Verified != 1 || hash.Precision < 0.9 || len(hash.Examples) != maxExamples {
+		t.Errorf("Wrong estimation of password_hash rule: %+v", hash)
+	}
+
+	docs, ok := found[`ext(path) == ".md"`]
+	if !ok || docs.Kind != models.RULEKINDEXPR || docs.Precision != 1 {
+		t.Errorf("Expected markdown path rule, got: %v", suggestions)
+	}
+
+	//docs/ directory explains the same fragments as the .md rule
+	if _, ok := found[`path startswith "docs/" or path contains "/docs/"`]; ok {
+		t.Errorf("Overlapping suggestion was not dropped: %v", suggestions)
+	}
+
+	//src/ directory contains verified leaks
+	for _, s := range suggestions {
+		if s.Precision < opts.MinPrecision || s.Support < opts.MinSupport {
+			t.Errorf("Suggestion below thresholds: %+v", s)
+		}
+	}
+	return
+}
+
+func TestSuggestSkipsExisting(t *testing.T) {
+	existing := []models.RejectRule{{Rule: "(?i)password_hash"}}
+	for _, s := range Suggest(testSamples(), existing, DefaultOptions) {
+		if s.Rule == "(?i)password_hash" {
+			t.Errorf("Existing rule suggested again")
+		}
+	}
+	return
+}
+
+func TestContextRules(t *testing.T) {
+	rules := contextRules("db_password: changeme", "password", 3)
+	if len(rules) != 1 || rules[0] != "(?i)db_password" {
+		t.Errorf("Expected prefix rule, got: %v", rules)
+	}
+
+	rules = contextRules("password: changeme", "password", 0)
+	if len(rules) != 1 || rules[0] != `(?i)\bpassword\W{1,4}changeme\b` {
+		t.Errorf("Expected next word rule, got: %v", rules)
+	}
+	return
+}
@@ -0,0 +1,187 @@
+package taskrun
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/utils"
+)
+
+const (
+	//FlushInterval : how often counters of the running task are written to the database
+	FlushInterval = 5 * time.Second
+
+	//MAXLOGLINES : log lines of a run kept in the database, the rest go only to the log file
+	MAXLOGLINES = 1000
+)
+
+type contextKey struct{}
+
+//Run : run of a task, counters are updated by the stage pipeline & written to the database while the task runs
+//Methods of nil run do nothing, so code running outside of a tracked task needs no checks
+type Run struct {
+	ID   int
+	Task string
+
+	manager                                         models.Storage
+	requests, retries, reports, fragments, rejected int64
+	logLines                                        int64
+}
+
+//Start : record new run of the task, the run is passed to the task in the returned context
+func Start(ctx context.Context, manager models.Storage, task string) (runCtx context.Context, run *Run, err error) {
+	record, err := manager.StartTaskRun(task)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	run = &Run{ID: record.ID, Task: task, manager: manager}
+	return context.WithValue(ctx, contextKey{}, run), run, nil
+}
+
+//FromContext : run of the task the context belongs to, nil if the task isn't tracked
+func FromContext(ctx context.Context) *Run {
+	run, _ := ctx.Value(contextKey{}).(*Run)
+	return run
+}
+
+//Track : task recorded in the task runs table, its counters are written every FlushInterval
+//Task is run even if it can't be recorded
+func Track(manager models.Storage, task string, fn func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) (err error) {
+		ctx, run, err := Start(ctx, manager, task)
+		if err != nil {
+			utils.ErrorLogger.Println(err.Error())
+			return fn(ctx)
+		}
+
+		utils.InfoLogger.Printf("%s run %d started", task, run.ID)
+		run.Log(models.LOGINFO, "started")
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				case <-time.After(FlushInterval):
+					run.Flush()
+				}
+			}
+		}()
+
+		err = fn(ctx)
+		close(done)
+		wg.Wait()
+
+		run.Finish(err, ctx.Err() != nil)
+		return
+	}
+}
+
+//AddRequests : requests sent, retries included
+func (run *Run) AddRequests(n int) {
+	if run != nil {
+		atomic.AddInt64(&run.requests, int64(n))
+	}
+}
+
+//AddRetries : requests repeated after a failure or a rate limit
+func (run *Run) AddRetries(n int) {
+	if run != nil {
+		atomic.AddInt64(&run.retries, int64(n))
+	}
+}
+
+//AddReports : new reports stored
+func (run *Run) AddReports(n int) {
+	if run != nil {
+		atomic.AddInt64(&run.reports, int64(n))
+	}
+}
+
+//AddFragments : fragments of processed reports, rejected ones are counted among them too
+func (run *Run) AddFragments(n, rejected int) {
+	if run != nil {
+		atomic.AddInt64(&run.fragments, int64(n))
+		atomic.AddInt64(&run.rejected, int64(rejected))
+	}
+}
+
+//Counters : current counters of the run
+func (run *Run) Counters() (counters models.RunCounters) {
+	if run == nil {
+		return
+	}
+
+	counters.Requests = int(atomic.LoadInt64(&run.requests))
+	counters.Retries = int(atomic.LoadInt64(&run.retries))
+	counters.Reports = int(atomic.LoadInt64(&run.reports))
+	counters.Fragments = int(atomic.LoadInt64(&run.fragments))
+	counters.Rejected = int(atomic.LoadInt64(&run.rejected))
+	return
+}
+
+//Flush : write counters to the database
+func (run *Run) Flush() {
+	if run == nil {
+		return
+	}
+
+	err := run.manager.UpdateTaskRunCounters(run.ID, run.Counters())
+	if err != nil {
+		utils.ErrorLogger.Println(err.Error())
+	}
+}
+
+//Log : add line to the log of the run, callers write the log file themselves
+func (run *Run) Log(level, message string) {
+	if run == nil {
+		return
+	}
+
+	lines := atomic.AddInt64(&run.logLines, 1)
+	if lines > MAXLOGLINES {
+		return
+	}
+
+	if lines == MAXLOGLINES {
+		level, message = models.LOGINFO, fmt.Sprintf("log is truncated after %d lines", MAXLOGLINES)
+	}
+
+	err := run.manager.InsertTaskRunLog(models.TaskRunLog{RunID: run.ID, Time: time.Now().Unix(), Level: level, Message: message})
+	if err != nil {
+		utils.ErrorLogger.Println(err.Error())
+	}
+}
+
+//Finish : record final status of the run, canceled run may still return an error
+func (run *Run) Finish(taskErr error, canceled bool) {
+	if run == nil {
+		return
+	}
+
+	status, reason := models.TASKDONE, ""
+	if taskErr != nil {
+		status, reason = models.TASKFAILED, taskErr.Error()
+		run.Log(models.LOGERROR, reason)
+	}
+
+	if canceled {
+		status = models.TASKCANCELED
+	}
+
+	utils.InfoLogger.Printf("%s run %d: %s", run.Task, run.ID, status)
+	run.Log(models.LOGINFO, status)
+
+	err := run.manager.FinishTaskRun(run.ID, status, reason, run.Counters())
+	if err != nil {
+		utils.ErrorLogger.Println(err.Error())
+	}
+}
@@ -0,0 +1,111 @@
+package taskrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLoggers("test.log")
+
+	retCode := m.Run()
+	err := os.Remove("test.log")
+	if err != nil {
+		fmt.Println("Unable to remove test.log")
+		fmt.Println(err.Error())
+	}
+	os.Exit(retCode)
+}
+
+func TestTrack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megamon")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := models.OpenPool(utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.Close()
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	//pipeline counts from several workers
+	task := Track(&manager, "github", func(ctx context.Context) error {
+		run := FromContext(ctx)
+		done := make(chan struct{})
+		for i := 0; i < 4; i++ {
+			go func() {
+				run.AddRequests(3)
+				run.AddRetries(1)
+				run.AddReports(2)
+				run.AddFragments(5, 1)
+				done <- struct{}{}
+			}()
+		}
+
+		for i := 0; i < 4; i++ {
+			<-done
+		}
+		run.Log(models.LOGERROR, "page 2: 502 Bad Gateway")
+		return nil
+	})
+
+	if err = task(context.Background()); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	failing := Track(&manager, "gist", func(ctx context.Context) error {
+		return errors.New("bad credentials")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = failing(ctx); err == nil {
+		t.Errorf("Expected error of the task to be returned")
+	}
+
+	runs, err := manager.SelectTaskRuns(models.TaskRunFilter{Page: models.Page{Sort: "id"}})
+	if err != nil || len(runs) != 2 {
+		t.Errorf("Expected 2 runs, got: %v %v", runs, err)
+		return
+	}
+
+	expected := models.RunCounters{Requests: 12, Retries: 4, Reports: 8, Fragments: 20, Rejected: 4}
+	if runs[0].Status != models.TASKDONE || runs[0].RunCounters != expected || runs[0].Finished == 0 {
+		t.Errorf("Expected done run with counters, got: %+v", runs[0])
+	}
+
+	if runs[1].Status != models.TASKCANCELED || runs[1].Error != "bad credentials" {
+		t.Errorf("Expected canceled run with its error, got: %+v", runs[1])
+	}
+
+	logs, err := manager.SelectTaskRunLogs(runs[0].ID)
+	if err != nil || len(logs) != 3 || logs[0].Message != "started" || logs[1].Level != models.LOGERROR || logs[2].Message != models.TASKDONE {
+		t.Errorf("Expected log of the run, got: %v %v", logs, err)
+	}
+
+	//code outside of a tracked task
+	var run *Run
+	run.AddRequests(1)
+	run.Log(models.LOGINFO, "ignored")
+	if FromContext(context.Background()) != nil || run.Counters() != (models.RunCounters{}) {
+		t.Errorf("Expected nil run to do nothing")
+	}
+}
@@ -0,0 +1,305 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megamon/core/leaks/stage"
+	"github.com/megamon/core/utils"
+)
+
+//QUARANTINE : how long token rejected with 401 is not used, then it is tried again
+const QUARANTINE = time.Hour
+
+const (
+	//TOKENOK : token has quota left or its quota is unknown yet
+	TOKENOK = "ok"
+
+	//TOKENEXHAUSTED : token has no quota left until reset
+	TOKENEXHAUSTED = "exhausted"
+
+	//TOKENQUARANTINED : token was rejected with 401
+	TOKENQUARANTINED = "quarantined"
+)
+
+//ErrNoTokens : every token is quarantined or there are none in settings
+var ErrNoTokens = errors.New("tokens: no usable github tokens")
+
+type token struct {
+	value string
+
+	//known : quota headers were seen, limit & remaining are valid
+	known            bool
+	limit, remaining int
+	reset            time.Time
+
+	quarantined time.Time
+	reason      string
+
+	inFlight           int
+	requests, failures int
+	lastUsed           time.Time
+}
+
+//Health : state of the token shown in the settings UI, only the mask of the token is exposed
+//Limit & Remaining are -1 until quota headers of the token are seen
+type Health struct {
+	Mask        string `json:"mask"`
+	Status      string `json:"status"`
+	Limit       int    `json:"limit"`
+	Remaining   int    `json:"remaining"`
+	Reset       int64  `json:"reset"`
+	Requests    int    `json:"requests"`
+	Failures    int    `json:"failures"`
+	Quarantined int64  `json:"quarantined_until"`
+	Reason      string `json:"reason"`
+}
+
+//Pool : github tokens with quota & reset tracked per token from responses
+//Requests are signed at send time with the healthiest token, safe for concurrent use
+type Pool struct {
+	Clock stage.Clock
+
+	mu     sync.Mutex
+	tokens []*token
+}
+
+//NewPool : pool of the tokens, quota of every token is unknown until its first response
+func NewPool(values []string) *Pool {
+	p := &Pool{Clock: stage.SystemClock}
+	p.Sync(values)
+	return p
+}
+
+var (
+	defaultMu   sync.Mutex
+	defaultPool *Pool
+)
+
+//Default : process-wide pool shared by tasks & backend, synced with tokens in global settings
+func Default() *Pool {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultPool == nil {
+		defaultPool = NewPool(nil)
+	}
+
+	defaultPool.Sync(utils.Settings.Github.Tokens)
+	return defaultPool
+}
+
+//Sync : set tokens of the pool, state of the tokens it already has is kept
+func (p *Pool) Sync(values []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	known := make(map[string]*token, len(p.tokens))
+	for _, t := range p.tokens {
+		known[t.value] = t
+	}
+
+	tokens := make([]*token, 0, len(values))
+	for _, value := range values {
+		t, ok := known[value]
+		if value == "" || (ok && t == nil) {
+			continue
+		}
+
+		if !ok {
+			t = &token{value: value}
+		}
+
+		tokens = append(tokens, t)
+		known[value] = nil
+	}
+	p.tokens = tokens
+}
+
+func (t *token) status(now time.Time) string {
+	switch {
+	case now.Before(t.quarantined):
+		return TOKENQUARANTINED
+	case t.known && t.remaining <= 0 && now.Before(t.reset):
+		return TOKENEXHAUSTED
+	default:
+		return TOKENOK
+	}
+}
+
+//quota : requests the token may still send, unknown quota & passed reset count as a full one
+func (t *token) quota(now time.Time) int {
+	switch {
+	case !t.known || (!now.Before(t.reset) && t.limit == 0):
+		return 1<<30 - t.inFlight
+	case !now.Before(t.reset):
+		return t.limit - t.inFlight
+	default:
+		return t.remaining - t.inFlight
+	}
+}
+
+//pick : token with the most quota left, least recently used of equal ones
+//Wait until the earliest reset is returned if every token is exhausted
+func (p *Pool) pick(now time.Time) (best *token, wait time.Duration, err error) {
+	var reset time.Time
+	for _, t := range p.tokens {
+		switch t.status(now) {
+		case TOKENOK:
+			if best == nil || t.quota(now) > best.quota(now) ||
+				(t.quota(now) == best.quota(now) && t.lastUsed.Before(best.lastUsed)) {
+				best = t
+			}
+
+		case TOKENEXHAUSTED:
+			if reset.IsZero() || t.reset.Before(reset) {
+				reset = t.reset
+			}
+		}
+	}
+
+	switch {
+	case best != nil:
+		return best, 0, nil
+	case !reset.IsZero():
+		return nil, reset.Sub(now), nil
+	default:
+		return nil, 0, ErrNoTokens
+	}
+}
+
+//Authorize : stage authorizer realization, request is signed with the healthiest token
+//If every token is exhausted it waits for the earliest reset, ErrNoTokens if all are quarantined
+func (p *Pool) Authorize(ctx context.Context, req *http.Request) (value string, err error) {
+	for {
+		p.mu.Lock()
+		now := p.Clock.Now()
+		t, wait, err := p.pick(now)
+		if err != nil {
+			p.mu.Unlock()
+			return "", err
+		}
+
+		if t != nil {
+			t.inFlight++
+			t.requests++
+			t.lastUsed = now
+			p.mu.Unlock()
+
+			req.Header.Set("Authorization", "token "+t.value)
+			return t.value, nil
+		}
+		p.mu.Unlock()
+
+		utils.InfoLogger.Printf("tokens: all tokens are exhausted, waiting %s", wait)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-p.Clock.After(wait):
+		}
+	}
+}
+
+//Observe : stage authorizer realization, quota of the token is updated from the response
+//Token rejected with 401 is quarantined, secondary rate limit exhausts it until Retry-After
+func (p *Pool) Observe(value string, resp *http.Response, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var t *token
+	for _, candidate := range p.tokens {
+		if candidate.value == value {
+			t = candidate
+		}
+	}
+
+	//token was removed from settings while the request was sent
+	if t == nil {
+		return
+	}
+
+	t.inFlight--
+	if err != nil {
+		return
+	}
+
+	now := p.Clock.Now()
+	if resp.StatusCode == http.StatusUnauthorized {
+		t.failures++
+		t.quarantined, t.reason = now.Add(QUARANTINE), resp.Status
+		utils.ErrorLogger.Printf("tokens: token %s is quarantined until %s: %s", Mask(value), t.quarantined.Format(time.RFC3339), resp.Status)
+		return
+	}
+
+	if resp.StatusCode >= 400 {
+		t.failures++
+	}
+
+	remaining, rErr := strconv.Atoi(resp.Header.Get("x-ratelimit-remaining"))
+	reset, sErr := strconv.ParseInt(resp.Header.Get("x-ratelimit-reset"), 10, 64)
+	if rErr == nil && sErr == nil {
+		t.known, t.remaining, t.reset = true, remaining, time.Unix(reset, 0)
+		if limit, err := strconv.Atoi(resp.Header.Get("x-ratelimit-limit")); err == nil {
+			t.limit = limit
+		}
+	}
+
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err == nil && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) {
+		t.known, t.remaining = true, 0
+		if until := now.Add(time.Duration(retryAfter) * time.Second); until.After(t.reset) {
+			t.reset = until
+		}
+	}
+}
+
+//Health : state of every token in order of settings
+func (p *Pool) Health() (health []Health) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.Clock.Now()
+	health = make([]Health, 0, len(p.tokens))
+	for _, t := range p.tokens {
+		h := Health{Mask: Mask(t.value), Status: t.status(now), Limit: -1, Remaining: -1,
+			Requests: t.requests, Failures: t.failures, Reason: t.reason}
+
+		if t.known {
+			h.Limit, h.Remaining, h.Reset = t.limit, t.remaining, t.reset.Unix()
+		}
+
+		if h.Status == TOKENQUARANTINED {
+			h.Quarantined = t.quarantined.Unix()
+		}
+		health = append(health, h)
+	}
+	return
+}
+
+//MASKSUFFIX : last characters of the token shown by its mask
+const MASKSUFFIX = 4
+
+//tokenTypes : prefixes of github token types, the fine-grained one is the longest
+var tokenTypes = []string{"github_pat_", "ghp_", "gho_", "ghu_", "ghs_", "ghr_"}
+
+//Mask : type prefix & last characters of the token, safe to log & show, e.g. ghp_...abcd
+//Tokens too short to hide most of them keep only the type prefix
+func Mask(value string) string {
+	kind := ""
+	for _, prefix := range tokenTypes {
+		if strings.HasPrefix(value, prefix) {
+			kind = prefix
+			break
+		}
+	}
+
+	secret := value[len(kind):]
+	if len(secret) <= 2*MASKSUFFIX {
+		return kind + "..."
+	}
+	return kind + "..." + secret[len(secret)-MASKSUFFIX:]
+}
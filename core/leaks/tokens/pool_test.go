@@ -0,0 +1,190 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megamon/core/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLoggers("test.log")
+
+	retCode := m.Run()
+	err := os.Remove("test.log")
+	if err != nil {
+		fmt.Println("Unable to remove test.log")
+		fmt.Println(err.Error())
+	}
+	os.Exit(retCode)
+}
+
+//fakeClock : time moves only when the pool waits
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+
+	fired := make(chan time.Time, 1)
+	fired <- c.now
+	return fired
+}
+
+func response(code int, remaining int, reset time.Time) *http.Response {
+	resp := &http.Response{StatusCode: code, Status: http.StatusText(code), Header: make(http.Header)}
+	resp.Header.Set("x-ratelimit-limit", "30")
+	resp.Header.Set("x-ratelimit-remaining", strconv.Itoa(remaining))
+	resp.Header.Set("x-ratelimit-reset", strconv.FormatInt(reset.Unix(), 10))
+	return resp
+}
+
+func TestPool(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	pool := NewPool([]string{"ghp_aaaaaaaa", "ghp_bbbbbbbb", "ghp_cccccccc"})
+	pool.Clock = clock
+	ctx := context.Background()
+	reset := clock.now.Add(time.Minute)
+
+	authorize := func() string {
+		t.Helper()
+		req, _ := http.NewRequest("GET", "https://api.github.com/search/code", nil)
+		token, err := pool.Authorize(ctx, req)
+		if err != nil || req.Header.Get("Authorization") != "token "+token {
+			t.Errorf("Expected signed request, got: %q %v", req.Header.Get("Authorization"), err)
+		}
+		return token
+	}
+
+	//tokens of unknown quota are tried in turn
+	for _, expected := range []string{"ghp_aaaaaaaa", "ghp_bbbbbbbb", "ghp_cccccccc"} {
+		clock.now = clock.now.Add(time.Second)
+		if token := authorize(); token != expected {
+			t.Errorf("Expected %s, got: %s", expected, token)
+		}
+	}
+
+	pool.Observe("ghp_aaaaaaaa", response(200, 5, reset), nil)
+	pool.Observe("ghp_bbbbbbbb", response(200, 20, reset), nil)
+	pool.Observe("ghp_cccccccc", response(403, 0, reset), nil)
+
+	//the most quota left, exhausted token is not used until reset
+	if token := authorize(); token != "ghp_bbbbbbbb" {
+		t.Errorf("Expected token with the most quota, got: %s", token)
+	}
+
+	pool.Observe("ghp_bbbbbbbb", &http.Response{StatusCode: 401, Status: "401 Unauthorized", Header: make(http.Header)}, nil)
+	if token := authorize(); token != "ghp_aaaaaaaa" {
+		t.Errorf("Expected quarantined token not to be used, got: %s", token)
+	}
+
+	pool.Observe("ghp_aaaaaaaa", response(429, 0, reset), nil)
+
+	//every usable token is exhausted: wait for the earliest reset
+	if token := authorize(); token != "ghp_aaaaaaaa" && token != "ghp_cccccccc" || len(clock.waits) != 1 {
+		t.Errorf("Expected to wait for reset, got: %s %v", token, clock.waits)
+	}
+
+	health := pool.Health()
+	data, _ := json.Marshal(health)
+	if len(health) != 3 || health[1].Status != TOKENQUARANTINED || health[1].Reason != "401 Unauthorized" ||
+		health[0].Mask != "ghp_..." || strings.Contains(string(data), "aaaaaaaa") {
+		t.Errorf("Expected health of tokens without them, got: %s", data)
+	}
+
+	//state of kept tokens survives settings update
+	pool.Sync([]string{"ghp_bbbbbbbb", "", "ghp_dddddddd", "ghp_bbbbbbbb"})
+	health = pool.Health()
+	if len(health) != 2 || health[0].Status != TOKENQUARANTINED || health[1].Limit != -1 {
+		t.Errorf("Expected synced tokens, got: %+v", health)
+	}
+
+	pool.Sync([]string{"ghp_bbbbbbbb"})
+	req, _ := http.NewRequest("GET", "https://api.github.com/search/code", nil)
+	if _, err := pool.Authorize(ctx, req); err != ErrNoTokens {
+		t.Errorf("Expected no usable tokens, got: %v", err)
+	}
+
+	clock.now = clock.now.Add(QUARANTINE)
+	if token := authorize(); token != "ghp_bbbbbbbb" {
+		t.Errorf("Expected token to be tried again after quarantine, got: %s", token)
+	}
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	pool := NewPool([]string{"ghp_aaaaaaaa", "ghp_bbbbbbbb"})
+	reset := time.Now().Add(time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				req, _ := http.NewRequest("GET", "https://api.github.com/search/code", nil)
+				token, err := pool.Authorize(context.Background(), req)
+				if err != nil {
+					t.Errorf("%s", err.Error())
+					return
+				}
+				pool.Observe(token, response(200, 1000-i*50-j, reset), nil)
+				pool.Health()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	requests := 0
+	for _, health := range pool.Health() {
+		requests += health.Requests
+	}
+
+	if requests != 400 {
+		t.Errorf("Expected 400 requests, got: %d", requests)
+	}
+}
+
+func TestMask(t *testing.T) {
+	first := "ghp_" + strings.Repeat("a", 32) + "1234"
+	second := "ghp_" + strings.Repeat("a", 32) + "5678"
+	pool := NewPool([]string{first, second})
+
+	//tokens of the same type are told apart by their last characters
+	health := pool.Health()
+	if len(health) != 2 || health[0].Mask != "ghp_...1234" || health[1].Mask != "ghp_...5678" {
+		t.Errorf("Expected distinct masks of ghp_ tokens, got: %+v", health)
+	}
+
+	masks := map[string]string{
+		"github_pat_" + strings.Repeat("b", 78) + "wxyz": "github_pat_...wxyz",
+		strings.Repeat("c", 36) + "beef":                 "...beef",
+		"ghp_short":                                      "ghp_...",
+	}
+
+	for value, expected := range masks {
+		if mask := Mask(value); mask != expected {
+			t.Errorf("Expected mask %s, got: %s", expected, mask)
+		}
+	}
+	return
+}
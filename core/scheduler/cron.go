@@ -0,0 +1,212 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Schedule : times the task is due
+type Schedule interface {
+	//Next : first due time after t
+	Next(t time.Time) time.Time
+}
+
+//maxLookahead : schedule never due within this period is rejected, e.g. 0 0 30 2 *
+const maxLookahead = 5 * 366 * 24 * time.Hour
+
+//field : range of values of the cron field
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes = field{name: "minute", min: 0, max: 59}
+	hours   = field{name: "hour", min: 0, max: 23}
+	days    = field{name: "day of month", min: 1, max: 31}
+	months  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	weekdays = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+//descriptors : shortcuts for common schedules
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+//cronSchedule : standard 5 field schedule, fields are sets of allowed values
+//Day is due if either day of month or day of week matches, unless one of them is *
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+//everySchedule : fixed interval from the previous run
+type everySchedule struct {
+	interval time.Duration
+}
+
+//Next : schedule interface realization
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+//Parse : schedule from 5 field cron expression (minute hour day-of-month month day-of-week),
+//@hourly, @daily, @weekly, @monthly, @yearly or @every <duration>
+func Parse(spec string) (schedule Schedule, err error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, err
+		}
+
+		if interval < time.Minute {
+			return nil, fmt.Errorf("schedule %q: interval is shorter than a minute", spec)
+		}
+		return everySchedule{interval}, nil
+	}
+
+	if expanded, ok := descriptors[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	var s cronSchedule
+	bits := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, f := range []field{minutes, hours, days, months, weekdays} {
+		*bits[i], err = f.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %s", spec, err.Error())
+		}
+	}
+
+	//7 is sunday too
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q is never due", spec)
+	}
+	return s, nil
+}
+
+//parse : set of values from comma separated list of *, values & ranges with optional /step
+func (f field) parse(expr string) (bits uint64, err error) {
+	for _, part := range strings.Split(expr, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step of %s: %q", f.name, part)
+			}
+			part = part[:i]
+		}
+
+		low, high := f.min, f.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			if low, err = f.value(bounds[0]); err != nil {
+				return
+			}
+			if high, err = f.value(bounds[1]); err != nil {
+				return
+			}
+		default:
+			if low, err = f.value(part); err != nil {
+				return
+			}
+
+			//a/n means from a to the end
+			high = low
+			if step > 1 {
+				high = f.max
+			}
+		}
+
+		if low > high {
+			return 0, fmt.Errorf("invalid range of %s: %q", f.name, part)
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return
+}
+
+func (f field) value(s string) (v int, err error) {
+	if named, ok := f.names[strings.ToLower(s)]; ok {
+		return named, nil
+	}
+
+	v, err = strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s: %q", f.name, s)
+	}
+	return v, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+//Next : schedule interface realization, zero time if the schedule is not due within maxLookahead
+func (s cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxLookahead)
+
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
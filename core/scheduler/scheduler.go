@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/utils"
+)
+
+//DefaultTick : how often the scheduler checks whether tasks are due
+const DefaultTick = 30 * time.Second
+
+//entry : parsed schedule of the task
+type entry struct {
+	spec     string
+	schedule Schedule
+	state    models.TaskSchedule
+}
+
+//Scheduler : starts registered tasks on cron schedules from settings (globals.schedules)
+//Task still running when it is due is not started again, next & last runs are persisted
+type Scheduler struct {
+	Manager models.Storage
+	Tasks   map[string]*utils.TaskManager
+
+	//Defaults : schedules of tasks without one in settings
+	Defaults map[string]string
+	Tick     time.Duration
+
+	entries map[string]*entry
+
+	//schedules : copy of the schedules in settings, replaced by SetSchedules when settings change
+	mu        sync.Mutex
+	schedules map[string]string
+}
+
+//New : scheduler of the tasks, the same tasks are controlled by the backend
+//Schedules are copied from current settings, later changes are passed by SetSchedules
+func New(manager models.Storage, tasks map[string]*utils.TaskManager) *Scheduler {
+	s := &Scheduler{
+		Manager:  manager,
+		Tasks:    tasks,
+		Defaults: make(map[string]string),
+		Tick:     DefaultTick,
+		entries:  make(map[string]*entry),
+	}
+	s.SetSchedules(utils.Settings.LeakGlobals.Schedules)
+	return s
+}
+
+//SetSchedules : schedules changed in settings, they are picked up on the next tick
+func (s *Scheduler) SetSchedules(schedules map[string]string) {
+	copied := make(map[string]string, len(schedules))
+	for task, spec := range schedules {
+		copied[task] = spec
+	}
+
+	s.mu.Lock()
+	s.schedules = copied
+	s.mu.Unlock()
+}
+
+//Validate : check schedules before they are saved to settings, empty schedule means manual runs only
+func Validate(schedules map[string]string) (err error) {
+	for task, spec := range schedules {
+		if spec == "" {
+			continue
+		}
+
+		if _, err = Parse(spec); err != nil {
+			return fmt.Errorf("task %s: %s", task, err.Error())
+		}
+	}
+	return
+}
+
+//Specs : schedules of the tasks, settings override defaults
+func (s *Scheduler) Specs() map[string]string {
+	specs := make(map[string]string, len(s.Defaults))
+	for task, spec := range s.Defaults {
+		specs[task] = spec
+	}
+
+	s.mu.Lock()
+	for task, spec := range s.schedules {
+		specs[task] = spec
+	}
+	s.mu.Unlock()
+	return specs
+}
+
+//Run : check schedules every tick until the context is done
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		s.tick(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Tick):
+		}
+	}
+}
+
+//tick : start due tasks, schedules changed in settings are picked up here
+func (s *Scheduler) tick(now time.Time) {
+	specs := s.Specs()
+	for task, tm := range s.Tasks {
+		e, err := s.entry(task, specs[task], now)
+		if err != nil {
+			utils.ErrorLogger.Println(err.Error())
+			continue
+		}
+
+		if e == nil || now.Unix() < e.state.NextRun {
+			continue
+		}
+
+		e.state.LastRun = now.Unix()
+		if tm.Start() {
+			utils.InfoLogger.Printf("scheduler: %s started", task)
+			e.state.LastStatus = models.SCHEDULESTARTED
+		} else {
+			utils.InfoLogger.Printf("scheduler: %s is still running, the run is skipped", task)
+			e.state.LastStatus = models.SCHEDULESKIPPED
+		}
+
+		e.state.NextRun = e.schedule.Next(now).Unix()
+		s.save(e.state)
+	}
+}
+
+//entry : schedule of the task, it is (re)loaded when the spec changes, nil if the task is not scheduled
+//Next run persisted with the same spec is kept, so the run due while the service was down is started now
+func (s *Scheduler) entry(task, spec string, now time.Time) (e *entry, err error) {
+	e, ok := s.entries[task]
+	if ok && e.spec == spec {
+		if spec == "" {
+			return nil, nil
+		}
+		return e, nil
+	}
+
+	state, err := s.Manager.SelectTaskSchedule(task)
+	if err == sql.ErrNoRows {
+		state, err = models.TaskSchedule{Task: task}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	e = &entry{spec: spec, state: state}
+	if spec == "" {
+		s.entries[task] = e
+		if state.Cron != "" || state.NextRun != 0 {
+			e.state.Cron, e.state.NextRun = "", 0
+			s.save(e.state)
+		}
+		return nil, nil
+	}
+
+	e.schedule, err = Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Cron != spec || state.NextRun == 0 {
+		e.state.Cron = spec
+		e.state.NextRun = e.schedule.Next(now).Unix()
+		s.save(e.state)
+	}
+
+	s.entries[task] = e
+	return e, nil
+}
+
+func (s *Scheduler) save(state models.TaskSchedule) {
+	err := s.Manager.SaveTaskSchedule(state)
+	if err != nil {
+		utils.ErrorLogger.Println(err.Error())
+	}
+}
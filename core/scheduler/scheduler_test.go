@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLoggers("test.log")
+
+	retCode := m.Run()
+	err := os.Remove("test.log")
+	if err != nil {
+		fmt.Println("Unable to remove test.log")
+		fmt.Println(err.Error())
+	}
+	os.Exit(retCode)
+}
+
+func TestParse(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 23, 59, 30, 0, time.UTC)
+	cases := map[string]time.Time{
+		"*/15 * * * *":    time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"30 2 * * *":      time.Date(2024, time.February, 1, 2, 30, 0, 0, time.UTC),
+		"0 9 * * mon-fri": time.Date(2024, time.February, 1, 9, 0, 0, 0, time.UTC),
+		"0 0 29 feb *":    time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 1 * 0":       time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"0 12 13 * 5":     time.Date(2024, time.February, 2, 12, 0, 0, 0, time.UTC),
+		"@weekly":         time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC),
+		"@every 90m":      start.Add(90 * time.Minute),
+	}
+
+	for spec, expected := range cases {
+		schedule, err := Parse(spec)
+		if err != nil {
+			t.Errorf("%s", err.Error())
+			continue
+		}
+
+		if next := schedule.Next(start); !next.Equal(expected) {
+			t.Errorf("Expected %s to be due at %s, got: %s", spec, expected, next)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "0 0 30 2 *", "*/0 * * * *", "5-1 * * * *", "@every 10s", "@sometimes"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestSchedulerTick(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megamon")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := models.OpenPool(utils.DBCredentialsSettings{Driver: models.DriverSQLite, Path: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+	defer manager.Close()
+
+	if err = manager.Migrate(); err != nil {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	release := make(chan struct{})
+	runs := 0
+	task := utils.NewTaskManager(func(ctx context.Context) error {
+		runs++
+		<-release
+		return nil
+	})
+
+	utils.Settings.LeakGlobals.Schedules = map[string]string{"github": "*/10 * * * *"}
+	defer func() { utils.Settings.LeakGlobals.Schedules = nil }()
+
+	tasks := map[string]*utils.TaskManager{"github": task, "gist": utils.NewTaskManager(nil)}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, time.January, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	expect := func(status string, lastRun, nextRun time.Time) {
+		t.Helper()
+		schedule, err := manager.SelectTaskSchedule("github")
+		if err != nil || schedule.Cron != "*/10 * * * *" || schedule.LastStatus != status ||
+			(!lastRun.IsZero() && schedule.LastRun != lastRun.Unix()) || schedule.NextRun != nextRun.Unix() {
+			t.Errorf("Expected %s run & next run at %s, got: %+v %v", status, nextRun, schedule, err)
+		}
+	}
+
+	s := New(&manager, tasks)
+	s.tick(at(0, 5))
+	expect("", time.Time{}, at(0, 10))
+
+	s.tick(at(0, 10))
+	expect(models.SCHEDULESTARTED, at(0, 10), at(0, 20))
+
+	//the run is still in progress
+	s.tick(at(0, 20))
+	expect(models.SCHEDULESKIPPED, at(0, 20), at(0, 30))
+
+	close(release)
+	task.Wait()
+
+	//runs due while the service was down are started once
+	s = New(&manager, tasks)
+	s.tick(at(1, 3))
+	expect(models.SCHEDULESTARTED, at(1, 3), at(1, 10))
+	task.Wait()
+
+	if runs != 2 {
+		t.Errorf("Expected 2 runs, got: %d", runs)
+	}
+
+	if _, err = manager.SelectTaskSchedule("gist"); err == nil {
+		t.Errorf("Expected unscheduled task not to be saved")
+	}
+
+	s.SetSchedules(map[string]string{"github": ""})
+	s.tick(at(1, 10))
+	schedule, err := manager.SelectTaskSchedule("github")
+	if err != nil || schedule.Cron != "" || schedule.NextRun != 0 || runs != 2 {
+		t.Errorf("Expected schedule to be removed, got: %+v %v", schedule, err)
+	}
+}
+
+func TestSetSchedules(t *testing.T) {
+	s := New(nil, nil)
+	s.Defaults["maintenance"] = "0 3 * * *"
+
+	//settings change while the scheduler reads its specs
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			s.SetSchedules(map[string]string{"github": fmt.Sprintf("*/%d * * * *", i%10+1)})
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		s.Specs()
+	}
+	<-done
+
+	schedules := map[string]string{"github": "0 * * * *", "maintenance": ""}
+	s.SetSchedules(schedules)
+	schedules["github"] = ""
+
+	specs := s.Specs()
+	if specs["github"] != "0 * * * *" || specs["maintenance"] != "" {
+		t.Errorf("Expected copied schedules over defaults, got: %v", specs)
+	}
+}
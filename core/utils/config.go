@@ -1,13 +1,19 @@
 package utils
 
-import "regexp"
+import (
+	"regexp"
+
+	"github.com/megamon/core/leaks/expr"
+)
 
 //RejectRule : description of reject rule
 type RejectRule struct {
 	ID   int    `json:"id"`
 	Rule string `json:"rule"`
 	Name string `json:"name"`
+	Kind string `json:"kind"`
 	Expr *regexp.Regexp
+	Cond *expr.Expr `json:"-"`
 }
 
 //Keyword : auxilary data type
@@ -23,6 +29,7 @@ type GlobalSettings struct {
 	DBCredentials    DBCredentialsSettings `yaml:"db_redentials" json:"db_redentials"`
 	LeakGlobals      leakGlobalsSettings   `yaml:"globals" json:"globals"`
 	AdminCredentials webAdminSettings      `yaml:"admin_credentials" json:"admin_credentials"`
+	ContentStore     ContentStoreSettings  `yaml:"content_store" json:"content_store"`
 }
 
 //Blacklist : black list
@@ -32,10 +39,43 @@ type Blacklist struct {
 
 //DBCredentialsSettings : database credentials
 type DBCredentialsSettings struct {
+	//Driver : postgres (default) or sqlite
+	Driver     string `yaml:"driver" json:"driver"`
+	Path       string `yaml:"path" json:"path"`
 	Database   string `yaml:"database" json:"database"`
 	Name       string `yaml:"name" json:"name"`
 	Password   string `yaml:"password" json:"password"`
 	DBHostName string `yaml:"db_hostname"`
+
+	Pool DBPoolSettings `yaml:"pool" json:"pool"`
+}
+
+//DBPoolSettings : limits of the process-wide connection pool, zero values use defaults
+//Timeouts are in seconds, RetryDelay is in milliseconds and grows with every attempt
+type DBPoolSettings struct {
+	MaxOpenConns     int `yaml:"max_open_conns" json:"max_open_conns"`
+	MaxIdleConns     int `yaml:"max_idle_conns" json:"max_idle_conns"`
+	ConnMaxLifetime  int `yaml:"conn_max_lifetime" json:"conn_max_lifetime"`
+	ConnMaxIdleTime  int `yaml:"conn_max_idle_time" json:"conn_max_idle_time"`
+	ConnectTimeout   int `yaml:"connect_timeout" json:"connect_timeout"`
+	StatementTimeout int `yaml:"statement_timeout" json:"statement_timeout"`
+	Retries          int `yaml:"retries" json:"retries"`
+	RetryDelay       int `yaml:"retry_delay" json:"retry_delay"`
+}
+
+//ContentStoreSettings : where fetched content is kept
+//Type is flat (legacy files named by hash), fs (sharded, default) or s3,
+//compression is none, gzip (default) or zstd
+//Unset type stays flat while ContentDir has only legacy files, megamon content migrate moves them to the set type
+type ContentStoreSettings struct {
+	Type        string `yaml:"type" json:"type"`
+	Compression string `yaml:"compression" json:"compression"`
+	Endpoint    string `yaml:"endpoint" json:"endpoint"`
+	Region      string `yaml:"region" json:"region"`
+	Bucket      string `yaml:"bucket" json:"bucket"`
+	Prefix      string `yaml:"prefix" json:"prefix"`
+	AccessKey   string `yaml:"access_key" json:"access_key"`
+	SecretKey   string `yaml:"secret_key" json:"-"`
 }
 
 type githubSettings struct {
@@ -52,12 +92,48 @@ type leakGlobalsSettings struct {
 	ContentDir string `yaml:"content_dir" json:"content_dir"`
 	LogDir     string `yaml:"log_dir" json:"log_dir"`
 	LogFile    string `yaml:"log_file" json:"log_file"`
+	ModelFile  string `yaml:"model_file" json:"model_file"`
+
+	Retention []RetentionPolicy `yaml:"retention" json:"retention"`
+
+	//RetentionInterval : hours between maintenance runs if the maintenance task has no schedule
+	RetentionInterval int `yaml:"retention_interval" json:"retention_interval"`
+
+	//Schedules : cron expressions of tasks by name, e.g. github: "0 */6 * * *", empty means manual runs only
+	Schedules map[string]string `yaml:"schedules" json:"schedules"`
+
+	//Stages : concurrency & limits of pipeline stages by name: search, fetch & gist
+	Stages map[string]StageSettings `yaml:"stages" json:"stages"`
+}
+
+//StageSettings : workers & limits of the pipeline stage, zero values use defaults
+//Context lengths are in characters, Backoff is seconds to wait after the first failure, the wait doubles with every attempt
+type StageSettings struct {
+	RequestWorkers     int `yaml:"request_workers" json:"request_workers"`
+	ProcessWorkers     int `yaml:"process_workers" json:"process_workers"`
+	FragmentizeWorkers int `yaml:"fragmentize_workers" json:"fragmentize_workers"`
+	ContextLen         int `yaml:"context_len" json:"context_len"`
+	MaxContextLen      int `yaml:"max_context_len" json:"max_context_len"`
+	MaxRetries         int `yaml:"max_retries" json:"max_retries"`
+	Backoff            int `yaml:"backoff" json:"backoff"`
+}
+
+//RetentionPolicy : purge reports of the type (any if empty) & status older than Days
+//Action is content, fragments or report
+type RetentionPolicy struct {
+	Type   string `yaml:"type" json:"type"`
+	Status string `yaml:"status" json:"status"`
+	Days   int    `yaml:"days" json:"days"`
+	Action string `yaml:"action" json:"action"`
 }
 
 type webAdminSettings struct {
 	Username  string `yaml:"username" json:"username"`
 	Password  string `yaml:"password" json:"password"`
 	AuthToken string `yaml:"token" json:"token"`
+
+	//TrustedProxies : IPs or CIDR ranges of reverse proxies whose X-Forwarded-For is trusted, none trusts only the peer address
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
 }
 
 //Settings : global instance of settings for the project
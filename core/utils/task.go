@@ -12,51 +12,109 @@ const (
 	//TaskCanceled : task was canceled
 	TaskCanceled = "canceled"
 
+	//TaskCancelling : task was asked to stop & hasn't returned yet
+	TaskCancelling = "cancelling"
+
 	//TaskRunning : task is running
 	TaskRunning = "running"
 
 	//TaskDone : task done
 	TaskDone = "done"
+
+	//TaskFailed : task returned error
+	TaskFailed = "failed"
 )
 
-//WorkerParams : auxilary structure to hold all needed values
-type WorkerParams struct {
-	Task      func(ctx context.Context) error
-	Status    string
-	Cancel    context.CancelFunc
-	WaitGroup sync.WaitGroup
+//TaskManager : runs a task in the background, at most one run at a time
+//It is shared by the backend & the scheduler, all methods are safe for concurrent use
+type TaskManager struct {
+	task func(ctx context.Context) error
+
+	mu     sync.Mutex
+	status string
+	err    error
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+//NewTaskManager : manager of the task, the task must return when its context is done
+func NewTaskManager(task func(ctx context.Context) error) *TaskManager {
+	done := make(chan struct{})
+	close(done)
+	return &TaskManager{task: task, status: TaskNotRunning, done: done}
+}
+
+//Status : state of the last run
+func (tm *TaskManager) Status() string {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.status
 }
 
-//RunTask : start task
-func RunTask(wp *WorkerParams) {
-	if (*wp).Status == TaskRunning {
-		return
+//Err : error the last run returned, nil while it runs
+func (tm *TaskManager) Err() error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.err
+}
+
+//Start : start the task, false if it is running or cancelling already
+func (tm *TaskManager) Start() (started bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.status == TaskRunning || tm.status == TaskCancelling {
+		return false
 	}
 
-	var ctx context.Context
-	ctx, (*wp).Cancel = context.WithCancel(context.Background())
-	(*wp).Status = TaskRunning
-	(*wp).WaitGroup.Add(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	tm.status, tm.err, tm.cancel, tm.done = TaskRunning, nil, cancel, done
 
 	go func() {
-		defer (*wp).WaitGroup.Done()
-		err := (*wp).Task(ctx)
+		defer close(done)
+		err := tm.task(ctx)
 		if err != nil {
 			ErrorLogger.Println(err.Error())
 		}
-
-		(*wp).Status = TaskDone
+		cancel()
+		tm.finish(err)
 	}()
-	return
+	return true
 }
 
-//EndTask : send cancel message to the running task
-func EndTask(wp *WorkerParams) {
-	if (*wp).Status != TaskRunning {
-		return
+//finish : final status of the run, canceled run stays canceled whatever the task returned
+func (tm *TaskManager) finish(err error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.err = err
+	switch {
+	case tm.status == TaskCancelling:
+		tm.status = TaskCanceled
+	case err != nil:
+		tm.status = TaskFailed
+	default:
+		tm.status = TaskDone
 	}
+}
+
+//Stop : cancel context of the running task, the task is cancelling until it returns
+//False if the task isn't running
+func (tm *TaskManager) Stop() (stopped bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.status != TaskRunning {
+		return false
+	}
+
+	tm.status = TaskCancelling
+	tm.cancel()
+	return true
+}
 
-	(*wp).Cancel()
-	(*wp).WaitGroup.Wait()
-	(*wp).Status = TaskCanceled
+//Wait : wait for the current run to return, returns at once if the task isn't running
+func (tm *TaskManager) Wait() {
+	tm.mu.Lock()
+	done := tm.done
+	tm.mu.Unlock()
+	<-done
 }
@@ -1,9 +1,26 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
 	"testing"
 )
 
+func TestMain(m *testing.M) {
+	InitLoggers("test.log")
+
+	retCode := m.Run()
+	err := os.Remove("test.log")
+	if err != nil {
+		fmt.Println("Unable to remove test.log")
+		fmt.Println(err.Error())
+	}
+	os.Exit(retCode)
+}
+
 func TestInitConfig(t *testing.T) {
 	err := InitConfig("../../config/config.yaml")
 
@@ -17,3 +34,73 @@ func TestInitConfig(t *testing.T) {
 
 	return
 }
+
+func TestTaskManager(t *testing.T) {
+	release, cancelled := make(chan struct{}), make(chan struct{})
+	fail := errors.New("bad credentials")
+	var taskErr error
+	tm := NewTaskManager(func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			<-cancelled
+		case <-release:
+		}
+		return taskErr
+	})
+
+	if tm.Status() != TaskNotRunning || tm.Stop() {
+		t.Errorf("Expected idle task not to be stopped, got: %s", tm.Status())
+	}
+	tm.Wait()
+
+	//backend & scheduler start the task at once, only one run is started
+	started := make(chan bool, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started <- tm.Start()
+			_ = tm.Status()
+		}()
+	}
+	wg.Wait()
+	close(started)
+
+	runs := 0
+	for ok := range started {
+		if ok {
+			runs++
+		}
+	}
+
+	if runs != 1 || tm.Status() != TaskRunning {
+		t.Errorf("Expected 1 running run, got: %d %s", runs, tm.Status())
+	}
+
+	close(release)
+	tm.Wait()
+	if tm.Status() != TaskDone || tm.Err() != nil {
+		t.Errorf("Expected task to be done, got: %s %v", tm.Status(), tm.Err())
+	}
+
+	//canceled run stays canceled even if the task returns error
+	taskErr = fail
+	release = make(chan struct{})
+	tm.Start()
+	if !tm.Stop() || tm.Start() {
+		t.Errorf("Expected cancelling task not to be started again, got: %s", tm.Status())
+	}
+	close(cancelled)
+	tm.Wait()
+	if tm.Status() != TaskCanceled || tm.Err() != fail {
+		t.Errorf("Expected task to be canceled, got: %s %v", tm.Status(), tm.Err())
+	}
+
+	tm.Start()
+	close(release)
+	tm.Wait()
+	if tm.Status() != TaskFailed || tm.Err() != fail {
+		t.Errorf("Expected task to fail, got: %s %v", tm.Status(), tm.Err())
+	}
+}
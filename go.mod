@@ -3,11 +3,13 @@ module github.com/megamon
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/gorilla/sessions v1.2.1
-	github.com/labstack/echo v3.3.10+incompatible
+	github.com/klauspost/compress v1.15.15
 	github.com/labstack/echo-contrib v0.11.0
 	github.com/labstack/echo/v4 v4.3.0
 	github.com/lib/pq v1.10.2
 	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324
 	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.14.8
 )
@@ -4,42 +4,27 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"runtime"
 
+	"github.com/megamon/core/leaks/classifier"
 	"github.com/megamon/core/leaks/gist"
 	"github.com/megamon/core/leaks/github"
 	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/leaks/retention"
+	"github.com/megamon/core/leaks/stage"
+	"github.com/megamon/core/leaks/taskrun"
+	"github.com/megamon/core/scheduler"
 	"github.com/megamon/core/utils"
 	"github.com/megamon/web/backend"
 )
 
-func runWorker(taskQueue chan int, task func(ctx context.Context) error) {
-	ctx := context.Background()
-	var taskID int
-	var err error
-
-	for {
-		select {
-		case taskID = <-taskQueue:
-			switch taskID {
-			case 1:
-				fmt.Printf("Got %d\n", taskID)
-				taskQueue <- 2
-				err = task(ctx)
-				if err != nil {
-					utils.ErrorLogger.Println(err.Error())
-				}
-			default:
-				fmt.Printf("Got %d\n", taskID)
-			}
-		default:
-			runtime.Gosched()
-		}
-	}
+//withManager : task using the shared database pool, its runs are recorded in the task runs table
+func withManager(manager models.Storage, name string, task func(ctx context.Context, manager models.Storage) error) func(ctx context.Context) error {
+	return taskrun.Track(manager, name, func(ctx context.Context) error {
+		return task(ctx, manager)
+	})
 }
 
 func main() {
-	_ = context.Background()
 	utils.InitConfig("./config/config.yaml")
 
 	if _, err := os.Stat(utils.Settings.LeakGlobals.LogDir); os.IsNotExist(err) {
@@ -54,6 +39,7 @@ func main() {
 	utils.InitLoggers(logFilePath)
 	utils.InfoLogger.Println("programm started")
 
+	//the only pool of the process, stages, tasks & backend share it
 	var manager models.Manager
 	err := manager.Init()
 	if err != nil {
@@ -62,13 +48,62 @@ func main() {
 	}
 
 	defer manager.Close()
-	models.Init(manager.Database)
 
-	params := make(map[string](*utils.WorkerParams))
-	params["github"] = &utils.WorkerParams{Task: github.RunGitSearch, Status: utils.TaskNotRunning}
-	params["gist"] = &utils.WorkerParams{Task: gist.RunGistStage, Status: utils.TaskNotRunning}
+	//migrate command manages schema versions by itself
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		err = manager.Migrate()
+		if err != nil {
+			utils.ErrorLogger.Fatal(err.Error())
+			return
+		}
+	}
+
+	if len(os.Args) > 1 {
+		err = runCommand(&manager, os.Args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			manager.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	//runs of the previous process can't finish anymore
+	aborted, err := manager.AbortTaskRuns()
+	if err != nil {
+		utils.ErrorLogger.Fatal(err.Error())
+		return
+	}
+
+	if aborted > 0 {
+		utils.InfoLogger.Printf("%d interrupted task runs marked failed", aborted)
+	}
+
+	params := make(map[string]*utils.TaskManager)
+	params["github"] = utils.NewTaskManager(withManager(&manager, "github", github.RunGitSearch))
+	params["gist"] = utils.NewTaskManager(withManager(&manager, "gist", gist.RunGistStage))
+	params["classifier"] = utils.NewTaskManager(withManager(&manager, "classifier", classifier.RunTraining))
+	params["maintenance"] = utils.NewTaskManager(withManager(&manager, "maintenance", retention.RunMaintenance))
+
+	err = scheduler.Validate(utils.Settings.LeakGlobals.Schedules)
+	if err != nil {
+		utils.ErrorLogger.Fatal(err.Error())
+		return
+	}
+
+	err = stage.ValidateSettings(utils.Settings.LeakGlobals.Stages)
+	if err != nil {
+		utils.ErrorLogger.Fatal(err.Error())
+		return
+	}
+
+	//tasks run on schedules from settings, maintenance runs every retention_interval hours by default
+	sched := scheduler.New(&manager, params)
+	sched.Defaults["maintenance"] = retention.DefaultSchedule()
+	go sched.Run(context.Background())
 
-	var b backend.Backend
+	//external cron starts only the searches, maintenance & training keep their schedules
+	b := backend.Backend{DBManager: &manager, AllTasks: []string{"github", "gist"}, Scheduler: sched}
 	b.Start(params)
 	return
 }
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/allowlist"
+	"github.com/megamon/core/leaks/models"
+)
+
+func getAllowlist(ctx echo.Context) (err error) {
+	entries, err := ctx.(Context).backend.DBManager.SelectAllowlist()
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	if entries == nil {
+		entries = []models.AllowlistEntry{}
+	}
+	return ctx.JSON(200, entries)
+}
+
+func addAllowlistEntry(ctx echo.Context) (err error) {
+	var entry models.AllowlistEntry
+	err = ctx.Bind(&entry)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	entry.Value = strings.TrimSpace(entry.Value)
+	err = allowlist.Validate(entry)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	entry.ID, err = ctx.(Context).backend.DBManager.InsertAllowlistEntry(entry)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+	audit(ctx, "allowlist.add", "allowlist", []int{entry.ID}, nil, entry)
+
+	return ctx.JSON(200, entry)
+}
+
+func deleteAllowlistEntry(ctx echo.Context) (err error) {
+	entryID, err := strconv.Atoi(ctx.Param("entry_id"))
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	manager := ctx.(Context).backend.DBManager
+	entries, err := manager.SelectAllowlist()
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	var old interface{}
+	for _, entry := range entries {
+		if entry.ID == entryID {
+			old = entry
+		}
+	}
+
+	err = manager.DeleteAllowlistEntry(entryID)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+	audit(ctx, "allowlist.delete", "allowlist", []int{entryID}, old, nil)
+
+	return ctx.String(200, "OK")
+}
@@ -0,0 +1,254 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/leaks/tokens"
+	"github.com/megamon/core/utils"
+)
+
+//tokenActor : actor of requests authorized with the API token instead of a session
+const tokenActor = "api-token"
+
+//audit : record action of the current user, values before & after it are stored as JSON
+//Failure to write the log is reported, but doesn't undo the action which already happened
+func audit(ctx echo.Context, action, target string, targetIDs []int, before, after interface{}) {
+	actor := getLoginFromSession(ctx)
+	if actor == "" {
+		actor = tokenActor
+	}
+	auditAs(ctx, actor, action, target, targetIDs, before, after)
+}
+
+//auditAs : record action of the actor which is not logged in yet, e.g. the login attempt
+func auditAs(ctx echo.Context, actor, action, target string, targetIDs []int, before, after interface{}) {
+	entry := models.AuditEntry{
+		Time:      time.Now().Unix(),
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		TargetIDs: targetIDs,
+		Old:       auditValue(before),
+		New:       auditValue(after),
+		ClientIP:  ctx.RealIP(),
+	}
+
+	_, err := ctx.(Context).backend.DBManager.InsertAuditEntry(entry)
+	if err != nil {
+		utils.ErrorLogger.Printf("audit: %s %s %v: %s", actor, action, targetIDs, err.Error())
+	}
+}
+
+//clientIPExtractor : client IP of the audit log, X-Forwarded-For is read only from the trusted proxies
+//Without trusted proxies the peer address is used, so clients can't forge their IP with headers
+func clientIPExtractor(proxies []string) (extractor echo.IPExtractor, err error) {
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, ipRange, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+func auditValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+//maskTokens : only masks of tokens get into the log
+func maskTokens(values []string) (masked []string) {
+	masked = make([]string, 0, len(values))
+	for _, value := range values {
+		masked = append(masked, tokens.Mask(value))
+	}
+	return
+}
+
+//auditedSettings : settings changed by updateSettings without secrets
+type auditedSettings struct {
+	Username        string                         `json:"username"`
+	PasswordChanged bool                           `json:"password_changed,omitempty"`
+	Tokens          []string                       `json:"tokens"`
+	Langs           []string                       `json:"langs"`
+	Keywords        []string                       `json:"keywords"`
+	Schedules       map[string]string              `json:"schedules"`
+	Stages          map[string]utils.StageSettings `json:"stages"`
+}
+
+func currentSettings() (settings auditedSettings) {
+	settings.Username = utils.Settings.AdminCredentials.Username
+	settings.Tokens = maskTokens(utils.Settings.Github.Tokens)
+	settings.Langs = append([]string{}, utils.Settings.Github.Langs.Blacklist...)
+
+	settings.Keywords = make([]string, 0, len(utils.Settings.LeakGlobals.Keywords))
+	for keyword := range utils.Settings.LeakGlobals.Keywords {
+		settings.Keywords = append(settings.Keywords, keyword)
+	}
+
+	settings.Schedules = make(map[string]string, len(utils.Settings.LeakGlobals.Schedules))
+	for task, spec := range utils.Settings.LeakGlobals.Schedules {
+		settings.Schedules[task] = spec
+	}
+
+	settings.Stages = make(map[string]utils.StageSettings, len(utils.Settings.LeakGlobals.Stages))
+	for name, stage := range utils.Settings.LeakGlobals.Stages {
+		settings.Stages[name] = stage
+	}
+	return
+}
+
+//auditFilter : filter from actor, action, target, target_id, since, until, sort, limit & offset form values
+func auditFilter(ctx echo.Context) (filter models.AuditFilter, err error) {
+	filter = models.AuditFilter{Actor: ctx.FormValue("actor"), Action: ctx.FormValue("action"), Target: ctx.FormValue("target")}
+	filter.Sort = ctx.FormValue("sort")
+	if filter.Sort == "" {
+		filter.Sort = "-id"
+	}
+
+	params := map[string]*int{"target_id": &filter.TargetID, "since": &filter.Since, "until": &filter.Until, "limit": &filter.Limit, "offset": &filter.Offset}
+	for name, value := range params {
+		*value, err = intParam(ctx, name)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func getAuditLog(ctx echo.Context) (err error) {
+	filter, err := auditFilter(ctx)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	manager := ctx.(Context).backend.DBManager
+	total, err := manager.CountAuditEntries(filter)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	entries, err := manager.SelectAuditEntries(filter)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+
+	return ctx.JSON(200, struct {
+		Total   int                 `json:"total"`
+		Entries []models.AuditEntry `json:"entries"`
+	}{total, entries})
+}
+
+//exportAuditLog : all entries matching the filter as csv (default) or json attachment
+func exportAuditLog(ctx echo.Context) (err error) {
+	filter, err := auditFilter(ctx)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	entries, err := ctx.(Context).backend.DBManager.SelectAuditEntries(filter)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	format := ctx.FormValue("format")
+	switch format {
+	case "", "csv":
+		format = "csv"
+	case "json":
+		if entries == nil {
+			entries = []models.AuditEntry{}
+		}
+	default:
+		return ctx.String(400, "Unknown format: "+format)
+	}
+
+	filename := "audit-" + strconv.FormatInt(time.Now().Unix(), 10) + "." + format
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+
+	if format == "json" {
+		return ctx.JSON(200, entries)
+	}
+
+	data, err := auditCSV(entries)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+	return ctx.Blob(200, "text/csv", data)
+}
+
+func auditCSV(entries []models.AuditEntry) (data []byte, err error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	err = writer.Write([]string{"id", "time", "actor", "action", "target", "target_ids", "old", "new", "client_ip"})
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		targetIDs := make([]string, 0, len(entry.TargetIDs))
+		for _, ID := range entry.TargetIDs {
+			targetIDs = append(targetIDs, strconv.Itoa(ID))
+		}
+
+		err = writer.Write([]string{
+			strconv.Itoa(entry.ID),
+			time.Unix(entry.Time, 0).UTC().Format(time.RFC3339),
+			csvCell(entry.Actor),
+			entry.Action,
+			entry.Target,
+			strings.Join(targetIDs, " "),
+			csvCell(entry.Old),
+			csvCell(entry.New),
+			entry.ClientIP,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+//csvCell : values starting with formula characters are quoted, so spreadsheets don't evaluate them
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/scheduler"
 	"github.com/megamon/core/utils"
 )
 
@@ -16,9 +17,15 @@ type Template struct {
 	templates *template.Template
 }
 
-//Backend : backend instance
+//Backend : backend instance, DBManager is the shared pool opened by the caller
 type Backend struct {
-	DBManager models.Manager
+	DBManager models.Storage
+
+	//AllTasks : tasks started by /leaks/api/task/all/start, maintenance & training run on their own schedules
+	AllTasks []string
+
+	//Scheduler : gets schedules changed in settings, nil if tasks are not scheduled
+	Scheduler *scheduler.Scheduler
 }
 
 //Render : render template function
@@ -29,6 +36,12 @@ func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Con
 //Start : initialize backend
 func (b *Backend) Start(p Params) {
 	e := echo.New()
+	extractor, err := clientIPExtractor(utils.Settings.AdminCredentials.TrustedProxies)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	e.IPExtractor = extractor
+
 	t := &Template{
 		templates: template.Must(template.ParseGlob("web/frontend/templates/*")),
 	}
@@ -37,7 +50,6 @@ func (b *Backend) Start(p Params) {
 	csMiddleware := session.Middleware(cookieStore)
 	e.Use(csMiddleware)
 	e.Renderer = t
-	b.DBManager.Init()
 
 	//Pass database & job queues to context
 	e.Use(func(h echo.HandlerFunc) echo.HandlerFunc {
@@ -52,17 +64,41 @@ func (b *Backend) Start(p Params) {
 
 	e.GET("/leaks/api/report/events", getReportedEvents, basicAuthRequired)
 	e.GET("/leaks/api/report/events/count", getNewReportCount, basicAuthRequired)
+	e.GET("/leaks/api/health", getHealth, basicAuthRequired)
 	e.GET("/leaks/api/report/frags/:datatype/:status", getFragments, loginRequired)
 	e.GET("/leaks/api/report/count/:datatype/:status", getFragmentCount, loginRequired)
 	e.GET("/leaks/api/report/info/:frag_id", getFragmentInfo, loginRequired)
 	e.GET("/leaks/api/report/mark/:frag_id/:status", markFragment, loginRequired)
+	e.GET("/leaks/api/search", getSearchResults, loginRequired)
+	e.GET("/leaks/api/search/coverage", getSearchCoverage, loginRequired)
 
 	e.GET("/leaks/api/settings", getSettings, loginRequired)
 	e.POST("/leaks/api/settings", updateSettings, loginRequired)
 
+	e.GET("/leaks/api/rules/export", exportRules, loginRequired)
+	e.POST("/leaks/api/rules/import", importRules, loginRequired)
+	e.GET("/leaks/api/rules/suggestions", getRuleSuggestions, loginRequired)
+	e.POST("/leaks/api/rules/suggestions/accept", acceptRuleSuggestion, loginRequired)
+
+	e.GET("/leaks/api/allowlist", getAllowlist, loginRequired)
+	e.POST("/leaks/api/allowlist", addAllowlistEntry, loginRequired)
+	e.DELETE("/leaks/api/allowlist/:entry_id", deleteAllowlistEntry, loginRequired)
+
+	e.GET("/leaks/api/classifier/report", getClassifierReport, loginRequired)
+
+	e.GET("/leaks/api/retention/dryrun", getRetentionDryRun, loginRequired)
+
+	e.GET("/leaks/api/audit", getAuditLog, loginRequired)
+	e.GET("/leaks/api/audit/export", exportAuditLog, loginRequired)
+
 	e.GET("/leaks/api/task/all/start", startAllTasks, basicAuthRequired)
 	e.GET("/leaks/api/task/:task/:state", taskManager, loginRequired)
 	e.GET("/leaks/api/task/available", tasksAvailable, loginRequired)
+	e.GET("/leaks/api/schedules", getSchedules, loginRequired)
+	e.GET("/leaks/api/stages", getStages, loginRequired)
+	e.GET("/leaks/api/tokens", getTokenHealth, loginRequired)
+	e.GET("/leaks/api/runs", getTaskRuns, loginRequired)
+	e.GET("/leaks/api/runs/:run_id", getTaskRun, loginRequired)
 
 	e.GET("/login", loginPage)
 	e.POST("/login", handleLogin)
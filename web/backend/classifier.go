@@ -0,0 +1,23 @@
+package backend
+
+import (
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/classifier"
+)
+
+func getClassifierReport(ctx echo.Context) (err error) {
+	model, err := classifier.Load(classifier.ModelFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ctx.String(404, "classifier is not trained yet")
+		}
+		return ctx.String(500, err.Error())
+	}
+
+	if model.Report == nil {
+		return ctx.String(404, "model has no evaluation report")
+	}
+	return ctx.JSON(200, model.Report)
+}
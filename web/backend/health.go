@@ -0,0 +1,14 @@
+package backend
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+//getHealth : database reachability & pool statistics, 503 if the database doesn't answer
+func getHealth(ctx echo.Context) (err error) {
+	health := ctx.(Context).backend.DBManager.Health(ctx.Request().Context())
+	if !health.OK {
+		return ctx.JSON(503, health)
+	}
+	return ctx.JSON(200, health)
+}
@@ -2,10 +2,10 @@ package backend
 
 import (
 	"crypto/sha1"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"regexp"
 	"strconv"
 	"time"
 
@@ -13,6 +13,8 @@ import (
 	"github.com/megamon/core/leaks/fragment"
 	"github.com/megamon/core/leaks/models"
 	"github.com/megamon/core/leaks/stage"
+	"github.com/megamon/core/leaks/tokens"
+	"github.com/megamon/core/scheduler"
 	"github.com/megamon/core/utils"
 	"gopkg.in/yaml.v2"
 )
@@ -22,7 +24,7 @@ func stub(ctx echo.Context) (err error) {
 }
 
 //Params : parameters to the backend
-type Params map[string]*utils.WorkerParams
+type Params map[string]*utils.TaskManager
 
 //Context : context with db and other stuff
 type Context struct {
@@ -31,65 +33,58 @@ type Context struct {
 	echo.Context
 }
 
-func getFragments(ctx echo.Context) (err error) {
-	fragmentType := ctx.Param("datatype")
+//fragmentFilter : filter from route params, source of the report is matched by repo, owner, path, language, keyword form values
+func fragmentFilter(ctx echo.Context) (filter models.FragmentFilter, err error) {
 	rejectID, err := strconv.Atoi(ctx.Param("status"))
 	if err != nil {
-		return ctx.String(400, err.Error())
+		return
 	}
 
-	extensions := make([]string, 3)
-	valid, err := validate(fragmentType)
-	if err != nil {
-		return ctx.String(400, err.Error())
+	filter = models.FragmentFilter{RejectIDs: []int{rejectID}, Type: ctx.Param("datatype")}
+	filter.Source = models.Source{
+		Repo:     ctx.FormValue("repo"),
+		Owner:    ctx.FormValue("owner"),
+		Path:     ctx.FormValue("path"),
+		Language: ctx.FormValue("language"),
+		Keyword:  ctx.FormValue("keyword"),
 	}
+	return
+}
 
-	if !valid {
-		return ctx.String(400, "wrong data type")
+//getFragments : page of fragments, sort is one of id, score, time, repo, owner, path, language, keyword prefixed with "-" for descending order
+func getFragments(ctx echo.Context) (err error) {
+	filter, err := fragmentFilter(ctx)
+	if err != nil {
+		return ctx.String(400, err.Error())
 	}
-
-	filterQuery := "AND type='" + fragmentType + "' "
-	extensions = append(extensions, filterQuery)
+	filter.Sort = ctx.FormValue("sort")
 
 	limitParam := ctx.FormValue("limit")
 	if limitParam != "" {
-		_, err := strconv.Atoi(limitParam)
+		filter.Limit, err = strconv.Atoi(limitParam)
 		if err != nil {
 			return ctx.String(400, err.Error())
 		}
-		limitQuery := "LIMIT " + limitParam + " "
-		extensions = append(extensions, limitQuery)
 	}
 
 	offsetParam := ctx.FormValue("offset")
 	if offsetParam != "" {
-		_, err := strconv.Atoi(offsetParam)
+		filter.Offset, err = strconv.Atoi(offsetParam)
 		if err != nil {
 			return ctx.String(400, err.Error())
 		}
-
-		offsetQuery := "OFFSET " + offsetParam
-		extensions = append(extensions, offsetQuery)
 	}
 
 	manager := ctx.(Context).backend.DBManager
-	fragments, err := manager.SelectTextFragment("reject_id", rejectID, extensions...)
-
-	for i := range fragments {
-		alteredKeywords := make([][]int, 0, len(fragments[i].Keywords))
-		fragmentKeywords := fragments[i].Keywords
-		for _, kwIndices := range fragmentKeywords {
-			frag := fragment.Fragment{Offset: kwIndices[0], Length: kwIndices[1]}
-			frag.ConvertToRunes(fragments[i].Text)
-			alteredKeywords = append(alteredKeywords, []int{frag.Offset, frag.Offset + frag.Length})
-		}
-
-		fragments[i].Keywords = alteredKeywords
-	}
+	fragments, err := manager.SelectTextFragments(filter)
 	if err != nil {
 		return ctx.String(500, err.Error())
 	}
 
+	for i := range fragments {
+		convertKeywords(&fragments[i])
+	}
+
 	reportJSON, err := json.Marshal(fragments)
 	if err != nil {
 		return ctx.String(500, err.Error())
@@ -97,28 +92,26 @@ func getFragments(ctx echo.Context) (err error) {
 	return ctx.JSONBlob(200, reportJSON)
 }
 
-func getFragmentCount(ctx echo.Context) (err error) {
-	fragmentType := ctx.Param("datatype")
-	rejectID, err := strconv.Atoi(ctx.Param("status"))
-	if err != nil {
-		return ctx.String(400, err.Error())
+//convertKeywords : byte offsets & lengths of keywords to rune ranges used by the frontend
+func convertKeywords(textFragment *models.TextFragment) {
+	alteredKeywords := make([][]int, 0, len(textFragment.Keywords))
+	for _, kwIndices := range textFragment.Keywords {
+		frag := fragment.Fragment{Offset: kwIndices[0], Length: kwIndices[1]}
+		frag.ConvertToRunes(textFragment.Text)
+		alteredKeywords = append(alteredKeywords, []int{frag.Offset, frag.Offset + frag.Length})
 	}
 
-	extensions := make([]string, 3)
-	valid, err := validate(fragmentType)
+	textFragment.Keywords = alteredKeywords
+}
+
+func getFragmentCount(ctx echo.Context) (err error) {
+	filter, err := fragmentFilter(ctx)
 	if err != nil {
 		return ctx.String(400, err.Error())
 	}
 
-	if !valid {
-		return ctx.String(400, "wrong data type")
-	}
-
-	filterQuery := "AND type='" + fragmentType + "' "
-	extensions = append(extensions, filterQuery)
-
 	manager := ctx.(Context).backend.DBManager
-	count, err := manager.CountTextFragments("reject_id", rejectID, extensions...)
+	count, err := manager.CountTextFragments(filter)
 
 	if err != nil {
 		return ctx.String(400, err.Error())
@@ -128,7 +121,7 @@ func getFragmentCount(ctx echo.Context) (err error) {
 		Count    int    `json:"count"`
 		Type     string `json:"type"`
 		RejectID int    `json:"reject_id"`
-	}{count, fragmentType, rejectID})
+	}{count, filter.Type, filter.RejectIDs[0]})
 }
 func getFragmentInfo(ctx echo.Context) (err error) {
 	fragID, err := strconv.Atoi(ctx.Param("frag_id"))
@@ -138,17 +131,15 @@ func getFragmentInfo(ctx echo.Context) (err error) {
 	}
 
 	manager := ctx.(Context).backend.DBManager
-	frags, err := manager.SelectTextFragment("id", fragID)
+	frag, err := manager.SelectTextFragmentByID(fragID)
 
+	if err == sql.ErrNoRows {
+		return ctx.String(404, fmt.Sprintf("There is no fragment with id: %d", fragID))
+	}
 	if err != nil {
 		return ctx.String(500, err.Error())
-
-	}
-	if len(frags) != 1 {
-		return ctx.String(500, fmt.Sprintf("There must be exactly one fragment with id: %d; got %d", fragID, len(frags)))
 	}
 
-	frag := frags[0]
 	reportID := frag.ReportID
 
 	report, err := manager.SelectReportByID(reportID)
@@ -171,50 +162,65 @@ func markFragment(ctx echo.Context) (err error) {
 	}
 
 	manager := ctx.(Context).backend.DBManager
-	frags, err := manager.SelectTextFragment("id", fragID)
+	frag, err := manager.SelectTextFragmentByID(fragID)
 
+	if err == sql.ErrNoRows {
+		return ctx.String(404, fmt.Sprintf("There is no fragment with id: %d", fragID))
+	}
 	if err != nil {
 		return ctx.String(500, err.Error())
-
-	}
-	if len(frags) != 1 {
-		return ctx.String(500, fmt.Sprintf("There must be exactly one fragment with id: %d; got %d", fragID, len(frags)))
 	}
 
-	frag := frags[0]
 	reportID := frag.ReportID
+	report, err := manager.SelectReportByID(reportID)
+	if err != nil && err != sql.ErrNoRows {
+		return ctx.String(500, err.Error())
+	}
 
 	err = manager.UpdateTextFragmentRejectID(frag.ID, rejectID)
 	if err != nil {
 		return ctx.String(500, err.Error())
 	}
+	audit(ctx, "fragment.mark", "fragment", []int{fragID}, frag.RejectID, rejectID)
 
 	if rejectID == models.RULEVERIFIED {
-		frags, err := manager.SelectTextFragment("report_id", reportID)
+		frags, err := manager.SelectTextFragments(models.FragmentFilter{ReportID: reportID})
 		if err != nil {
 			return ctx.String(500, err.Error())
 		}
 
+		removed := make([]int, 0, len(frags))
 		for _, f := range frags {
 			if f.ID != fragID && f.RejectID != models.RULEMANUAL {
 				err = manager.UpdateTextFragmentRejectID(f.ID, models.RULEAUTOREMOVED)
 				if err != nil {
 					return ctx.String(500, err.Error())
 				}
+				removed = append(removed, f.ID)
 			}
 		}
 
+		if len(removed) > 0 {
+			audit(ctx, "fragment.autoremove", "fragment", removed, nil, models.RULEAUTOREMOVED)
+		}
+
 		manager.UpdateReportStatus(reportID, stage.VALIDATED)
+		audit(ctx, "report.status", "report", []int{reportID}, report.Status, stage.VALIDATED)
 
 		timestamp := int(time.Now().Unix())
 		manager.UpdateReportTime(reportID, timestamp)
 		return ctx.String(200, "OK")
 	}
 
-	count, err := manager.CountTextFragments("reject_id", models.RULENONE)
+	count, err := manager.CountTextFragments(models.FragmentFilter{ReportID: reportID, RejectIDs: []int{models.RULENONE}})
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
 
 	if count == 0 {
 		manager.UpdateReportStatus(reportID, stage.CLOSED)
+		audit(ctx, "report.status", "report", []int{reportID}, report.Status, stage.CLOSED)
+
 		timestamp := int(time.Now().Unix())
 		manager.UpdateReportTime(reportID, timestamp)
 	}
@@ -256,6 +262,17 @@ func updateSettings(ctx echo.Context) (err error) {
 		return ctx.String(400, err.Error())
 	}
 
+	err = scheduler.Validate(updated.LeakGlobals.Schedules)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	err = stage.ValidateSettings(updated.LeakGlobals.Stages)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	before := currentSettings()
 	if updated.AdminCredentials.Password != "" {
 		shaHash := sha1.New().Sum([]byte(updated.AdminCredentials.Password))
 		utils.Settings.AdminCredentials.Password = fmt.Sprintf("%x", shaHash)
@@ -268,6 +285,22 @@ func updateSettings(ctx echo.Context) (err error) {
 	utils.Settings.Github.Langs = updated.Github.Langs
 	utils.Settings.Github.Tokens = updated.Github.Tokens
 
+	//removed tokens are not used by running tasks anymore, new ones are used at once
+	tokens.Default()
+
+	//scheduler picks up changed schedules on its next tick
+	if updated.LeakGlobals.Schedules != nil {
+		utils.Settings.LeakGlobals.Schedules = updated.LeakGlobals.Schedules
+		if sched := ctx.(Context).backend.Scheduler; sched != nil {
+			sched.SetSchedules(updated.LeakGlobals.Schedules)
+		}
+	}
+
+	//stages read their options when they start, running tasks keep the old ones
+	if updated.LeakGlobals.Stages != nil {
+		utils.Settings.LeakGlobals.Stages = updated.LeakGlobals.Stages
+	}
+
 	for keyword := range utils.Settings.LeakGlobals.Keywords {
 		if _, ok := updated.LeakGlobals.Keywords[keyword]; !ok {
 			kw := utils.Settings.LeakGlobals.Keywords[keyword]
@@ -293,6 +326,10 @@ func updateSettings(ctx echo.Context) (err error) {
 		}
 	}
 
+	after := currentSettings()
+	after.PasswordChanged = updated.AdminCredentials.Password != ""
+	audit(ctx, "settings.update", "settings", nil, before, after)
+
 	yamlSettings, err := yaml.Marshal(utils.Settings)
 
 	if err != nil {
@@ -303,16 +340,17 @@ func updateSettings(ctx echo.Context) (err error) {
 	return ctx.String(200, "OK")
 }
 
-func validate(text string) (bool, error) {
-	alphabetic, err := regexp.Compile("[a-zA-Z]+")
-	return alphabetic.Match([]byte(text)), err
-}
-
+//startAllTasks : start the search tasks, the endpoint is called by the external cron
 func startAllTasks(ctx echo.Context) (err error) {
-	for task := range ctx.(Context).queues {
-		wp := ctx.(Context).queues[task]
-		utils.RunTask(wp)
+	tasks := make([]string, 0, len(ctx.(Context).backend.AllTasks))
+	for _, task := range ctx.(Context).backend.AllTasks {
+		tm, ok := ctx.(Context).queues[task]
+		if ok && tm.Start() {
+			tasks = append(tasks, task)
+		}
 	}
+
+	audit(ctx, "task.start_all", "task", nil, nil, tasks)
 	return ctx.String(200, "OK")
 }
 
@@ -320,42 +358,43 @@ func taskManager(ctx echo.Context) (err error) {
 	task := ctx.Param("task")
 	state := ctx.Param("state")
 
-	var valid bool
-
-	valid, err = validate(task)
-	if err != nil {
-		return ctx.String(500, err.Error())
-	}
-	if !valid {
-		return ctx.String(400, "Invalid task fromat")
-	}
-
-	valid, err = validate(state)
-	if err != nil {
-		return ctx.String(500, err.Error())
-	}
-	if !valid {
-		return ctx.String(400, "Invalid state fromat")
-	}
-
 	if _, ok := ctx.(Context).queues[task]; !ok {
 		return ctx.String(400, "Task not found!")
 	}
 
-	wp := ctx.(Context).queues[task]
+	tm := ctx.(Context).queues[task]
 
 	switch state {
 	case "info":
-		return ctx.String(200, (*wp).Status)
+		return ctx.String(200, tm.Status())
 
 	case "start":
-		utils.RunTask(wp)
+		status := tm.Status()
+		if tm.Start() {
+			audit(ctx, "task.start", "task", nil, status, task)
+		}
 		return ctx.String(200, "OK")
 
+	//the task is cancelling until it returns, its status shows when it's done
 	case "end":
-		utils.EndTask(wp)
+		status := tm.Status()
+		if tm.Stop() {
+			audit(ctx, "task.end", "task", nil, status, task)
+		}
 		return ctx.String(200, "OK")
 
+	//requests of the last run of the task stage: done, pending & failed
+	case "progress":
+		manager := ctx.(Context).backend.DBManager
+		progress, err := manager.SelectQueueProgress(task)
+		if err == sql.ErrNoRows {
+			return ctx.String(404, "No runs of the task")
+		}
+
+		if err != nil {
+			return ctx.String(500, err.Error())
+		}
+		return ctx.JSON(200, progress)
 	}
 
 	return ctx.String(400, "Unknown state!")
@@ -395,9 +434,7 @@ func basicAuthRequired(next echo.HandlerFunc) echo.HandlerFunc {
 
 func getReportedEvents(ctx echo.Context) (err error) {
 	manager := ctx.(Context).backend.DBManager
-	timestamp := ctx.QueryParam("timestamp")
-
-	_, err = strconv.Atoi(timestamp)
+	timestamp, err := strconv.Atoi(ctx.QueryParam("timestamp"))
 	if err != nil {
 		return ctx.String(400, "Invalid timestamp")
 	}
@@ -408,7 +445,7 @@ func getReportedEvents(ctx echo.Context) (err error) {
 	}
 	reports := make([]models.Report, 0, 128)
 	for _, reportType := range reportTypes {
-		typedReports, err := manager.SelectReportByStatus(reportType, stage.VALIDATED, "AND time > "+timestamp)
+		typedReports, err := manager.SelectReports(models.ReportFilter{Type: reportType, Status: stage.VALIDATED, Since: timestamp})
 		if err != nil {
 			return ctx.String(500, err.Error())
 		}
@@ -426,7 +463,6 @@ func getReportedEvents(ctx echo.Context) (err error) {
 
 func getNewReportCount(ctx echo.Context) (err error) {
 	manager := ctx.(Context).backend.DBManager
-	timestamp := ctx.QueryParam("timestamp")
 	isJSONParam := ctx.QueryParam("json")
 	isJSON := false
 
@@ -434,7 +470,7 @@ func getNewReportCount(ctx echo.Context) (err error) {
 		isJSON = true
 	}
 
-	_, err = strconv.Atoi(timestamp)
+	timestamp, err := strconv.Atoi(ctx.QueryParam("timestamp"))
 	if err != nil {
 		return ctx.String(400, "Invalid timestamp")
 	}
@@ -446,7 +482,7 @@ func getNewReportCount(ctx echo.Context) (err error) {
 
 	result := make(map[string]int, 10)
 	for _, reportType := range reportTypes {
-		count, err := manager.CountReports(reportType, "AND time > "+timestamp)
+		count, err := manager.CountReports(models.ReportFilter{Type: reportType, Since: timestamp})
 		if err != nil {
 			return ctx.String(500, err.Error())
 		}
@@ -26,8 +26,12 @@ func handleLogin(c echo.Context) error {
 		if err := sess.Save(c.Request(), c.Response()); err != nil {
 			return c.Render(http.StatusUnprocessableEntity, "login.html", "error")
 		}
+
+		auditAs(c, login, "login", "session", nil, nil, nil)
 		return c.Redirect(http.StatusFound, "/")
 	}
+
+	auditAs(c, login, "login.failed", "session", nil, nil, nil)
 	return c.Render(http.StatusOK, "login.html",
 		struct {
 			Error string
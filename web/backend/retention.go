@@ -0,0 +1,19 @@
+package backend
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/retention"
+)
+
+//getRetentionDryRun : what the maintenance task would purge now
+func getRetentionDryRun(ctx echo.Context) (err error) {
+	result, err := retention.RunOnce(ctx.(Context).backend.DBManager, true)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	if result.Items == nil {
+		result.Items = []retention.Item{}
+	}
+	return ctx.JSON(200, result)
+}
@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"io/ioutil"
+
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/models"
+	"github.com/megamon/core/leaks/ruleset"
+	"github.com/megamon/core/leaks/suggest"
+)
+
+func exportRules(ctx echo.Context) (err error) {
+	format := ctx.QueryParam("format")
+	if format == "" {
+		format = ruleset.FormatYAML
+	}
+
+	set, err := ruleset.Load(ctx.(Context).backend.DBManager)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	data, err := ruleset.Marshal(format, set)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	contentType := "application/x-yaml"
+	if format == ruleset.FormatGitleaks {
+		contentType = "application/toml"
+	}
+
+	return ctx.Blob(200, contentType, data)
+}
+
+func importRules(ctx echo.Context) (err error) {
+	format := ctx.QueryParam("format")
+	if format == "" {
+		format = ruleset.FormatYAML
+	}
+
+	data, err := ioutil.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	set, err := ruleset.Parse(format, data)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	summary, err := ruleset.Apply(ctx.(Context).backend.DBManager, set, ctx.QueryParam("conflict"))
+	if err != nil {
+		if _, ok := err.(*ruleset.ConflictError); ok {
+			return ctx.String(409, err.Error())
+		}
+		return ctx.String(500, err.Error())
+	}
+
+	audit(ctx, "rules.import", "rule", nil, nil, summary)
+	return ctx.JSON(200, summary)
+}
+
+func getRuleSuggestions(ctx echo.Context) (err error) {
+	manager := ctx.(Context).backend.DBManager
+	keywords, err := manager.SelectAllKeywords()
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	rules, err := manager.SelectAllRules()
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	samples, err := suggest.Load(manager, keywords, suggest.DefaultOptions)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	suggestions := suggest.Suggest(samples, rules, suggest.DefaultOptions)
+	if suggestions == nil {
+		suggestions = []suggest.Suggestion{}
+	}
+	return ctx.JSON(200, suggestions)
+}
+
+func acceptRuleSuggestion(ctx echo.Context) (err error) {
+	var suggestion suggest.Suggestion
+	err = ctx.Bind(&suggestion)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	rule := models.RejectRule{Name: suggestion.Name, Rule: suggestion.Rule, Kind: suggestion.Kind}
+	if err = rule.Compile(); err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	rule.ID, err = ctx.(Context).backend.DBManager.InsertRule(rule)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+	audit(ctx, "rules.add", "rule", []int{rule.ID}, nil, rule)
+
+	return ctx.JSON(200, rule)
+}
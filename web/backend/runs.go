@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/models"
+)
+
+//getTaskRuns : runs of the task (all tasks if empty) & their counters, the latest first
+//status, limit & offset form values narrow the selection
+func getTaskRuns(ctx echo.Context) (err error) {
+	filter := models.TaskRunFilter{Task: ctx.FormValue("task"), Status: ctx.FormValue("status")}
+	filter.Sort = "-id"
+
+	params := map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset}
+	for name, value := range params {
+		*value, err = intParam(ctx, name)
+		if err != nil {
+			return ctx.String(400, err.Error())
+		}
+	}
+
+	manager := ctx.(Context).backend.DBManager
+	total, err := manager.CountTaskRuns(filter)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	runs, err := manager.SelectTaskRuns(filter)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	if runs == nil {
+		runs = []models.TaskRun{}
+	}
+
+	return ctx.JSON(200, struct {
+		Total int              `json:"total"`
+		Runs  []models.TaskRun `json:"runs"`
+	}{total, runs})
+}
+
+//getTaskRun : run with its log lines
+func getTaskRun(ctx echo.Context) (err error) {
+	runID, err := strconv.Atoi(ctx.Param("run_id"))
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	manager := ctx.(Context).backend.DBManager
+	run, err := manager.SelectTaskRunByID(runID)
+	if err == sql.ErrNoRows {
+		return ctx.String(404, "Run not found")
+	}
+
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	logs, err := manager.SelectTaskRunLogs(runID)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	if logs == nil {
+		logs = []models.TaskRunLog{}
+	}
+
+	return ctx.JSON(200, struct {
+		models.TaskRun
+		Logs []models.TaskRunLog `json:"logs"`
+	}{run, logs})
+}
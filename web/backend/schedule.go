@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/models"
+)
+
+//taskSchedule : schedule of the task with its current status
+type taskSchedule struct {
+	models.TaskSchedule
+	Status string `json:"status"`
+}
+
+//getSchedules : schedules of all tasks, task never scheduled has empty cron
+func getSchedules(ctx echo.Context) (err error) {
+	schedules, err := ctx.(Context).backend.DBManager.SelectTaskSchedules()
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	byTask := make(map[string]models.TaskSchedule, len(schedules))
+	for _, schedule := range schedules {
+		byTask[schedule.Task] = schedule
+	}
+
+	result := make([]taskSchedule, 0, len(ctx.(Context).queues))
+	for task, tm := range ctx.(Context).queues {
+		schedule, ok := byTask[task]
+		if !ok {
+			schedule.Task = task
+		}
+		result = append(result, taskSchedule{schedule, tm.Status()})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Task < result[j].Task
+	})
+	return ctx.JSON(200, result)
+}
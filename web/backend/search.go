@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/models"
+)
+
+//intParam : optional integer form value, 0 if missing
+func intParam(ctx echo.Context, name string) (value int, err error) {
+	param := ctx.FormValue(name)
+	if param == "" {
+		return
+	}
+	return strconv.Atoi(param)
+}
+
+//getSearchResults : fragments matching the query in content, path, repository or owner
+//status is a comma separated list of reject ids, since & until are unix timestamps of the report,
+//sort is one of id, report_id, score, time prefixed with "-" for descending order
+func getSearchResults(ctx echo.Context) (err error) {
+	filter := models.SearchFilter{Query: ctx.FormValue("q"), Type: ctx.FormValue("type")}
+	filter.Sort = ctx.FormValue("sort")
+
+	if status := ctx.FormValue("status"); status != "" {
+		for _, value := range strings.Split(status, ",") {
+			rejectID, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return ctx.String(400, err.Error())
+			}
+			filter.RejectIDs = append(filter.RejectIDs, rejectID)
+		}
+	}
+
+	params := map[string]*int{"since": &filter.Since, "until": &filter.Until, "limit": &filter.Limit, "offset": &filter.Offset}
+	for name, value := range params {
+		*value, err = intParam(ctx, name)
+		if err != nil {
+			return ctx.String(400, err.Error())
+		}
+	}
+
+	if len([]rune(strings.TrimSpace(filter.Query))) < models.MinTextQuery {
+		return ctx.String(400, "search query is too short")
+	}
+
+	manager := ctx.(Context).backend.DBManager
+	results, total, err := manager.SearchTextFragments(filter)
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	if results == nil {
+		results = []models.SearchResult{}
+	}
+
+	for i := range results {
+		convertKeywords(&results[i].TextFragment)
+	}
+
+	return ctx.JSON(200, struct {
+		Total   int                   `json:"total"`
+		Results []models.SearchResult `json:"results"`
+	}{total, results})
+}
+
+//getSearchCoverage : share of the results of each keyword reached by the last github search
+func getSearchCoverage(ctx echo.Context) (err error) {
+	coverage, err := ctx.(Context).backend.DBManager.SelectCoverage()
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	if coverage == nil {
+		coverage = []models.Coverage{}
+	}
+	return ctx.JSON(200, coverage)
+}
@@ -0,0 +1,22 @@
+package backend
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/stage"
+	"github.com/megamon/core/utils"
+)
+
+//stageOptions : effective options of the stage, they apply from its next run
+type stageOptions struct {
+	Stage   string              `json:"stage"`
+	Options utils.StageSettings `json:"options"`
+}
+
+//getStages : options of all configurable stages, settings with defaults applied
+func getStages(ctx echo.Context) (err error) {
+	result := make([]stageOptions, 0, len(stage.Names))
+	for _, name := range stage.Names {
+		result = append(result, stageOptions{name, stage.OptionsOf(name).Settings()})
+	}
+	return ctx.JSON(200, result)
+}
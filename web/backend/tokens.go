@@ -0,0 +1,11 @@
+package backend
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/megamon/core/leaks/tokens"
+)
+
+//getTokenHealth : quota & status of github tokens, only masks of tokens are returned
+func getTokenHealth(ctx echo.Context) (err error) {
+	return ctx.JSON(200, tokens.Default().Health())
+}
@@ -5,6 +5,10 @@ HighlightedReport = Vue.component('h-report', {
         var ind  = this.fragment.keywords
         var rootChilds = []
 
+        if(ind.length == 0){
+            return new_el("div", {class:"text-wrap"}, text)
+        }
+
         rootChilds.push(new_el("span", {}, text.substring(0, ind[0])))
         for(var i=0 ;i < ind.length; i++)
         {
@@ -32,6 +36,10 @@ HeadNavigation = Vue.component('h-nav', {
                     name:"Gist",
                     path:"/gist"
                 },
+                {
+                    name:"Search",
+                    path:"/search"
+                },
                 {
                     name:"Settings",
                     path:"/settings"
@@ -39,6 +47,14 @@ HeadNavigation = Vue.component('h-nav', {
                 {
                     name: "Controls",
                     path: "/controls"
+                },
+                {
+                    name: "Runs",
+                    path: "/runs"
+                },
+                {
+                    name: "Audit",
+                    path: "/audit"
                 },]
             }
           }
@@ -183,10 +199,46 @@ Controls = Vue.component('controls',{
         return{
             statuses: {"github":"unknown", 
                        "gist"  :"unknown"},
-            polling : ''
+            polling : '',
+            report : null,
+            retention : null,
+            coverage : []
         }
     },
     methods:{
+        getCoverage: function(){
+            axios.get("/leaks/api/search/coverage")
+                .then(response => {
+                    if(response.status == 200){
+                        this.coverage = response.data
+                    }
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        getRetentionDryRun: function(){
+            axios.get("/leaks/api/retention/dryrun")
+                .then(response => {
+                    if(response.status == 200){
+                        this.retention = response.data
+                    }
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        getClassifierReport: function(){
+            axios.get("/leaks/api/classifier/report")
+                .then(response => {
+                    if(response.status == 200){
+                        this.report = response.data
+                    }
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
         getTasksAvailable: function(){
             var requestURI = "/leaks/api/task/available"
             axios.get(requestURI)
@@ -251,6 +303,8 @@ Controls = Vue.component('controls',{
     },
     created: function() {
         this.getTasksAvailable()
+        this.getClassifierReport()
+        this.getCoverage()
         this.polling = setInterval(this.updateStatuses(), 15000)
     },
     beforeDestroy: function(){
@@ -272,6 +326,41 @@ Controls = Vue.component('controls',{
             </td>
         </tr>
     </table>
+    <div v-if="report">
+        <h3>classifier report</h3>
+        <ul>
+            <li><b>Samples:</b> {{report.samples}} ({{report.leaks}} verified)</li>
+            <li><b>Precision:</b> {{report.precision.toFixed(3)}}</li>
+            <li><b>Recall:</b> {{report.recall.toFixed(3)}}</li>
+            <li><b>F1:</b> {{report.f1.toFixed(3)}}</li>
+            <li><b>Trained:</b> {{new Date(report.created * 1000).toLocaleString()}}</li>
+        </ul>
+    </div>
+    <div v-if="coverage.length">
+        <h3>search coverage</h3>
+        <table class="table table-sm">
+        <thead><tr><th>Keyword</th><th>Results</th><th>Reachable</th><th>Partitions</th><th>Truncated</th><th>Coverage</th></tr></thead>
+        <tbody>
+            <tr v-for="c in coverage" v-bind:key="c.keyword">
+                <td>{{c.keyword}}</td>
+                <td>{{c.total}}</td>
+                <td>{{c.retrievable}}</td>
+                <td>{{c.partitions}}</td>
+                <td>{{c.truncated}}</td>
+                <td>{{(c.ratio * 100).toFixed(1)}}%</td>
+            </tr>
+        </tbody>
+        </table>
+    </div>
+    <div>
+        <h3>retention</h3>
+        <button type="button" class="btn btn-outline-primary" v-on:click="getRetentionDryRun()"> dry run </button>
+        <ul v-if="retention">
+            <li><b>Contents:</b> {{retention.contents}}</li>
+            <li><b>Fragments:</b> {{retention.fragments}}</li>
+            <li><b>Reports:</b> {{retention.reports}}</li>
+        </ul>
+    </div>
     </div>
     `
 })
@@ -288,10 +377,135 @@ Settings = Vue.component('settings', {
             selected: "",
             checkbox: false,
             rules:[],
-            keywords:[]
+            keywords:[],
+            allowlist:[],
+            allowlistTypes:["repo", "owner", "sha", "path"],
+            allowlistEntry:{type: "repo", value: ""},
+            suggestions:[],
+            schedules:[],
+            tokenHealth:[],
+            stages:[],
+            stageFields:[
+                {key: "request_workers", name: "Request workers"},
+                {key: "process_workers", name: "Process workers"},
+                {key: "fragmentize_workers", name: "Fragmentize workers"},
+                {key: "context_len", name: "Context length"},
+                {key: "max_context_len", name: "Max context length"},
+                {key: "max_retries", name: "Max retries"},
+                {key: "backoff", name: "Backoff, s"}
+            ]
         }
     },
     methods:{
+        getTokenHealth: function(){
+            axios.get('/leaks/api/tokens')
+                .then(response => {
+                    this.tokenHealth = response.data
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        getStages: function(){
+            axios.get('/leaks/api/stages')
+                .then(response => {
+                    this.stages = response.data
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        stageOption: function(stage, key){
+            var stages = this.settings.globals.stages
+            if(stages && stage in stages && stages[stage][key]){
+                return stages[stage][key]
+            }
+            return ""
+        },
+        setStageOption: function(stage, key, value){
+            if(!this.settings.globals.stages){
+                this.$set(this.settings.globals, "stages", {})
+            }
+            if(!(stage in this.settings.globals.stages)){
+                this.$set(this.settings.globals.stages, stage, {})
+            }
+            this.$set(this.settings.globals.stages[stage], key, parseInt(value) || 0)
+        },
+        getSchedules: function(){
+            axios.get('/leaks/api/schedules')
+                .then(response => {
+                    this.schedules = response.data
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        scheduleOf: function(task){
+            var schedules = this.settings.globals.schedules
+            if(schedules && task in schedules){
+                return schedules[task]
+            }
+            return ""
+        },
+        setSchedule: function(task, spec){
+            if(!this.settings.globals.schedules){
+                this.$set(this.settings.globals, "schedules", {})
+            }
+            this.$set(this.settings.globals.schedules, task, spec.trim())
+        },
+        formatTime: function(timestamp){
+            if(!timestamp){
+                return "-"
+            }
+            return new Date(timestamp * 1000).toLocaleString()
+        },
+        getSuggestions: function(){
+            axios.get('/leaks/api/rules/suggestions')
+                .then(response => {
+                    this.suggestions = response.data
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        acceptSuggestion: function(suggestion){
+            axios.post('/leaks/api/rules/suggestions/accept', suggestion)
+                .then(response => {
+                    this.rules.push(response.data.rule)
+                    this.suggestions = this.suggestions.filter(s => s.rule != suggestion.rule)
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        getAllowlist: function(){
+            axios.get('/leaks/api/allowlist')
+                .then(response => {
+                    this.allowlist = response.data
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        addAllowlistEntry: function(){
+            axios.post('/leaks/api/allowlist', this.allowlistEntry)
+                .then(response => {
+                    this.allowlist.push(response.data)
+                    this.allowlistEntry.value = ""
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        removeAllowlistEntry: function(entryId){
+            axios.delete('/leaks/api/allowlist/' + entryId)
+                .then(response => {
+                    this.allowlist = this.allowlist.filter(entry => entry.id != entryId)
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
         getSettings: function(){
             var requestURI = '/leaks/api/settings'
             axios.get(requestURI)
@@ -395,10 +609,22 @@ Settings = Vue.component('settings', {
             console.log(this.settings)
             var requestURI = "/leaks/api/settings"
             axios.post(requestURI, this.settings)
+                .then(response => {
+                    this.getSchedules()
+                    this.getStages()
+                    this.getTokenHealth()
+                })
+                .catch(error => {
+                    console.log(error)
+                })
         },
     },
     created : function(){
         this.getSettings()
+        this.getAllowlist()
+        this.getSchedules()
+        this.getStages()
+        this.getTokenHealth()
     },
     template: "#settings-template"
 })
@@ -426,6 +652,22 @@ Fragments = Vue.component('r-fragments', {
                 {name: "Autoremoved", value: "3"},
             ],
             availableLimits:[10, 20, 50, 100],
+            sortOrders:[
+                {name: "Newest",     value: "-id"},
+                {name: "Score",      value: "-score"},
+                {name: "Repository", value: "repo"},
+                {name: "Owner",      value: "owner"},
+                {name: "Path",       value: "path"},
+                {name: "Language",   value: "language"},
+                {name: "Keyword",    value: "keyword"},
+            ],
+            source: {
+                repo: "",
+                owner: "",
+                language: "",
+                keyword: ""
+            },
+            sort: "-id",
             reportStatus: "0",
             limit: 10
         } 
@@ -436,9 +678,16 @@ Fragments = Vue.component('r-fragments', {
             updatePage: function () {
             offset = this.pagination.currentPage*this.limit
            
+            var params = {limit: this.limit, offset: offset, sort: this.sort}
+            for(var field in this.source){
+                if(this.source[field] != ""){
+                    params[field] = this.source[field]
+                }
+            }
+
             //Get fragments
-            var requestURI = '/leaks/api/report/frags/' + this.pagetype + "/" +  this.reportStatus + '?limit=' + this.limit + '&offset=' + offset
-            axios.get(requestURI)
+            var requestURI = '/leaks/api/report/frags/' + this.pagetype + "/" +  this.reportStatus
+            axios.get(requestURI, {params: params})
                 .then(response => {
                     this.fragments = response.data
                 })
@@ -447,8 +696,8 @@ Fragments = Vue.component('r-fragments', {
                 })
 
             //Get fragments count
-            requestURI = '/leaks/api/report/count/' + this.pagetype + "/" +  this.reportStatus + '?limit=' + this.limit + '&offset=' + offset
-            axios.get(requestURI)
+            requestURI = '/leaks/api/report/count/' + this.pagetype + "/" +  this.reportStatus
+            axios.get(requestURI, {params: params})
                 .then(response => {
                     var nResults = response.data["count"]
                     this.updatePagination(nResults)
@@ -595,14 +844,234 @@ Fragments = Vue.component('r-fragments', {
     },
 })
 
+Search = Vue.component('s-fragments', {
+    extends: Fragments,
+
+    data: function() {
+        return {
+            query: "",
+            searchType: "",
+            reportStatuses:[
+                {name: "Any",    value: ""},
+                {name: "New",    value: "0"},
+                {name: "Closed", value: "1"},
+                {name: "Verified",    value: "2"},
+                {name: "Autoremoved", value: "3"},
+            ],
+            searchTypes:[
+                {name: "Any",    value: ""},
+                {name: "Github", value: "github"},
+                {name: "Gist",   value: "gist"},
+            ],
+            reportStatus: "",
+            since: "",
+            until: "",
+            total: 0
+        }
+    },
+
+    template: "#search-template",
+    methods: {
+        search: function(){
+            this.pagination.currentPage = 0
+            this.updatePage()
+        },
+        updatePage: function(){
+            if(this.query.trim().length < 3){
+                return
+            }
+
+            var params = {
+                q: this.query,
+                limit: this.limit,
+                offset: this.pagination.currentPage*this.limit
+            }
+            if(this.reportStatus != ""){
+                params.status = this.reportStatus
+            }
+            if(this.searchType != ""){
+                params.type = this.searchType
+            }
+            if(this.since != ""){
+                params.since = Math.floor(new Date(this.since).getTime() / 1000)
+            }
+            if(this.until != ""){
+                params.until = Math.floor(new Date(this.until).getTime() / 1000) + 86400
+            }
+
+            axios.get("/leaks/api/search", {params: params})
+                .then(response => {
+                    this.fragments = response.data.results
+                    this.total = response.data.total
+                    this.updatePagination(this.total)
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+    },
+})
+
+Audit = Vue.component('audit-log', {
+    data: function(){
+        return {
+            entries: [],
+            total: 0,
+            filter: {
+                actor: "",
+                action: "",
+                target_id: ""
+            },
+            limit: 50,
+            offset: 0
+        }
+    },
+    computed: {
+        params: function(){
+            var params = {}
+            for(var field in this.filter){
+                if(this.filter[field] != ""){
+                    params[field] = this.filter[field]
+                }
+            }
+            return params
+        },
+    },
+    methods: {
+        update: function(){
+            var params = Object.assign({limit: this.limit, offset: this.offset}, this.params)
+            axios.get("/leaks/api/audit", {params: params})
+                .then(response => {
+                    if(response.status == 200){
+                        this.entries = response.data.entries
+                        this.total = response.data.total
+                    }
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        search: function(){
+            this.offset = 0
+            this.update()
+        },
+        move: function(step){
+            var offset = this.offset + step*this.limit
+            if(offset >= 0 && offset < this.total){
+                this.offset = offset
+                this.update()
+            }
+        },
+        exportURL: function(format){
+            var query = new URLSearchParams(Object.assign({format: format}, this.params))
+            return "/leaks/api/audit/export?" + query.toString()
+        },
+        formatTime: function(timestamp){
+            return new Date(timestamp * 1000).toLocaleString()
+        },
+    },
+    created: function(){
+        this.update()
+    },
+    template: "#audit-template"
+})
+
+Runs = Vue.component('task-runs', {
+    data: function(){
+        return {
+            runs: [],
+            total: 0,
+            task: "",
+            tasks: [],
+            limit: 50,
+            offset: 0,
+            selected: null,
+            polling: ''
+        }
+    },
+    methods: {
+        getTasks: function(){
+            axios.get("/leaks/api/task/available")
+                .then(response => {
+                    if(response.status == 200){
+                        this.tasks = response.data
+                    }
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        update: function(){
+            var params = {limit: this.limit, offset: this.offset}
+            if(this.task != ""){
+                params.task = this.task
+            }
+
+            axios.get("/leaks/api/runs", {params: params})
+                .then(response => {
+                    if(response.status == 200){
+                        this.runs = response.data.runs
+                        this.total = response.data.total
+                    }
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+
+            if(this.selected && this.selected.status == "running"){
+                this.show(this.selected.id)
+            }
+        },
+        search: function(){
+            this.offset = 0
+            this.update()
+        },
+        move: function(step){
+            var offset = this.offset + step*this.limit
+            if(offset >= 0 && offset < this.total){
+                this.offset = offset
+                this.update()
+            }
+        },
+        show: function(runId){
+            axios.get("/leaks/api/runs/" + runId)
+                .then(response => {
+                    if(response.status == 200){
+                        this.selected = response.data
+                    }
+                })
+                .catch(error => {
+                    console.log(error)
+                })
+        },
+        formatTime: function(timestamp){
+            if(!timestamp){
+                return "-"
+            }
+            return new Date(timestamp * 1000).toLocaleString()
+        },
+    },
+    created: function(){
+        this.getTasks()
+        this.update()
+        this.polling = setInterval(this.update, 10000)
+    },
+    beforeDestroy: function(){
+        clearInterval(this.polling)
+    },
+    template: "#runs-template"
+})
 
 const router = new VueRouter({
     routes :[ 
         {path: "/", component:Fragments, props:{pagetype:"github"}},
         {path: "/github", component:Fragments, props:{pagetype:"github"}},
         {path: "/gist",  component:Fragments, props:{pagetype:"gist"}},
+        {path: "/search",  component:Search },
         {path: "/settings",  component:Settings },
         {path: "/controls", component:Controls },
+        {path: "/runs", component:Runs },
+        {path: "/audit", component:Audit },
     ],
     mode: "history"
 })
@@ -618,8 +1087,11 @@ var app = new Vue({
         'pagination-navigation' : Pagination,
         'report-control' : RControl,
         'fragments' : Fragments,
+        'search' : Search,
         'settings' : Settings,
         'controls' : Controls,
+        'task-runs' : Runs,
+        'audit-log' : Audit,
         'v-items' : VItems,
         'v-modal':ModalWindow,
         'f-info':FragmentInfo,